@@ -0,0 +1,828 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package acltest provides an in-memory stand-in for the ACL API as seen
+// through the tsuru service proxy, to be used in tests of the acl plugin and
+// of any tool built on top of the client package.
+package acltest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-api/api/version"
+	"github.com/tsuru/acl-plugin/client"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	DefaultEngine  = "acl-operator"
+	DefaultVersion = "0.0.0"
+
+	ownerAclFromHell = "aclfromhell"
+)
+
+// SyncFunc is called for every engine whenever a rule is synced, returning
+// the engine result, stored as JSON like the ACL API does, or the sync error.
+// It's called without holding any lock, so it may call the Server.
+type SyncFunc func(r types.Rule, engine string) (interface{}, error)
+
+type failure struct {
+	method  string
+	path    string
+	status  int
+	message string
+	times   int
+}
+
+// Server is a fake tsuru API proxying requests to a fake ACL API. Every path
+// used by the plugin is implemented, keeping rules, service instances and
+// sync information in memory:
+//
+//	/1.20/services/{service}/resources/{instance}/rule
+//	/1.20/services/{service}/resources/{instance}/rule/{id}
+//	/services/proxy/service/{service}?callback=/rules
+//	/services/proxy/service/{service}?callback=/rules/sync
+//	/services/proxy/service/{service}?callback=/rules/{id}
+//	/services/proxy/service/{service}?callback=/rules/{id}/sync
+//	/services/proxy/service/{service}?callback=/services
+//	/services/proxy/service/{service}?callback=/apps/{app}/sync
+//
+// The service name in paths is ignored, every service shares the same data.
+// Like the ACL API, rule names are unique among every rule ever stored,
+// including removed ones and the rules expanded for each bound app and job.
+type Server struct {
+	URL string
+
+	server *httptest.Server
+
+	mu        sync.Mutex
+	version   string
+	latency   time.Duration
+	engines   []string
+	autoSync  bool
+	syncFunc  SyncFunc
+	failures  []*failure
+	calls     []string
+	nextID    int
+	instances map[string]*types.ServiceInstance
+	rules     map[string]*types.Rule
+	syncs     map[string]map[string]*types.RuleSyncInfo
+
+	tsuruApps      []client.TsuruApp
+	tsuruPools     []string
+	tsuruInstances map[string][]string
+}
+
+// NewServer starts a new fake server. Callers should call Close when done.
+func NewServer() *Server {
+	s := &Server{
+		version:   DefaultVersion,
+		engines:   []string{DefaultEngine},
+		autoSync:  true,
+		instances: map[string]*types.ServiceInstance{},
+		rules:     map[string]*types.Rule{},
+		syncs:     map[string]map[string]*types.RuleSyncInfo{},
+
+		tsuruInstances: map[string][]string{},
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Client returns an HTTP client configured to talk to the server.
+func (s *Server) Client() *http.Client {
+	return s.server.Client()
+}
+
+// SetVersion sets the value of the ACL API version header sent in every
+// response.
+func (s *Server) SetVersion(v string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version = v
+}
+
+// SetLatency delays every response by d.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// SetEngines sets the engines recording sync information for every synced
+// rule, by default only DefaultEngine is used.
+func (s *Server) SetEngines(engines ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.engines = engines
+}
+
+// SetAutoSync controls whether rules are synced as soon as they're created,
+// which is the default. When disabled rules are only synced when a sync is
+// explicitly requested.
+func (s *Server) SetAutoSync(autoSync bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.autoSync = autoSync
+}
+
+// SetSyncFunc replaces the function used to compute engines sync results.
+func (s *Server) SetSyncFunc(fn SyncFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.syncFunc = fn
+}
+
+// Fail makes requests matching method and pathPattern fail with status and
+// message. Patterns use path.Match syntax against the ACL API path, e.g.
+// "/rules/*/sync" or "/resources/myinstance/rule", or the tsuru API path,
+// e.g. "/1.0/apps/myapp". An empty method matches
+// every method. times limits how many requests fail, 0 means forever.
+func (s *Server) Fail(method, pathPattern string, status int, message string, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, &failure{
+		method:  method,
+		path:    pathPattern,
+		status:  status,
+		message: message,
+		times:   times,
+	})
+}
+
+// ClearFailures removes every failure added with Fail.
+func (s *Server) ClearFailures() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = nil
+}
+
+// Calls returns every request received by the server in the format
+// "METHOD /acl/api/path".
+func (s *Server) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
+
+// AddServiceInstance creates a service instance with the apps in bindApps
+// bound to it.
+func (s *Server) AddServiceInstance(name string, bindApps ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.instances[name] = &types.ServiceInstance{
+		InstanceName: name,
+		BindApps:     bindApps,
+	}
+}
+
+// BindJobs binds jobNames to the service instance name, its rules are
+// expanded for each job like they are for each app.
+func (s *Server) BindJobs(name string, jobNames ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if si, ok := s.instances[name]; ok {
+		si.BindJobs = append(si.BindJobs, jobNames...)
+	}
+}
+
+// AddTsuruApp adds an app in pool to the tsuru API, the pool is added too.
+func (s *Server) AddTsuruApp(name, pool string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tsuruApps = append(s.tsuruApps, client.TsuruApp{Name: name, Pool: pool})
+	s.addTsuruPool(pool)
+}
+
+// AddTsuruPool adds a pool to the tsuru API.
+func (s *Server) AddTsuruPool(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addTsuruPool(name)
+}
+
+// AddTsuruServiceInstance adds an instance of service to the tsuru API, e.g.
+// an rpaas instance.
+func (s *Server) AddTsuruServiceInstance(service, instance string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tsuruInstances[service] = append(s.tsuruInstances[service], instance)
+}
+
+// AddRule stores r as is, as if it was created directly in the ACL API,
+// returning its ID.
+func (s *Server) AddRule(r types.Rule) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.RuleID == "" {
+		r.RuleID = s.newID()
+	}
+	s.rules[r.RuleID] = &r
+	return r.RuleID
+}
+
+// AddSync records a sync attempt of ruleID in engine.
+func (s *Server) AddSync(ruleID, engine string, data types.RuleSyncData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordSync(ruleID, engine, data)
+}
+
+// Rules returns every stored rule, including the expanded rules of service
+// instances, sorted by ID.
+func (s *Server) Rules() []types.Rule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.findRules(nil)
+}
+
+// ServiceInstances returns every service instance sorted by name.
+func (s *Server) ServiceInstances() []types.ServiceInstance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listInstances()
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	latency := s.latency
+	s.mu.Unlock()
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-req.Context().Done():
+			return
+		}
+	}
+
+	if strings.HasPrefix(req.URL.Path, "/1.0/") {
+		s.serveTsuru(w, req)
+		return
+	}
+	method, aclPath, query, ok := translatePath(req)
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set(version.VersionHeader, s.version)
+	s.calls = append(s.calls, method+" "+aclPath)
+	if f := s.matchFailure(method, aclPath); f != nil {
+		writeError(w, f.status, f.message)
+		return
+	}
+
+	var body []byte
+	if req.Body != nil {
+		body, _ = ioutil.ReadAll(req.Body)
+	}
+	status, rsp := s.route(method, aclPath, query, body, req.Header)
+	if err, isErr := rsp.(error); isErr {
+		writeError(w, status, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if rsp != nil {
+		_ = json.NewEncoder(w).Encode(rsp)
+	}
+}
+
+// serveTsuru answers the tsuru API requests listing apps, pools and service
+// instances, and getting each of them by name:
+//
+//	/1.0/apps/{app}
+//	/1.0/pools/{pool}
+//	/1.0/services/{service}/instances/{instance}
+//
+// Empty lists are answered with 204 No Content, as tsuru does. Use Fail
+// with status 403 for the ones the token isn't allowed to see.
+func (s *Server) serveTsuru(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, req.Method+" "+req.URL.Path)
+	if f := s.matchFailure(req.Method, req.URL.Path); f != nil {
+		writeError(w, f.status, f.message)
+		return
+	}
+	if rsp, found, ok := s.tsuruItem(req.URL.Path); ok {
+		if !found {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(rsp)
+		return
+	}
+	var rsp interface{}
+	var count int
+	switch req.URL.Path {
+	case "/1.0/apps":
+		rsp, count = s.tsuruApps, len(s.tsuruApps)
+	case "/1.0/pools":
+		pools := make([]client.TsuruPool, len(s.tsuruPools))
+		for i, p := range s.tsuruPools {
+			pools[i] = client.TsuruPool{Name: p}
+		}
+		rsp, count = pools, len(pools)
+	case "/1.0/services/instances":
+		var services []client.TsuruServiceInstances
+		for service, instances := range s.tsuruInstances {
+			if name := req.URL.Query().Get("service"); name != "" && name != service {
+				continue
+			}
+			services = append(services, client.TsuruServiceInstances{Service: service, Instances: instances})
+		}
+		rsp, count = services, len(services)
+	default:
+		http.NotFound(w, req)
+		return
+	}
+	if count == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(rsp)
+}
+
+// tsuruItem returns the app, pool or service instance named in tsuruPath,
+// ok is false when tsuruPath doesn't get a single item.
+func (s *Server) tsuruItem(tsuruPath string) (rsp interface{}, found bool, ok bool) {
+	parts := strings.Split(strings.Trim(tsuruPath, "/"), "/")
+	switch {
+	case len(parts) == 3 && parts[1] == "apps":
+		for _, a := range s.tsuruApps {
+			if a.Name == parts[2] {
+				return a, true, true
+			}
+		}
+		return nil, false, true
+	case len(parts) == 3 && parts[1] == "pools":
+		for _, p := range s.tsuruPools {
+			if p == parts[2] {
+				return client.TsuruPool{Name: p}, true, true
+			}
+		}
+		return nil, false, true
+	case len(parts) == 5 && parts[1] == "services" && parts[3] == "instances":
+		for _, instance := range s.tsuruInstances[parts[2]] {
+			if instance == parts[4] {
+				return map[string]string{"service": parts[2], "name": instance}, true, true
+			}
+		}
+		return nil, false, true
+	}
+	return nil, false, false
+}
+
+func (s *Server) addTsuruPool(name string) {
+	for _, p := range s.tsuruPools {
+		if p == name {
+			return
+		}
+	}
+	s.tsuruPools = append(s.tsuruPools, name)
+}
+
+// translatePath converts a tsuru API request into the path it would reach
+// in the ACL API.
+func translatePath(req *http.Request) (string, string, url.Values, bool) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) >= 5 && parts[0] == "1.20" && parts[1] == "services" && parts[3] == "resources" {
+		return req.Method, "/" + strings.Join(parts[3:], "/"), req.URL.Query(), true
+	}
+	if len(parts) == 4 && parts[0] == "services" && parts[1] == "proxy" && parts[2] == "service" {
+		callback := req.URL.Query().Get("callback")
+		if callback == "" {
+			return "", "", nil, false
+		}
+		u, err := url.Parse(callback)
+		if err != nil {
+			return "", "", nil, false
+		}
+		query := u.Query()
+		for k, v := range req.URL.Query() {
+			if k != "callback" {
+				query[k] = v
+			}
+		}
+		return req.Method, u.Path, query, true
+	}
+	return "", "", nil, false
+}
+
+func (s *Server) matchFailure(method, aclPath string) *failure {
+	for i, f := range s.failures {
+		if f.method != "" && f.method != method {
+			continue
+		}
+		if matched, _ := path.Match(f.path, aclPath); !matched {
+			continue
+		}
+		if f.times > 0 {
+			f.times--
+			if f.times == 0 {
+				s.failures = append(s.failures[:i], s.failures[i+1:]...)
+			}
+		}
+		return f
+	}
+	return nil
+}
+
+func (s *Server) route(method, aclPath string, query url.Values, body []byte, headers http.Header) (int, interface{}) {
+	parts := strings.Split(strings.Trim(aclPath, "/"), "/")
+	switch {
+	case parts[0] == "resources" && len(parts) == 3 && parts[2] == "rule":
+		switch method {
+		case http.MethodGet:
+			return s.serviceListRules(parts[1])
+		case http.MethodPost:
+			return s.serviceAddRule(parts[1], body, headers)
+		}
+	case parts[0] == "resources" && len(parts) == 4 && parts[2] == "rule" && method == http.MethodDelete:
+		return s.serviceRemoveRule(parts[1], parts[3])
+	case aclPath == "/rules":
+		switch method {
+		case http.MethodGet:
+			return http.StatusOK, s.findRules(query)
+		case http.MethodPost:
+			return s.addRule(body, headers)
+		}
+	case aclPath == "/rules/sync" && method == http.MethodGet:
+		return http.StatusOK, s.findSyncs(nil)
+	case parts[0] == "rules" && len(parts) == 2:
+		switch method {
+		case http.MethodGet:
+			r, ok := s.rules[parts[1]]
+			if !ok {
+				return http.StatusNotFound, errNotFound
+			}
+			return http.StatusOK, r
+		case http.MethodDelete:
+			r, ok := s.rules[parts[1]]
+			if !ok {
+				return http.StatusNotFound, errNotFound
+			}
+			r.Removed = true
+			return http.StatusOK, nil
+		}
+	case parts[0] == "rules" && len(parts) == 3 && parts[2] == "sync":
+		switch method {
+		case http.MethodGet:
+			return http.StatusOK, s.findSyncs([]string{parts[1]})
+		case http.MethodPost:
+			r, ok := s.rules[parts[1]]
+			if !ok {
+				return http.StatusNotFound, errNotFound
+			}
+			s.syncRules([]types.Rule{*r})
+			return http.StatusOK, nil
+		}
+	case aclPath == "/services" && method == http.MethodGet:
+		return http.StatusOK, s.listInstances()
+	case parts[0] == "apps" && len(parts) == 3 && parts[2] == "sync" && method == http.MethodPost:
+		var rules []types.Rule
+		for _, r := range s.rules {
+			if !r.Removed && r.Source.TsuruApp != nil && r.Source.TsuruApp.AppName == parts[1] {
+				rules = append(rules, *r)
+			}
+		}
+		s.syncRules(rules)
+		return http.StatusOK, map[string]int{"count": len(rules)}
+	}
+	return http.StatusNotFound, errNotFound
+}
+
+var errNotFound = fmt.Errorf("not found")
+
+type httpError struct {
+	Message string `json:"message"`
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(httpError{Message: message})
+}
+
+func (s *Server) serviceListRules(instanceName string) (int, interface{}) {
+	si, ok := s.instances[instanceName]
+	if !ok {
+		return http.StatusNotFound, errNotFound
+	}
+	var expanded []types.Rule
+	var ruleIDs []string
+	for _, r := range s.findRules(nil) {
+		if r.Metadata["owner"] == ownerAclFromHell && r.Metadata["instance-name"] == instanceName {
+			expanded = append(expanded, r)
+			ruleIDs = append(ruleIDs, r.RuleID)
+		}
+	}
+	syncs := []types.RuleSyncInfo{}
+	if len(ruleIDs) > 0 {
+		syncs = s.findSyncs(ruleIDs)
+	}
+	return http.StatusOK, map[string]interface{}{
+		"ServiceInstance": si,
+		"ExpandedRules":   expanded,
+		"RulesSync":       syncs,
+	}
+}
+
+func (s *Server) serviceAddRule(instanceName string, body []byte, headers http.Header) (int, interface{}) {
+	si, ok := s.instances[instanceName]
+	if !ok {
+		return http.StatusNotFound, errNotFound
+	}
+	var r types.ServiceRule
+	if err := json.Unmarshal(body, &r); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := r.Destination.Validate(); err != nil {
+		return http.StatusBadRequest, err
+	}
+	for _, baseRule := range si.BaseRules {
+		if !baseRule.Removed && baseRule.Equals(&r) {
+			return http.StatusConflict, fmt.Errorf("rule already exists")
+		}
+	}
+	r.RuleID = s.newID()
+	r.Created = time.Now().UTC()
+	r.Creator = headers.Get("X-Tsuru-User")
+	r.EventID = headers.Get("X-Tsuru-Eventid")
+	si.BaseRules = append(si.BaseRules, r)
+
+	var expanded []types.Rule
+	for _, appName := range si.BindApps {
+		appRule := r.Rule
+		appRule.Source = types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: appName}}
+		appRule.RuleID = fmt.Sprintf("%s-%s", r.RuleID, appName)
+		appRule.Metadata = expandedMetadata(r.RuleID, instanceName, "app-name", appName)
+		expanded = append(expanded, appRule)
+	}
+	for _, jobName := range si.BindJobs {
+		jobRule := r.Rule
+		jobRule.Source = types.RuleType{TsuruJob: &types.TsuruJobRule{JobName: jobName}}
+		jobRule.RuleID = fmt.Sprintf("job-%s-%s", r.RuleID, jobName)
+		jobRule.Metadata = expandedMetadata(r.RuleID, instanceName, "job-name", jobName)
+		expanded = append(expanded, jobRule)
+	}
+	for i := range expanded {
+		expanded[i].Creator = r.Creator
+		// The base rule is already stored when an expanded rule hits the
+		// unique name index, the ACL API fails the same way.
+		if s.nameInUse(expanded[i].RuleName, expanded[i].RuleID) {
+			return http.StatusInternalServerError, fmt.Errorf("E11000 duplicate key error collection: acl_rules index: name_1 dup key: { name: %q }", expanded[i].RuleName)
+		}
+		s.rules[expanded[i].RuleID] = &expanded[i]
+	}
+	if s.autoSync {
+		s.syncRules(expanded)
+	}
+	return http.StatusOK, r
+}
+
+func expandedMetadata(baseID, instanceName, key, value string) map[string]string {
+	return map[string]string{
+		"owner":         ownerAclFromHell,
+		"base-ruleid":   baseID,
+		"instance-name": instanceName,
+		key:             value,
+	}
+}
+
+// nameInUse reports whether name is used by a rule other than ruleID,
+// removed rules keep their names.
+func (s *Server) nameInUse(name, ruleID string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range s.rules {
+		if r.RuleName == name && r.RuleID != ruleID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) serviceRemoveRule(instanceName, ruleID string) (int, interface{}) {
+	si, ok := s.instances[instanceName]
+	if !ok {
+		return http.StatusNotFound, errNotFound
+	}
+	found := false
+	for i, r := range si.BaseRules {
+		if r.RuleID == ruleID {
+			si.BaseRules = append(si.BaseRules[:i], si.BaseRules[i+1:]...)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return http.StatusNotFound, errNotFound
+	}
+	for _, r := range s.rules {
+		if r.Metadata["owner"] == ownerAclFromHell && r.Metadata["base-ruleid"] == ruleID {
+			r.Removed = true
+		}
+	}
+	return http.StatusOK, nil
+}
+
+func (s *Server) addRule(body []byte, headers http.Header) (int, interface{}) {
+	var r types.Rule
+	if err := json.Unmarshal(body, &r); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if r.RuleName != "" {
+		if errs := validation.IsDNS1123Subdomain(r.RuleName); len(errs) > 0 {
+			return http.StatusBadRequest, fmt.Errorf("RuleName: %s", strings.Join(errs, "\n"))
+		}
+		if s.nameInUse(r.RuleName, "") {
+			return http.StatusConflict, fmt.Errorf("RuleName: %s already in use", r.RuleName)
+		}
+	}
+	r.RuleID = s.newID()
+	r.Created = time.Now().UTC()
+	r.Creator = headers.Get("X-Tsuru-User")
+	s.rules[r.RuleID] = &r
+	if s.autoSync {
+		s.syncRules([]types.Rule{r})
+	}
+	return http.StatusCreated, r
+}
+
+func (s *Server) listInstances() []types.ServiceInstance {
+	instances := []types.ServiceInstance{}
+	for _, si := range s.instances {
+		instances = append(instances, *si)
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].InstanceName < instances[j].InstanceName
+	})
+	return instances
+}
+
+// findRules returns the rules matching every filter in query. Filters use
+// the same case insensitive dotted field names accepted by the ACL API, e.g.
+// "destination.externaldns.name" or "metadata.owner".
+func (s *Server) findRules(query url.Values) []types.Rule {
+	rules := []types.Rule{}
+	for _, r := range s.rules {
+		if matchRule(*r, query) {
+			rules = append(rules, *r)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].RuleID < rules[j].RuleID
+	})
+	return rules
+}
+
+func matchRule(r types.Rule, query url.Values) bool {
+	if len(query) == 0 {
+		return true
+	}
+	data, _ := json.Marshal(r)
+	var fields map[string]interface{}
+	_ = json.Unmarshal(data, &fields)
+	for key, values := range query {
+		value, ok := lookupField(fields, strings.Split(strings.ToLower(key), "."))
+		if !ok || len(values) == 0 || value != values[0] {
+			return false
+		}
+	}
+	return true
+}
+
+func lookupField(fields map[string]interface{}, keys []string) (string, bool) {
+	for name, v := range fields {
+		if strings.ToLower(name) != keys[0] {
+			continue
+		}
+		if len(keys) > 1 {
+			sub, ok := v.(map[string]interface{})
+			if !ok {
+				return "", false
+			}
+			return lookupField(sub, keys[1:])
+		}
+		switch v := v.(type) {
+		case string:
+			return v, true
+		case bool:
+			return strconv.FormatBool(v), true
+		case nil:
+			return "", false
+		default:
+			return fmt.Sprint(v), true
+		}
+	}
+	return "", false
+}
+
+func (s *Server) findSyncs(ruleIDs []string) []types.RuleSyncInfo {
+	filter := map[string]bool{}
+	for _, id := range ruleIDs {
+		filter[id] = true
+	}
+	syncs := []types.RuleSyncInfo{}
+	for ruleID, byEngine := range s.syncs {
+		if len(filter) > 0 && !filter[ruleID] {
+			continue
+		}
+		for _, rs := range byEngine {
+			rs := *rs
+			rs.Syncs = append([]types.RuleSyncData(nil), rs.Syncs...)
+			syncs = append(syncs, rs)
+		}
+	}
+	sort.Slice(syncs, func(i, j int) bool {
+		if syncs[i].RuleID == syncs[j].RuleID {
+			return syncs[i].Engine < syncs[j].Engine
+		}
+		return syncs[i].RuleID < syncs[j].RuleID
+	})
+	return syncs
+}
+
+// syncRules records a sync of rules in every engine. It must be called
+// holding s.mu, which is released while the SyncFunc runs.
+func (s *Server) syncRules(rules []types.Rule) {
+	engines := append([]string(nil), s.engines...)
+	syncFunc := s.syncFunc
+	for _, r := range rules {
+		for _, engine := range engines {
+			start := time.Now().UTC()
+			var result interface{} = "triggered " + engine
+			var err error
+			if syncFunc != nil {
+				s.mu.Unlock()
+				result, err = syncFunc(r, engine)
+				s.mu.Lock()
+			}
+			data := types.RuleSyncData{
+				StartTime:  start,
+				EndTime:    time.Now().UTC(),
+				Successful: err == nil,
+				Removed:    r.Removed,
+			}
+			if err != nil {
+				data.Error = err.Error()
+			}
+			if encoded, jsonErr := json.Marshal(result); result != nil && jsonErr == nil {
+				data.SyncResult = string(encoded)
+			}
+			s.recordSync(r.RuleID, engine, data)
+		}
+	}
+}
+
+func (s *Server) recordSync(ruleID, engine string, data types.RuleSyncData) {
+	byEngine, ok := s.syncs[ruleID]
+	if !ok {
+		byEngine = map[string]*types.RuleSyncInfo{}
+		s.syncs[ruleID] = byEngine
+	}
+	rs, ok := byEngine[engine]
+	if !ok {
+		rs = &types.RuleSyncInfo{
+			SyncID:    s.newID(),
+			RuleID:    ruleID,
+			Engine:    engine,
+			StartTime: data.StartTime,
+		}
+		byEngine[engine] = rs
+	}
+	rs.EndTime = data.EndTime
+	rs.PingTime = data.EndTime
+	rs.Syncs = append(rs.Syncs, data)
+}
+
+func (s *Server) newID() string {
+	s.nextID++
+	return fmt.Sprintf("%024x", s.nextID)
+}
@@ -0,0 +1,138 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package acltest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-plugin/client"
+)
+
+func newTestClient(t *testing.T) (*Server, *client.Client) {
+	t.Helper()
+	srv := NewServer()
+	t.Cleanup(srv.Close)
+	return srv, client.New(srv.URL, "token", "", srv.Client())
+}
+
+func statusCode(err error) int {
+	var statusErr *client.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode
+	}
+	return 0
+}
+
+func dnsRule(name string) types.Rule {
+	return types.Rule{Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: name}}}
+}
+
+func TestAddRuleExpandsForAppsAndJobs(t *testing.T) {
+	srv, c := newTestClient(t)
+	srv.AddServiceInstance("inst", "app1", "app2")
+	srv.BindJobs("inst", "job1")
+	created, err := c.AddRule(context.Background(), "inst", dnsRule("example.org"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ruleData, err := c.ListRules(context.Background(), "inst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := map[string]string{}
+	for _, r := range ruleData.ExpandedRules {
+		if r.Metadata["base-ruleid"] != created.RuleID {
+			t.Errorf("rule %s: base-ruleid = %q, want %q", r.RuleID, r.Metadata["base-ruleid"], created.RuleID)
+		}
+		sources[r.RuleID] = r.Source.String()
+	}
+	want := map[string]string{
+		created.RuleID + "-app1":          (&types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}}).String(),
+		created.RuleID + "-app2":          (&types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app2"}}).String(),
+		"job-" + created.RuleID + "-job1": (&types.RuleType{TsuruJob: &types.TsuruJobRule{JobName: "job1"}}).String(),
+	}
+	if len(sources) != len(want) {
+		t.Fatalf("expanded rules = %v, want %v", sources, want)
+	}
+	for id, source := range want {
+		if sources[id] != source {
+			t.Errorf("rule %s source = %q, want %q", id, sources[id], source)
+		}
+	}
+	if len(ruleData.RulesSync) != len(want) {
+		t.Errorf("got %d sync infos, want %d", len(ruleData.RulesSync), len(want))
+	}
+}
+
+func TestRuleNamesAreUnique(t *testing.T) {
+	ctx := context.Background()
+	srv, c := newTestClient(t)
+	named := dnsRule("example.org")
+	named.RuleName = "partner"
+	named.Source = types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}}
+	created, err := c.AddCustomRule(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.AddCustomRule(ctx, named); statusCode(err) != http.StatusConflict {
+		t.Errorf("adding a repeated name: got %v, want a 409", err)
+	}
+	if err = c.RemoveCustomRule(ctx, created.RuleID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.AddCustomRule(ctx, named); statusCode(err) != http.StatusConflict {
+		t.Errorf("adding the name of a removed rule: got %v, want a 409", err)
+	}
+
+	srv.AddServiceInstance("inst", "app1", "app2")
+	instanceRule := dnsRule("other.org")
+	instanceRule.RuleName = "other"
+	if _, err = c.AddRule(ctx, "inst", instanceRule); statusCode(err) != http.StatusInternalServerError {
+		t.Errorf("adding a named rule expanded for two apps: got %v, want a 500", err)
+	}
+	ruleData, err := c.ListRules(ctx, "inst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ruleData.ServiceInstance.BaseRules) != 1 {
+		t.Errorf("got %d base rules, want the base rule stored before the failure", len(ruleData.ServiceInstance.BaseRules))
+	}
+}
+
+func TestSyncFuncMayCallServer(t *testing.T) {
+	srv, c := newTestClient(t)
+	srv.AddServiceInstance("inst", "app1")
+	srv.SetSyncFunc(func(r types.Rule, engine string) (interface{}, error) {
+		return len(srv.Rules()), nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := c.AddRule(ctx, "inst", dnsRule("example.org")); err != nil {
+		t.Fatal(err)
+	}
+	rulesSync, err := c.ListSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rulesSync) != 1 || rulesSync[0].LatestSync().SyncResult != "1" {
+		t.Errorf("rules sync = %+v, want one sync with result 1", rulesSync)
+	}
+}
+
+func TestFail(t *testing.T) {
+	srv, c := newTestClient(t)
+	srv.Fail("GET", "/rules", http.StatusServiceUnavailable, "unavailable", 1)
+	if _, err := c.ListAllRules(context.Background()); statusCode(err) != http.StatusServiceUnavailable {
+		t.Errorf("first request: got %v, want a 503", err)
+	}
+	if _, err := c.ListAllRules(context.Background()); err != nil {
+		t.Errorf("second request: got %v, want no error", err)
+	}
+}
@@ -0,0 +1,341 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package client implements a client for the ACL API reached through the
+// tsuru service proxy, the same way the acl plugin commands do.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/acl-api/api/types"
+)
+
+const (
+	DefaultServiceName = "acl"
+	DefaultUserAgent   = "AclFromHell-Plugin-http-client/1.0"
+)
+
+// StatusError is returned when the ACL API (or the tsuru proxy in front of
+// it) answers with a non successful status code.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("invalid status code %d: %q", e.StatusCode, e.Body)
+}
+
+// IsNotFound reports whether err is a StatusError with a 404 status code.
+func IsNotFound(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound
+}
+
+// IsForbidden reports whether err is a StatusError with a 403 status code.
+func IsForbidden(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusForbidden
+}
+
+// ServiceRules is the representation of the rules of a single service
+// instance, including the rules expanded for each bound app and their sync
+// information.
+type ServiceRules struct {
+	ServiceInstance types.ServiceInstance
+	ExpandedRules   []types.Rule
+	RulesSync       []types.RuleSyncInfo
+}
+
+type forceResponse struct {
+	Count int `json:"count"`
+}
+
+type Client struct {
+	Target     string
+	Token      string
+	Service    string
+	UserAgent  string
+	HTTPClient *http.Client
+}
+
+// New returns a client for the ACL service named service, reached through
+// the tsuru API in target and authenticated with token. A nil httpClient
+// means http.DefaultClient.
+func New(target, token, service string, httpClient *http.Client) *Client {
+	if service == "" {
+		service = DefaultServiceName
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		Target:     strings.TrimSuffix(target, "/"),
+		Token:      token,
+		Service:    service,
+		UserAgent:  DefaultUserAgent,
+		HTTPClient: httpClient,
+	}
+}
+
+// ListRules returns the rules of a service instance.
+func (c *Client) ListRules(ctx context.Context, instance string) (*ServiceRules, error) {
+	data, err := c.ListRulesJSON(ctx, instance)
+	if err != nil {
+		return nil, err
+	}
+	var rules ServiceRules
+	if err = decodeJSON(data, &rules); err != nil {
+		return nil, err
+	}
+	return &rules, nil
+}
+
+// ListRulesJSON is ListRules returning the response body as sent by the
+// API, keeping fields unknown to the types package.
+func (c *Client) ListRulesJSON(ctx context.Context, instance string) (json.RawMessage, error) {
+	var data json.RawMessage
+	err := c.doInstanceRequest(ctx, http.MethodGet, instance, "/rule", nil, &data)
+	return data, err
+}
+
+// AddRule adds a new rule to a service instance, returning the created rule.
+func (c *Client) AddRule(ctx context.Context, instance string, rule types.Rule) (*types.ServiceRule, error) {
+	var created types.ServiceRule
+	err := c.doInstanceRequest(ctx, http.MethodPost, instance, "/rule", rule, &created)
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// RemoveRule removes a rule from a service instance.
+func (c *Client) RemoveRule(ctx context.Context, instance, ruleID string) error {
+	return c.doInstanceRequest(ctx, http.MethodDelete, instance, "/rule/"+url.PathEscape(ruleID), nil, nil)
+}
+
+// AddCustomRule adds a rule directly to the ACL API, without any service
+// instance attached to it. It requires admin permissions.
+func (c *Client) AddCustomRule(ctx context.Context, rule types.Rule) (*types.Rule, error) {
+	data, err := c.AddCustomRuleJSON(ctx, rule)
+	if err != nil {
+		return nil, err
+	}
+	var created types.Rule
+	if err = decodeJSON(data, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// AddCustomRuleJSON is AddCustomRule returning the created rule as sent by
+// the API.
+func (c *Client) AddCustomRuleJSON(ctx context.Context, rule types.Rule) (json.RawMessage, error) {
+	var data json.RawMessage
+	err := c.doAdminRequest(ctx, http.MethodPost, "/rules", rule, &data)
+	return data, err
+}
+
+// GetRule returns a single rule by its ID. It requires admin permissions.
+func (c *Client) GetRule(ctx context.Context, ruleID string) (*types.Rule, error) {
+	data, err := c.GetRuleJSON(ctx, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	var rule types.Rule
+	if err = decodeJSON(data, &rule); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// GetRuleJSON is GetRule returning the rule as sent by the API.
+func (c *Client) GetRuleJSON(ctx context.Context, ruleID string) (json.RawMessage, error) {
+	var data json.RawMessage
+	err := c.doAdminRequest(ctx, http.MethodGet, rulePath(ruleID), nil, &data)
+	return data, err
+}
+
+// GetRuleSync returns the sync information of a single rule, with every
+// recorded sync attempt in each engine.
+func (c *Client) GetRuleSync(ctx context.Context, ruleID string) ([]types.RuleSyncInfo, error) {
+	data, err := c.GetRuleSyncJSON(ctx, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	var rulesSync []types.RuleSyncInfo
+	if err = decodeJSON(data, &rulesSync); err != nil {
+		return nil, err
+	}
+	return rulesSync, nil
+}
+
+// GetRuleSyncJSON is GetRuleSync returning the sync information as sent by
+// the API.
+func (c *Client) GetRuleSyncJSON(ctx context.Context, ruleID string) (json.RawMessage, error) {
+	var data json.RawMessage
+	err := c.doAdminRequest(ctx, http.MethodGet, rulePath(ruleID)+"/sync", nil, &data)
+	return data, err
+}
+
+// RemoveCustomRule removes a rule directly from the ACL API. It requires
+// admin permissions.
+func (c *Client) RemoveCustomRule(ctx context.Context, ruleID string) error {
+	return c.doAdminRequest(ctx, http.MethodDelete, rulePath(ruleID), nil, nil)
+}
+
+// ListAllRules returns every rule known by the ACL API.
+func (c *Client) ListAllRules(ctx context.Context) ([]types.Rule, error) {
+	return c.FindRules(ctx, nil)
+}
+
+// ListAllRulesJSON is ListAllRules returning the rules as sent by the API.
+func (c *Client) ListAllRulesJSON(ctx context.Context) (json.RawMessage, error) {
+	data, err := c.findRulesJSON(ctx, nil)
+	if err == nil && len(data) == 0 {
+		data = json.RawMessage("[]")
+	}
+	return data, err
+}
+
+// FindRules returns the rules matching query, e.g.
+// "destination.externaldns.name=example.org".
+func (c *Client) FindRules(ctx context.Context, query url.Values) ([]types.Rule, error) {
+	data, err := c.findRulesJSON(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	var rules []types.Rule
+	if err = decodeJSON(data, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (c *Client) findRulesJSON(ctx context.Context, query url.Values) (json.RawMessage, error) {
+	path := "/rules"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var data json.RawMessage
+	err := c.doAdminRequest(ctx, http.MethodGet, path, nil, &data)
+	return data, err
+}
+
+// ListSync returns the sync information of every rule.
+func (c *Client) ListSync(ctx context.Context) ([]types.RuleSyncInfo, error) {
+	var rulesSync []types.RuleSyncInfo
+	err := c.doAdminRequest(ctx, http.MethodGet, "/rules/sync", nil, &rulesSync)
+	if err != nil {
+		return nil, err
+	}
+	return rulesSync, nil
+}
+
+// ListServiceInstances returns every instance of the ACL service.
+func (c *Client) ListServiceInstances(ctx context.Context) ([]types.ServiceInstance, error) {
+	var instances []types.ServiceInstance
+	err := c.doAdminRequest(ctx, http.MethodGet, "/services", nil, &instances)
+	if err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+// ForceSyncApp syncs every rule of an app, returning how many rules were
+// synced.
+func (c *Client) ForceSyncApp(ctx context.Context, appName string) (int, error) {
+	var response forceResponse
+	err := c.doAdminRequest(ctx, http.MethodPost, "/apps/"+url.PathEscape(appName)+"/sync", nil, &response)
+	if err != nil {
+		return 0, err
+	}
+	return response.Count, nil
+}
+
+// ForceSyncRule syncs a single rule.
+func (c *Client) ForceSyncRule(ctx context.Context, ruleID string) error {
+	return c.doAdminRequest(ctx, http.MethodPost, rulePath(ruleID)+"/sync", nil, nil)
+}
+
+func rulePath(ruleID string) string {
+	return "/rules/" + url.PathEscape(ruleID)
+}
+
+func (c *Client) doAdminRequest(ctx context.Context, method, path string, body, out interface{}) error {
+	fullURL := fmt.Sprintf("%s/services/proxy/service/%s?callback=%s",
+		c.Target,
+		c.Service,
+		path,
+	)
+	return c.doRequest(ctx, method, fullURL, body, out)
+}
+
+func (c *Client) doInstanceRequest(ctx context.Context, method, instance, path string, body, out interface{}) error {
+	fullURL := fmt.Sprintf("%s/1.20/services/%s/resources/%s%s",
+		c.Target,
+		c.Service,
+		instance,
+		path,
+	)
+	return c.doRequest(ctx, method, fullURL, body, out)
+}
+
+func (c *Client) doRequest(ctx context.Context, method, fullURL string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, fullURL, reader)
+	if err != nil {
+		return err
+	}
+	if reader != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", "bearer "+c.Token)
+	req.Header.Set("User-Agent", c.UserAgent)
+	rsp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	data, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return err
+	}
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 400 {
+		return &StatusError{StatusCode: rsp.StatusCode, Body: string(data)}
+	}
+	if out == nil {
+		return nil
+	}
+	return decodeJSON(data, out)
+}
+
+func decodeJSON(data []byte, out interface{}) error {
+	// tsuru answers empty lists with 204 No Content.
+	if len(data) == 0 {
+		return nil
+	}
+	err := json.Unmarshal(data, out)
+	if err != nil {
+		return errors.Wrapf(err, "unable to unmarshal %q", string(data))
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/tsuru/acl-api/api/types"
+)
+
+type recordedRequest struct {
+	method string
+	uri    string
+	header http.Header
+	body   string
+}
+
+// newRecordingServer starts a server answering every request with status
+// and body, returning a client for it and the requests received.
+func newRecordingServer(t *testing.T, status int, body string) (*Client, *[]recordedRequest) {
+	t.Helper()
+	var requests []recordedRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := io.ReadAll(req.Body)
+		requests = append(requests, recordedRequest{
+			method: req.Method,
+			uri:    req.URL.RequestURI(),
+			header: req.Header,
+			body:   string(data),
+		})
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return New(srv.URL+"/", "secret", "acl-dev", srv.Client()), &requests
+}
+
+func TestRequests(t *testing.T) {
+	rule := types.Rule{Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "example.org"}}}
+	data, err := json.Marshal(rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ruleBody := string(data)
+	tests := []struct {
+		name       string
+		call       func(context.Context, *Client) error
+		wantMethod string
+		wantURI    string
+		wantBody   string
+	}{
+		{
+			name:       "ListRules",
+			call:       func(ctx context.Context, c *Client) error { _, err := c.ListRules(ctx, "inst"); return err },
+			wantMethod: http.MethodGet,
+			wantURI:    "/1.20/services/acl-dev/resources/inst/rule",
+		},
+		{
+			name:       "ListRulesJSON",
+			call:       func(ctx context.Context, c *Client) error { _, err := c.ListRulesJSON(ctx, "inst"); return err },
+			wantMethod: http.MethodGet,
+			wantURI:    "/1.20/services/acl-dev/resources/inst/rule",
+		},
+		{
+			name:       "AddRule",
+			call:       func(ctx context.Context, c *Client) error { _, err := c.AddRule(ctx, "inst", rule); return err },
+			wantMethod: http.MethodPost,
+			wantURI:    "/1.20/services/acl-dev/resources/inst/rule",
+			wantBody:   ruleBody,
+		},
+		{
+			name:       "RemoveRule",
+			call:       func(ctx context.Context, c *Client) error { return c.RemoveRule(ctx, "inst", "a/b") },
+			wantMethod: http.MethodDelete,
+			wantURI:    "/1.20/services/acl-dev/resources/inst/rule/a%2Fb",
+		},
+		{
+			name:       "AddCustomRule",
+			call:       func(ctx context.Context, c *Client) error { _, err := c.AddCustomRule(ctx, rule); return err },
+			wantMethod: http.MethodPost,
+			wantURI:    "/services/proxy/service/acl-dev?callback=/rules",
+			wantBody:   ruleBody,
+		},
+		{
+			name:       "AddCustomRuleJSON",
+			call:       func(ctx context.Context, c *Client) error { _, err := c.AddCustomRuleJSON(ctx, rule); return err },
+			wantMethod: http.MethodPost,
+			wantURI:    "/services/proxy/service/acl-dev?callback=/rules",
+			wantBody:   ruleBody,
+		},
+		{
+			name:       "GetRule",
+			call:       func(ctx context.Context, c *Client) error { _, err := c.GetRule(ctx, "a/b"); return err },
+			wantMethod: http.MethodGet,
+			wantURI:    "/services/proxy/service/acl-dev?callback=/rules/a%2Fb",
+		},
+		{
+			name:       "GetRuleJSON",
+			call:       func(ctx context.Context, c *Client) error { _, err := c.GetRuleJSON(ctx, "a/b"); return err },
+			wantMethod: http.MethodGet,
+			wantURI:    "/services/proxy/service/acl-dev?callback=/rules/a%2Fb",
+		},
+		{
+			name:       "GetRuleSync",
+			call:       func(ctx context.Context, c *Client) error { _, err := c.GetRuleSync(ctx, "r1"); return err },
+			wantMethod: http.MethodGet,
+			wantURI:    "/services/proxy/service/acl-dev?callback=/rules/r1/sync",
+		},
+		{
+			name:       "GetRuleSyncJSON",
+			call:       func(ctx context.Context, c *Client) error { _, err := c.GetRuleSyncJSON(ctx, "r1"); return err },
+			wantMethod: http.MethodGet,
+			wantURI:    "/services/proxy/service/acl-dev?callback=/rules/r1/sync",
+		},
+		{
+			name:       "RemoveCustomRule",
+			call:       func(ctx context.Context, c *Client) error { return c.RemoveCustomRule(ctx, "r1") },
+			wantMethod: http.MethodDelete,
+			wantURI:    "/services/proxy/service/acl-dev?callback=/rules/r1",
+		},
+		{
+			name:       "ListAllRules",
+			call:       func(ctx context.Context, c *Client) error { _, err := c.ListAllRules(ctx); return err },
+			wantMethod: http.MethodGet,
+			wantURI:    "/services/proxy/service/acl-dev?callback=/rules",
+		},
+		{
+			name:       "ListAllRulesJSON",
+			call:       func(ctx context.Context, c *Client) error { _, err := c.ListAllRulesJSON(ctx); return err },
+			wantMethod: http.MethodGet,
+			wantURI:    "/services/proxy/service/acl-dev?callback=/rules",
+		},
+		{
+			name: "FindRules",
+			call: func(ctx context.Context, c *Client) error {
+				_, err := c.FindRules(ctx, url.Values{"destination.externaldns.name": {"example.org"}})
+				return err
+			},
+			wantMethod: http.MethodGet,
+			wantURI:    "/services/proxy/service/acl-dev?callback=/rules?destination.externaldns.name=example.org",
+		},
+		{
+			name:       "ListSync",
+			call:       func(ctx context.Context, c *Client) error { _, err := c.ListSync(ctx); return err },
+			wantMethod: http.MethodGet,
+			wantURI:    "/services/proxy/service/acl-dev?callback=/rules/sync",
+		},
+		{
+			name:       "ListServiceInstances",
+			call:       func(ctx context.Context, c *Client) error { _, err := c.ListServiceInstances(ctx); return err },
+			wantMethod: http.MethodGet,
+			wantURI:    "/services/proxy/service/acl-dev?callback=/services",
+		},
+		{
+			name:       "ForceSyncApp",
+			call:       func(ctx context.Context, c *Client) error { _, err := c.ForceSyncApp(ctx, "app1"); return err },
+			wantMethod: http.MethodPost,
+			wantURI:    "/services/proxy/service/acl-dev?callback=/apps/app1/sync",
+		},
+		{
+			name:       "ForceSyncRule",
+			call:       func(ctx context.Context, c *Client) error { return c.ForceSyncRule(ctx, "r1") },
+			wantMethod: http.MethodPost,
+			wantURI:    "/services/proxy/service/acl-dev?callback=/rules/r1/sync",
+		},
+		{
+			name:       "ListTsuruApps",
+			call:       func(ctx context.Context, c *Client) error { _, err := c.ListTsuruApps(ctx); return err },
+			wantMethod: http.MethodGet,
+			wantURI:    "/1.0/apps?simplified=true",
+		},
+		{
+			name: "ListTsuruServiceInstances",
+			call: func(ctx context.Context, c *Client) error {
+				_, err := c.ListTsuruServiceInstances(ctx, "rpaasv2-be")
+				return err
+			},
+			wantMethod: http.MethodGet,
+			wantURI:    "/1.0/services/instances?service=rpaasv2-be",
+		},
+		{
+			name: "TsuruServiceInstanceExists",
+			call: func(ctx context.Context, c *Client) error {
+				_, err := c.TsuruServiceInstanceExists(ctx, "rpaasv2-be", "front")
+				return err
+			},
+			wantMethod: http.MethodGet,
+			wantURI:    "/1.0/services/rpaasv2-be/instances/front",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, requests := newRecordingServer(t, http.StatusNoContent, "")
+			if err := tt.call(context.Background(), c); err != nil {
+				t.Fatal(err)
+			}
+			if len(*requests) != 1 {
+				t.Fatalf("got %d requests, want 1", len(*requests))
+			}
+			req := (*requests)[0]
+			if req.method != tt.wantMethod || req.uri != tt.wantURI {
+				t.Errorf("got request %s %s, want %s %s", req.method, req.uri, tt.wantMethod, tt.wantURI)
+			}
+			if req.body != tt.wantBody {
+				t.Errorf("got body %s, want %s", req.body, tt.wantBody)
+			}
+			if got := req.header.Get("Authorization"); got != "bearer secret" {
+				t.Errorf("got Authorization %q, want the token", got)
+			}
+			if got := req.header.Get("User-Agent"); got != DefaultUserAgent {
+				t.Errorf("got User-Agent %q, want %q", got, DefaultUserAgent)
+			}
+			wantContentType := ""
+			if tt.wantBody != "" {
+				wantContentType = "application/json"
+			}
+			if got := req.header.Get("Content-Type"); got != wantContentType {
+				t.Errorf("got Content-Type %q, want %q", got, wantContentType)
+			}
+		})
+	}
+}
+
+func TestJSONMethodsKeepTheResponse(t *testing.T) {
+	body := `{"RuleID":"r1","Destination":{"ExternalDNS":{"Name":"example.org"}},"NewField":true}`
+	c, _ := newRecordingServer(t, http.StatusOK, body)
+	ctx := context.Background()
+	data, err := c.GetRuleJSON(ctx, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != body {
+		t.Errorf("got %s, want %s", data, body)
+	}
+	rule, err := c.GetRule(ctx, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.RuleID != "r1" || rule.Destination.String() != "DNS: example.org" {
+		t.Errorf("got rule %+v, want r1 to example.org", rule)
+	}
+}
+
+func TestEmptyResponses(t *testing.T) {
+	c, _ := newRecordingServer(t, http.StatusNoContent, "")
+	ctx := context.Background()
+	rules, err := c.ListAllRules(ctx)
+	if err != nil || rules != nil {
+		t.Errorf("got rules %v and error %v, want no rules", rules, err)
+	}
+	data, err := c.ListAllRulesJSON(ctx)
+	if err != nil || string(data) != "[]" {
+		t.Errorf("got %s and error %v, want an empty list", data, err)
+	}
+	ruleData, err := c.ListRules(ctx, "inst")
+	if err != nil || len(ruleData.ServiceInstance.BaseRules) != 0 {
+		t.Errorf("got rules %+v and error %v, want no rules", ruleData, err)
+	}
+}
+
+func TestInvalidResponse(t *testing.T) {
+	c, _ := newRecordingServer(t, http.StatusOK, "<html>")
+	_, err := c.GetRule(context.Background(), "r1")
+	if err == nil || !strings.Contains(err.Error(), `unable to unmarshal "<html>"`) {
+		t.Errorf("got error %v, want it to report the body", err)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		status        int
+		wantNotFound  bool
+		wantForbidden bool
+	}{
+		{status: http.StatusNotFound, wantNotFound: true},
+		{status: http.StatusForbidden, wantForbidden: true},
+		{status: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			c, _ := newRecordingServer(t, tt.status, "rule not found")
+			_, err := c.GetRule(context.Background(), "r1")
+			statusErr, ok := err.(*StatusError)
+			if !ok || statusErr.StatusCode != tt.status || statusErr.Body != "rule not found" {
+				t.Fatalf("got error %#v, want a StatusError with status %d", err, tt.status)
+			}
+			if got := IsNotFound(err); got != tt.wantNotFound {
+				t.Errorf("IsNotFound = %v, want %v", got, tt.wantNotFound)
+			}
+			if got := IsForbidden(err); got != tt.wantForbidden {
+				t.Errorf("IsForbidden = %v, want %v", got, tt.wantForbidden)
+			}
+		})
+	}
+}
+
+func TestTsuruExists(t *testing.T) {
+	tests := []struct {
+		status  int
+		want    bool
+		wantErr bool
+	}{
+		{status: http.StatusOK, want: true},
+		{status: http.StatusForbidden, want: true},
+		{status: http.StatusNotFound},
+		{status: http.StatusInternalServerError, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			c, requests := newRecordingServer(t, tt.status, "{}")
+			got, err := c.TsuruAppExists(context.Background(), "app 1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if uri := (*requests)[0].uri; uri != "/1.0/apps/app%201" {
+				t.Errorf("got request to %s, want the escaped app name", uri)
+			}
+		})
+	}
+}
+
+func TestNewDefaults(t *testing.T) {
+	c := New("https://tsuru.example.org/", "secret", "", nil)
+	if c.Target != "https://tsuru.example.org" || c.Service != DefaultServiceName || c.HTTPClient != http.DefaultClient {
+		t.Errorf("got client %+v, want the default service and HTTP client", c)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// TsuruApp is an app as listed by the tsuru API.
+type TsuruApp struct {
+	Name string `json:"name"`
+	Pool string `json:"pool"`
+}
+
+// TsuruPool is a pool as listed by the tsuru API.
+type TsuruPool struct {
+	Name string `json:"name"`
+}
+
+// TsuruServiceInstances are the instances of a service as listed by the
+// tsuru API.
+type TsuruServiceInstances struct {
+	Service   string   `json:"service"`
+	Instances []string `json:"instances"`
+}
+
+// ListTsuruApps returns every app visible to the token in the tsuru API.
+func (c *Client) ListTsuruApps(ctx context.Context) ([]TsuruApp, error) {
+	var apps []TsuruApp
+	err := c.doRequest(ctx, http.MethodGet, c.Target+"/1.0/apps?simplified=true", nil, &apps)
+	if err != nil {
+		return nil, err
+	}
+	return apps, nil
+}
+
+// ListTsuruPools returns every pool visible to the token in the tsuru API.
+func (c *Client) ListTsuruPools(ctx context.Context) ([]TsuruPool, error) {
+	var pools []TsuruPool
+	err := c.doRequest(ctx, http.MethodGet, c.Target+"/1.0/pools", nil, &pools)
+	if err != nil {
+		return nil, err
+	}
+	return pools, nil
+}
+
+// ListTsuruServices returns every service with the instances visible to
+// the token in the tsuru API.
+func (c *Client) ListTsuruServices(ctx context.Context) ([]TsuruServiceInstances, error) {
+	return c.listTsuruServices(ctx, c.Target+"/1.0/services/instances")
+}
+
+// ListTsuruServiceInstances returns the instances of service visible to the
+// token in the tsuru API.
+func (c *Client) ListTsuruServiceInstances(ctx context.Context, service string) ([]string, error) {
+	services, err := c.listTsuruServices(ctx, c.Target+"/1.0/services/instances?service="+url.QueryEscape(service))
+	if err != nil {
+		return nil, err
+	}
+	var instances []string
+	for _, s := range services {
+		if s.Service == service {
+			instances = append(instances, s.Instances...)
+		}
+	}
+	return instances, nil
+}
+
+// TsuruAppExists reports whether app exists in the tsuru API. Apps the
+// token isn't allowed to see are reported as existing, tsuru answers 403 for
+// them and 404 only for missing ones.
+func (c *Client) TsuruAppExists(ctx context.Context, app string) (bool, error) {
+	return c.tsuruExists(ctx, c.Target+"/1.0/apps/"+url.PathEscape(app))
+}
+
+// TsuruPoolExists reports whether pool exists in the tsuru API, see
+// TsuruAppExists.
+func (c *Client) TsuruPoolExists(ctx context.Context, pool string) (bool, error) {
+	return c.tsuruExists(ctx, c.Target+"/1.0/pools/"+url.PathEscape(pool))
+}
+
+// TsuruServiceInstanceExists reports whether instance of service exists in
+// the tsuru API, see TsuruAppExists.
+func (c *Client) TsuruServiceInstanceExists(ctx context.Context, service, instance string) (bool, error) {
+	return c.tsuruExists(ctx, c.Target+"/1.0/services/"+url.PathEscape(service)+"/instances/"+url.PathEscape(instance))
+}
+
+func (c *Client) tsuruExists(ctx context.Context, fullURL string) (bool, error) {
+	err := c.doRequest(ctx, http.MethodGet, fullURL, nil, nil)
+	switch {
+	case err == nil, IsForbidden(err):
+		return true, nil
+	case IsNotFound(err):
+		return false, nil
+	}
+	return false, err
+}
+
+func (c *Client) listTsuruServices(ctx context.Context, fullURL string) ([]TsuruServiceInstances, error) {
+	var services []TsuruServiceInstances
+	err := c.doRequest(ctx, http.MethodGet, fullURL, nil, &services)
+	if err != nil {
+		return nil, err
+	}
+	return services, nil
+}
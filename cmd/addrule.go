@@ -5,55 +5,86 @@
 package cmd
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"net/http"
-	"strconv"
+	"os"
 	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-plugin/client"
+	"github.com/tsuru/tablecli"
 )
 
 var AddCustomRuleCmd = &cobra.Command{
 	Use:   "add",
 	Short: "Add new rule custom rule",
+	Example: `
+# Allow a tsuru app to reach a destination tsuru app
+tsuru acl admin add --src-app <SOURCE APP> --app <DESTINATION APP> --owner <OWNER>
+
+# Allow an RPAAS instance to reach a destination tsuru app
+tsuru acl admin add --src-rpaas "<RPAAS SERVICE NAME>/<RPAAS SERVICE INSTANCE>" --app <DESTINATION APP> --owner <OWNER>
+
+# Allow a tsuru job to reach a destination by DNS
+tsuru acl admin add --src-job <SOURCE JOB> --dns mydomain.globoi.com --port tcp:443 --owner <OWNER>
+
+# Allow an external network to reach a destination tsuru pool
+tsuru acl admin add --src-ip 10.0.0.0/24 --app-pool <DESTINATION POOL> --owner <OWNER>
+	`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		src, err := parseSourceRuleType(cmd.Flags())
-		if err != nil {
-			return err
+		src, srcWarnings, srcErr := parseSourceRuleType(cmd.Flags())
+		dst, warnings, err := parseRuleType(cmd.Flags())
+		printWarnings(append(srcWarnings, warnings...))
+		if srcErr != nil {
+			return srcErr
 		}
-		dst, err := parseRuleType(cmd.Flags())
 		if err != nil {
 			return err
 		}
+		if checkDNS, _ := cmd.Flags().GetBool("check-dns"); checkDNS {
+			printWarnings(checkDNSResolution(cmd.Context(), newDNSResolver(""), []types.RuleType{*dst}))
+		}
 		owner, _ := cmd.Flags().GetString("owner")
 		if owner == "" {
 			return errors.New("--owner argument is mandatory")
 		}
+		name, metadata, err := parseRuleMetadata(cmd.Flags())
+		if err != nil {
+			return err
+		}
+		metadata["owner"] = owner
 
 		serviceName, _ := serviceInstanceName(args, 1)
-		data, err := json.Marshal(types.Rule{
+		c := newClient(serviceName)
+		if skip, _ := cmd.Flags().GetBool("skip-validation"); !skip {
+			if err = newTsuruValidator(c).ValidateAll(cmd.Context(), []types.RuleType{*src, *dst}); err != nil {
+				return err
+			}
+		}
+
+		rule := types.Rule{
+			RuleName:    name,
 			Source:      *src,
 			Destination: *dst,
-			Metadata: map[string]string{
-				"owner": owner,
-			},
-		})
-		if err != nil {
-			return err
+			Metadata:    metadata,
 		}
-		body := bytes.NewReader(data)
-
-		rsp, err := doProxyAdminRequest(http.MethodPost, serviceName, "/rules", body)
+		jsonOutput, _ := cmd.Flags().GetBool("json")
+		if jsonOutput {
+			data, addErr := c.AddCustomRuleJSON(cmd.Context(), rule)
+			if addErr != nil {
+				return addErr
+			}
+			return printJSON(data)
+		}
+		created, err := c.AddCustomRule(cmd.Context(), rule)
 		if err != nil {
 			return err
 		}
-		defer rsp.Body.Close()
-
+		fmt.Println("Rule successfully added.")
+		renderRule(*created)
 		return nil
 	},
 }
@@ -68,6 +99,9 @@ tsuru acl rules add <ACL SERVICE> --app <MY DESTINATION APP>
 # Add ACL to a destination tsuru pool (prefer to use fine grained by each app)
 tsuru acl rules add <ACL SERVICE> --app-pool <MY DESTINATION APP POOL>
 
+# Add ACL to a tsuru app that will be created later
+tsuru acl rules add <ACL SERVICE> --app <MY FUTURE APP> --skip-validation
+
 # Add ACL to a destination RPASS
 tsuru acl rules add <ACL SERVICE> --rpaas "<RPAAS SERVICE NAME>/<RPAAS SERVICE INSTANCE>"
 
@@ -75,71 +109,200 @@ tsuru acl rules add <ACL SERVICE> --rpaas "<RPAAS SERVICE NAME>/<RPAAS SERVICE I
 tsuru acl rules add <ACL SERVICE> --dns mydomain.globoi.com --port tcp:443
 
 # Add ACL to a destination service by IP (prefer by DNS over IP)
-tsuru acl rules add <ACL SERVICE> --ip MYIP/32 --port tcp:443
+tsuru acl rules add <ACL SERVICE> --ip MYIP --port tcp:443
+
+# Add ACL to the host and port of a URL
+tsuru acl rules add <ACL SERVICE> --url https://api.partner.com:8443/v1
+
+# Add ACLs to several destinations at once
+tsuru acl rules add <ACL SERVICE> --dns a.globoi.com --dns b.globoi.com:8443 --ip 10.1.2.3 --port tcp:443
+
+# Add ACL to a range of ports and a named port
+tsuru acl rules add <ACL SERVICE> --dns mydomain.globoi.com --port tcp:8000-8010 --port tcp:https
+
+# Add a named ACL with a description and labels
+tsuru acl rules add <ACL SERVICE> --dns mydomain.globoi.com --port tcp:443 --name partner-api --description "Partner payments API" --label team=payments --ticket INC-1234
+
+# Answer questions about the destination, on a terminal without destination flags
+tsuru acl rules add
 	`,
-	Args: cobra.MinimumNArgs(1),
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 && shouldRunAddRuleWizard(cmd.Flags()) {
+			return nil
+		}
+		return cobra.MinimumNArgs(1)(cmd, args)
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		rt, err := parseRuleType(cmd.Flags())
+		var p *prompter
+		if shouldRunAddRuleWizard(cmd.Flags()) {
+			p = newPrompter(cmd.Context(), os.Stdin, os.Stdout)
+			var err error
+			if args, err = runAddRuleWizard(cmd, p, args); err != nil {
+				return err
+			}
+		}
+		rts, warnings, err := parseRuleTypes(cmd.Flags())
+		printWarnings(warnings)
 		if err != nil {
 			return err
 		}
-		serviceName, instanceName := serviceInstanceName(args, 1)
-		data, err := json.Marshal(types.Rule{Destination: *rt})
+		if checkDNS, _ := cmd.Flags().GetBool("check-dns"); checkDNS {
+			printWarnings(checkDNSResolution(cmd.Context(), newDNSResolver(""), rts))
+		}
+		name, metadata, err := parseRuleMetadata(cmd.Flags())
 		if err != nil {
 			return err
 		}
-		body := bytes.NewReader(data)
-		rsp, err := doProxyRequest(http.MethodPost, serviceName, instanceName, "/rule", body)
-		if err != nil {
+		if name != "" {
+			if len(rts) > 1 {
+				return errors.Errorf("--name can't be used with %d destinations, add each named rule on its own", len(rts))
+			}
+			metadata[metadataName] = name
+		}
+		serviceName, instanceName := serviceInstanceName(args, 1)
+		c := newClient(serviceName)
+		if skip, _ := cmd.Flags().GetBool("skip-validation"); !skip {
+			if err = newTsuruValidator(c).ValidateAll(cmd.Context(), rts); err != nil {
+				return err
+			}
+		}
+		if p != nil {
+			rules := make([]types.Rule, len(rts))
+			for i, rt := range rts {
+				rules[i] = types.Rule{Destination: rt, Metadata: metadata}
+			}
+			add, confirmErr := confirmRules(p, instanceName, rules)
+			if confirmErr != nil {
+				return confirmErr
+			}
+			if !add {
+				return errors.New("rule not added")
+			}
+		}
+		if len(rts) == 1 {
+			_, err = c.AddRule(cmd.Context(), instanceName, types.Rule{
+				Destination: rts[0],
+				Metadata:    metadata,
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Println("Rule successfully added.")
+		} else if err = addRules(cmd.Context(), c, instanceName, rts, metadata); err != nil {
 			return err
 		}
-		defer rsp.Body.Close()
-		fmt.Println("Rule successfully added.")
+		if p != nil {
+			fmt.Printf("\nThe same rule can be added without questions with:\n  %s\n", equivalentCommand(cmd, args))
+		}
 		return nil
 	},
 }
 
-func parseRuleType(flags *pflag.FlagSet) (*types.RuleType, error) {
-	rawPorts, _ := flags.GetStringSlice("port")
-	var ports []types.ProtoPort
-	for _, p := range rawPorts {
-		parts := strings.Split(p, ":")
-		if len(parts) != 2 {
-			return nil, errors.New("--port arguments must be in the format <protocol>:<port>, e.g. \"--port tcp:443\"")
-		}
-		portInt, err := strconv.ParseUint(parts[1], 10, 16)
+// addRules adds a rule to each destination, reporting the result of each
+// one.
+func addRules(ctx context.Context, c *client.Client, instanceName string, rts []types.RuleType, metadata map[string]string) error {
+	ids := make([]string, len(rts))
+	for i, rt := range rts {
+		ids[i] = rt.String()
+	}
+	ruleIDs := make([]string, len(rts))
+	summary := runBulk(ctx, ids, 1, func(ctx context.Context, i int, _ string) error {
+		created, err := c.AddRule(ctx, instanceName, types.Rule{
+			Destination: rts[i],
+			Metadata:    metadata,
+		})
 		if err != nil {
-			return nil, err
+			return err
 		}
-		ports = append(ports, types.ProtoPort{
-			Protocol: parts[0],
-			Port:     uint16(portInt),
-		})
+		ruleIDs[i] = created.RuleID
+		return nil
+	})
+	table := tablecli.NewTable()
+	table.Headers = tablecli.Row{"Destination", "Rule ID", "Result"}
+	for i, result := range summary.Results {
+		status := "added"
+		switch {
+		case result.Skipped:
+			status = "skipped"
+		case result.Err != nil:
+			status = "failed: " + result.Err.Error()
+		}
+		table.AddRow(tablecli.Row{result.ID, ruleIDs[i], status})
+	}
+	fmt.Print(table.String())
+	failed, skipped := len(summary.Failed()), len(summary.Skipped())
+	fmt.Printf("%d succeeded, %d failed, %d skipped.\n", len(rts)-failed-skipped, failed, skipped)
+	if summary.Interrupted {
+		return ctx.Err()
+	}
+	if failed > 0 {
+		return errors.Errorf("%d of %d rules were not added", failed, len(rts))
 	}
-	ip, _ := flags.GetIPNet("ip")
-	dns, _ := flags.GetString("dns")
+	return nil
+}
+
+// parseRuleType returns the single destination set by the destination
+// flags, see parseRuleTypes.
+func parseRuleType(flags *pflag.FlagSet) (*types.RuleType, []string, error) {
+	rts, warnings, err := parseRuleTypes(flags)
+	if err != nil {
+		return nil, warnings, err
+	}
+	if len(rts) > 1 {
+		return nil, warnings, errors.New("only one destination is supported, --ip, --dns and --url must not be repeated")
+	}
+	return &rts[0], warnings, nil
+}
+
+// parseRuleTypes returns the validated destinations set by the destination
+// flags and the warnings found validating them. --ip, --dns and --url may
+// be repeated, or combined, setting a destination for each value with the
+// same --port. The error lists every invalid value.
+func parseRuleTypes(flags *pflag.FlagSet) ([]types.RuleType, []string, error) {
+	var problems []string
+	rawPorts, _ := flags.GetStringSlice("port")
+	ports, err := parsePorts(rawPorts, "port")
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	ips, _ := flags.GetStringSlice("ip")
+	dnsNames, _ := flags.GetStringSlice("dns")
+	urls, _ := flags.GetStringSlice("url")
 	app, _ := flags.GetString("app")
 	appPool, _ := flags.GetString("app-pool")
 	service, _ := flags.GetString("service")
 
 	rpaas, _ := flags.GetString("rpaas")
 
-	count := 0
-	rt := types.RuleType{}
-	if ip.IP != nil {
-		count++
-		rt.ExternalIP = &types.ExternalIPRule{
-			IP:    ip.String(),
-			Ports: ports,
+	var rts []types.RuleType
+	for _, raw := range ips {
+		ipRule, err := parseIPDestination(raw, ports)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
 		}
+		rts = append(rts, types.RuleType{ExternalIP: ipRule})
 	}
-	if dns != "" {
-		count++
-		rt.ExternalDNS = &types.ExternalDNSRule{
-			Name:  dns,
-			Ports: ports,
+	for _, raw := range dnsNames {
+		dnsRule, err := parseDNSDestination(raw, ports)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
 		}
+		rts = append(rts, types.RuleType{ExternalDNS: dnsRule})
 	}
+	for _, raw := range urls {
+		rt, err := parseURLDestination(raw, ports)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		rts = append(rts, *rt)
+	}
+	external := len(ips) + len(dnsNames) + len(urls)
+
+	count := 0
+	rt := types.RuleType{}
 	if app != "" {
 		count++
 		rt.TsuruApp = &types.TsuruAppRule{
@@ -154,46 +317,63 @@ func parseRuleType(flags *pflag.FlagSet) (*types.RuleType, error) {
 	}
 	if rpaas != "" {
 		count++
-		parts := strings.SplitN(rpaas, "/", 2)
-		if len(parts) != 2 {
-			return nil, errors.New("--rpaas argument must be in the format serviceName/serviceInstance, e.g. \"rpaasv2-be/myinstance\"")
-		}
-
-		rt.RpaasInstance = &types.RpaasInstanceRule{
-			ServiceName: parts[0],
-			Instance:    parts[1],
+		rt.RpaasInstance, err = parseRpaasInstance(rpaas, "rpaas")
+		if err != nil {
+			problems = append(problems, err.Error())
 		}
 	}
 	if service != "" {
 		count++
-		parts := strings.SplitN(service, "/", 2)
-		ns := "default"
-		var svcName string
-		if len(parts) == 2 {
-			ns, svcName = parts[0], parts[1]
-		} else {
-			svcName = parts[0]
-		}
-		rt.KubernetesService = &types.KubernetesServiceRule{
-			Namespace:   ns,
-			ServiceName: svcName,
+		rt.KubernetesService, err = parseKubernetesService(service, "service")
+		if err != nil {
+			problems = append(problems, err.Error())
 		}
 	}
 
-	if count != 1 {
-		return nil, errors.New("only one of --ip, --dns, --app, --app-pool, --rpaas, --service must be set")
+	if count > 1 || (count == 1 && external > 0) || (count == 0 && external == 0) {
+		return nil, nil, errors.New("only one of --app, --app-pool, --rpaas, --service, or one or more of --ip, --dns, --url must be set")
+	}
+	if (app != "" || appPool != "" || service != "") && len(rawPorts) > 0 {
+		problems = append(problems, "--port is not supported with --app, --app-pool or --service")
+	}
+	if count == 1 && len(problems) == 0 {
+		rts = append(rts, rt)
+	}
+
+	var warnings []string
+	var result []types.RuleType
+	for _, rt := range rts {
+		rtWarnings, rtProblems := validateRuleType(&rt)
+		warnings = append(warnings, rtWarnings...)
+		problems = append(problems, rtProblems...)
+		duplicated := false
+		for _, existing := range result {
+			if existing.String() == rt.String() {
+				duplicated = true
+				break
+			}
+		}
+		if !duplicated {
+			result = append(result, rt)
+		}
 	}
-	if (app != "" || appPool != "" || service != "") && len(ports) > 0 {
-		return nil, errors.New("--port is not supported with --app, --app-pool or --service")
+	if err := problemsError(problems); err != nil {
+		return nil, warnings, err
 	}
-	return &rt, nil
+	return result, warnings, nil
 }
 
-func parseSourceRuleType(flags *pflag.FlagSet) (*types.RuleType, error) {
+// parseSourceRuleType returns the validated source set by the --src-* flags
+// and the warnings found validating it.
+func parseSourceRuleType(flags *pflag.FlagSet) (*types.RuleType, []string, error) {
 	count := 0
 	rt := types.RuleType{}
 	app, _ := flags.GetString("src-app")
 	appPool, _ := flags.GetString("src-app-pool")
+	job, _ := flags.GetString("src-job")
+	rpaas, _ := flags.GetString("src-rpaas")
+	service, _ := flags.GetString("src-service")
+	ip, _ := flags.GetString("src-ip")
 	if app != "" {
 		count++
 		rt.TsuruApp = &types.TsuruAppRule{
@@ -206,10 +386,73 @@ func parseSourceRuleType(flags *pflag.FlagSet) (*types.RuleType, error) {
 			PoolName: appPool,
 		}
 	}
+	if job != "" {
+		count++
+		rt.TsuruJob = &types.TsuruJobRule{
+			JobName: job,
+		}
+	}
+	var err error
+	if rpaas != "" {
+		count++
+		rt.RpaasInstance, err = parseRpaasInstance(rpaas, "src-rpaas")
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if service != "" {
+		count++
+		rt.KubernetesService, err = parseKubernetesService(service, "src-service")
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if ip != "" {
+		count++
+		rt.ExternalIP = &types.ExternalIPRule{
+			IP: ip,
+		}
+		if n, err := parseNetwork(ip); err == nil && !strings.Contains(ip, "/") {
+			rt.ExternalIP.IP = n.String()
+		}
+	}
 
 	if count != 1 {
-		return nil, errors.New("only one of --app, --app-pool must be set")
+		return nil, nil, errors.New("only one of --src-app, --src-app-pool, --src-job, --src-rpaas, --src-service, --src-ip must be set")
 	}
 
-	return &rt, nil
+	warnings, problems := validateRuleType(&rt)
+	for i := range problems {
+		problems[i] = "source " + problems[i]
+	}
+	return &rt, warnings, problemsError(problems)
+}
+
+func parseRpaasInstance(value, flagName string) (*types.RpaasInstanceRule, error) {
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, errors.Errorf("--%s argument must be in the format serviceName/serviceInstance, e.g. \"rpaasv2-be/myinstance\"", flagName)
+	}
+	return &types.RpaasInstanceRule{
+		ServiceName: parts[0],
+		Instance:    parts[1],
+	}, nil
+}
+
+func parseKubernetesService(value, flagName string) (*types.KubernetesServiceRule, error) {
+	parts := strings.SplitN(value, "/", 2)
+	ns := "default"
+	var svcName string
+	if len(parts) == 2 {
+		ns, svcName = parts[0], parts[1]
+	} else {
+		svcName = parts[0]
+	}
+	if ns == "" || svcName == "" {
+		return nil, errors.Errorf("--%s argument must be in the format namespace/service or service, e.g. \"default/myservice\"", flagName)
+	}
+	return &types.KubernetesServiceRule{
+		Namespace:   ns,
+		ServiceName: svcName,
+	}, nil
 }
@@ -0,0 +1,471 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/tsuru/acl-api/api/types"
+	"golang.org/x/term"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// maxListedChoices limits how many candidates are listed at once.
+const maxListedChoices = 30
+
+var destinationFlagNames = []string{"ip", "dns", "url", "app", "app-pool", "rpaas", "service"}
+
+type wizardDestination struct {
+	Flag        string
+	Description string
+	Prompt      string
+	WithPorts   bool
+	Candidates  func(cmd *cobra.Command) []string
+	Parse       func(value string) (types.RuleType, error)
+}
+
+var wizardDestinations = []wizardDestination{
+	{Flag: "app", Description: "a tsuru app, following its units wherever they run", Prompt: "App name", Candidates: wizardCandidates(completeApps), Parse: func(value string) (types.RuleType, error) {
+		return types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: value}}, nil
+	}},
+	{Flag: "app-pool", Description: "every app of a tsuru pool, prefer single apps when possible", Prompt: "Pool name", Candidates: wizardCandidates(completePools), Parse: func(value string) (types.RuleType, error) {
+		return types.RuleType{TsuruApp: &types.TsuruAppRule{PoolName: value}}, nil
+	}},
+	{Flag: "rpaas", Description: "an RPAAS instance", Prompt: "RPAAS instance [service/instance]", Candidates: wizardCandidates(completeRpaasInstances), Parse: func(value string) (types.RuleType, error) {
+		rpaas, err := parseRpaasInstance(value, "rpaas")
+		return types.RuleType{RpaasInstance: rpaas}, err
+	}},
+	{Flag: "dns", Description: "an external service by DNS name, e.g. api.partner.com", Prompt: "DNS name", WithPorts: true, Parse: func(value string) (types.RuleType, error) {
+		dnsRule, err := parseDNSDestination(value, nil)
+		return types.RuleType{ExternalDNS: dnsRule}, err
+	}},
+	{Flag: "url", Description: "the host and port of a URL, e.g. https://api.partner.com:8443", Prompt: "URL", Parse: func(value string) (types.RuleType, error) {
+		rt, err := parseURLDestination(value, nil)
+		if err != nil {
+			return types.RuleType{}, err
+		}
+		return *rt, nil
+	}},
+	{Flag: "ip", Description: "an external IP or network, prefer DNS names when possible", Prompt: "IP or network", WithPorts: true, Parse: func(value string) (types.RuleType, error) {
+		ipRule, err := parseIPDestination(value, nil)
+		return types.RuleType{ExternalIP: ipRule}, err
+	}},
+}
+
+// shouldRunAddRuleWizard tells whether "rules add" was run on a terminal
+// without any destination flag.
+func shouldRunAddRuleWizard(flags *pflag.FlagSet) bool {
+	for _, name := range destinationFlagNames {
+		if flags.Changed(name) {
+			return false
+		}
+	}
+	return isInteractive()
+}
+
+// isInteractive tells whether both stdin and stdout are terminals, so the
+// user can answer questions.
+func isInteractive() bool {
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// runAddRuleWizard prompts for the instance, destination, ports, name and
+// expiration of a rule, setting the flags of cmd as if they were given in
+// the command line. It returns the arguments with the chosen instance.
+func runAddRuleWizard(cmd *cobra.Command, p *prompter, args []string) ([]string, error) {
+	flags := cmd.Flags()
+	fmt.Println("No destination given, answer the questions below to add a rule. Press Ctrl-C to cancel.")
+
+	if len(args) == 0 {
+		fmt.Println()
+		instance, err := p.Choose("Service instance", serviceInstances(cmd, defaultServiceName), true)
+		if err != nil {
+			return nil, err
+		}
+		args = []string{instance}
+	}
+
+	fmt.Println("\nWhere should the rule allow traffic to?")
+	for i, d := range wizardDestinations {
+		fmt.Printf("  %d) --%-9s %s\n", i+1, d.Flag, d.Description)
+	}
+	var dst wizardDestination
+	for {
+		answer, err := p.Ask("Destination type", "")
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(answer)
+		if err == nil && n >= 1 && n <= len(wizardDestinations) {
+			dst = wizardDestinations[n-1]
+			break
+		}
+		fmt.Printf("Choose a number from 1 to %d.\n", len(wizardDestinations))
+	}
+
+	fmt.Println()
+	var value string
+	var err error
+	if dst.Candidates != nil {
+		value, err = chooseTsuruValue(cmd, p, dst)
+	} else {
+		value, err = p.AskValid(dst.Prompt, "", func(answer string) error {
+			if answer == "" {
+				return errors.New("a value is required")
+			}
+			rt, parseErr := dst.Parse(answer)
+			if parseErr != nil {
+				return parseErr
+			}
+			_, problems := validateRuleType(&rt)
+			return problemsError(problems)
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = flags.Set(dst.Flag, value); err != nil {
+		return nil, err
+	}
+
+	if dst.WithPorts {
+		rawPorts, askErr := p.AskValid("Ports, separated by commas, empty for every port [tcp:443, tcp:8000-8010, tcp:https]", "", func(answer string) error {
+			_, portErr := parsePorts(splitAnswer(answer), "port")
+			return portErr
+		})
+		if askErr != nil {
+			return nil, askErr
+		}
+		for _, port := range splitAnswer(rawPorts) {
+			if err = flags.Set("port", port); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	name, err := p.AskValid("Rule name, optional [partner-api]", "", func(answer string) error {
+		if answer != "" && len(validation.IsDNS1123Subdomain(answer)) > 0 {
+			return errors.New("must have lowercase letters, numbers, dashes or dots, e.g. partner-api")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if name != "" {
+		if err = flags.Set("name", name); err != nil {
+			return nil, err
+		}
+	}
+
+	expiresIn, err := p.AskValid("Expire the rule after, optional [72h]", "", func(answer string) error {
+		if answer == "" {
+			return nil
+		}
+		d, parseErr := time.ParseDuration(answer)
+		if parseErr != nil || d <= 0 {
+			return errors.New("must be a positive duration, e.g. 72h or 30m")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if expiresIn != "" {
+		if err = flags.Set("expires-in", expiresIn); err != nil {
+			return nil, err
+		}
+	}
+	fmt.Println()
+	return args, nil
+}
+
+// chooseTsuruValue asks for one of the candidates of dst, setting
+// --skip-validation when a missing one is confirmed. The candidates are only
+// the ones visible to the token, others are checked in tsuru by name.
+func chooseTsuruValue(cmd *cobra.Command, p *prompter, dst wizardDestination) (string, error) {
+	candidates := dst.Candidates(cmd)
+	validator := newTsuruValidator(newClient(defaultServiceName))
+	for {
+		value, err := p.Choose(dst.Prompt, candidates, false)
+		if err != nil || len(candidates) == 0 || containsString(candidates, value) {
+			return value, err
+		}
+		rt, err := dst.Parse(value)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		problem, err := validator.Validate(cmd.Context(), rt)
+		if err != nil {
+			problem = err.Error()
+		}
+		if problem == "" {
+			return value, nil
+		}
+		fmt.Println(problem)
+		create, err := p.Confirm("Add the rule anyway, e.g. for one created later?", false)
+		if err != nil {
+			return "", err
+		}
+		if create {
+			return value, cmd.Flags().Set("skip-validation", "true")
+		}
+	}
+}
+
+// confirmRules previews the rules about to be added to instanceName and
+// asks for confirmation.
+func confirmRules(p *prompter, instanceName string, rules []types.Rule) (bool, error) {
+	fmt.Printf("Rules to add to %s:\n", instanceName)
+	for _, r := range rules {
+		fmt.Println()
+		previewRule(r)
+	}
+	fmt.Println()
+	return p.Confirm("Add the rules above?", true)
+}
+
+func previewRule(r types.Rule) {
+	fmt.Printf("Name: %s\n", ruleName(r))
+	fmt.Printf("Destination: %s\n", r.Destination.String())
+	keys := make([]string, 0, len(r.Metadata))
+	for k := range r.Metadata {
+		if k != metadataName {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	sort.Strings(keys)
+	fmt.Println("Metadata:")
+	for _, k := range keys {
+		fmt.Printf("  %v: %v\n", k, r.Metadata[k])
+	}
+}
+
+// equivalentCommand returns the command line running cmd with args and
+// the flags set, in the way the plugin is called by tsuru.
+func equivalentCommand(cmd *cobra.Command, args []string) string {
+	parts := []string{"tsuru", cmd.CommandPath()}
+	for _, arg := range args {
+		parts = append(parts, shellQuote(arg))
+	}
+	inherited := cmd.InheritedFlags()
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		// Inherited flags are plugin settings, e.g. the tsuru target and
+		// token, which must not be printed.
+		if inherited.Lookup(f.Name) != nil || f.Name == "tsuru.target" || f.Name == "tsuru.token" {
+			return
+		}
+		if f.Value.Type() == "bool" {
+			if f.Value.String() == "true" {
+				parts = append(parts, "--"+f.Name)
+			} else {
+				parts = append(parts, "--"+f.Name+"="+f.Value.String())
+			}
+			return
+		}
+		values := []string{f.Value.String()}
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			values = slice.GetSlice()
+		}
+		for _, v := range values {
+			parts = append(parts, "--"+f.Name, shellQuote(v))
+		}
+	})
+	return strings.Join(parts, " ")
+}
+
+var shellSafeRegexp = regexp.MustCompile(`^[a-zA-Z0-9_./:=@,+%-]+$`)
+
+func shellQuote(s string) string {
+	if shellSafeRegexp.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func splitAnswer(answer string) []string {
+	var values []string
+	for _, v := range strings.Split(answer, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// wizardCandidates adapts a completion function, dropping the descriptions
+// of its values.
+func wizardCandidates(complete func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective)) func(*cobra.Command) []string {
+	return func(cmd *cobra.Command) []string {
+		values, _ := complete(cmd, nil, "")
+		result := make([]string, len(values))
+		for i, v := range values {
+			result[i] = strings.SplitN(v, "\t", 2)[0]
+		}
+		return result
+	}
+}
+
+// prompter reads answers line by line, returning an error when ctx is
+// cancelled or the input is closed.
+type prompter struct {
+	ctx   context.Context
+	out   io.Writer
+	lines chan string
+	err   chan error
+}
+
+func newPrompter(ctx context.Context, in io.Reader, out io.Writer) *prompter {
+	p := &prompter{ctx: ctx, out: out, lines: make(chan string), err: make(chan error, 1)}
+	go func() {
+		reader := bufio.NewReader(in)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				p.err <- err
+				return
+			}
+			p.lines <- strings.TrimSpace(line)
+		}
+	}()
+	return p
+}
+
+// Ask prints question and returns the answer, or defaultValue for an
+// empty answer.
+func (p *prompter) Ask(question, defaultValue string) (string, error) {
+	if defaultValue != "" {
+		fmt.Fprintf(p.out, "%s (%s): ", question, defaultValue)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	select {
+	case line := <-p.lines:
+		if line == "" {
+			return defaultValue, nil
+		}
+		return line, nil
+	case err := <-p.err:
+		fmt.Fprintln(p.out)
+		if err == io.EOF {
+			return "", errors.New("input closed, rule not added")
+		}
+		return "", err
+	case <-p.ctx.Done():
+		return "", errors.New("rule not added")
+	}
+}
+
+// AskValid asks question until validate accepts the answer.
+func (p *prompter) AskValid(question, defaultValue string, validate func(string) error) (string, error) {
+	for {
+		answer, err := p.Ask(question, defaultValue)
+		if err != nil {
+			return "", err
+		}
+		if err = validate(answer); err == nil {
+			return answer, nil
+		}
+		fmt.Fprintf(p.out, "Invalid answer: %v\n", err)
+	}
+}
+
+func (p *prompter) Confirm(question string, defaultYes bool) (bool, error) {
+	options := "y/N"
+	if defaultYes {
+		options = "Y/n"
+	}
+	for {
+		answer, err := p.Ask(fmt.Sprintf("%s [%s]", question, options), "")
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return defaultYes, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
+
+// Choose asks for one of candidates, listing the ones starting with the
+// answer when it ends with "?". When strict, a unique prefix of a candidate
+// is accepted too. Otherwise the answer is returned as typed, even when it's
+// not a candidate, as candidates may not be everything that exists.
+func (p *prompter) Choose(question string, candidates []string, strict bool) (string, error) {
+	if len(candidates) > 0 && len(candidates) <= maxListedChoices {
+		p.list(candidates)
+	}
+	if len(candidates) > 0 {
+		question += ", ? to list"
+	}
+	for {
+		answer, err := p.Ask(question, "")
+		if err != nil {
+			return "", err
+		}
+		var matches []string
+		for _, c := range candidates {
+			if c == answer {
+				return c, nil
+			}
+			if strings.HasPrefix(c, strings.TrimSuffix(answer, "?")) {
+				matches = append(matches, c)
+			}
+		}
+		switch {
+		case answer == "":
+			fmt.Fprintln(p.out, "A value is required.")
+		case strings.HasSuffix(answer, "?"):
+			if len(matches) == 0 {
+				fmt.Fprintln(p.out, "Nothing found.")
+			}
+			p.list(matches)
+		case !strict || len(candidates) == 0:
+			return answer, nil
+		case len(matches) == 1:
+			fmt.Fprintf(p.out, "Using %s.\n", matches[0])
+			return matches[0], nil
+		case len(matches) > 1:
+			fmt.Fprintln(p.out, "More than one match:")
+			p.list(matches)
+		default:
+			msg := fmt.Sprintf("%q not found", answer)
+			if suggestions := closestMatches(answer, candidates); len(suggestions) > 0 {
+				msg += fmt.Sprintf(", did you mean %s?", strings.Join(suggestions, " or "))
+			}
+			fmt.Fprintln(p.out, msg)
+		}
+	}
+}
+
+func (p *prompter) list(values []string) {
+	for i, v := range values {
+		if i == maxListedChoices {
+			fmt.Fprintf(p.out, "  ... and %d more, type the beginning of a name followed by ? to filter\n", len(values)-maxListedChoices)
+			return
+		}
+		fmt.Fprintf(p.out, "  %s\n", v)
+	}
+}
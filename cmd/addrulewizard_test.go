@@ -0,0 +1,79 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestEquivalentCommand(t *testing.T) {
+	root := &cobra.Command{Use: "acl"}
+	root.PersistentFlags().String("tsuru.target", "", "")
+	root.PersistentFlags().String("tsuru.token", "", "")
+	rules := &cobra.Command{Use: "rules"}
+	add := &cobra.Command{Use: "add"}
+	add.Flags().String("app", "", "")
+	add.Flags().StringSlice("port", nil, "")
+	add.Flags().String("description", "", "")
+	add.Flags().Bool("skip-validation", false, "")
+	root.AddCommand(rules)
+	rules.AddCommand(add)
+	err := add.ParseFlags([]string{
+		"--tsuru.target", "https://tsuru.example.org",
+		"--tsuru.token", "secret",
+		"--app", "app1",
+		"--port", "tcp:443,tcp:8080",
+		"--description", "partner's API",
+		"--skip-validation",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `tsuru acl rules add acl inst1 --app app1 --description 'partner'\''s API' --port tcp:443 --port tcp:8080 --skip-validation`
+	if got := equivalentCommand(add, []string{"acl", "inst1"}); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPrompterChoose(t *testing.T) {
+	candidates := []string{"app1", "payments-api", "payments-web"}
+	tests := []struct {
+		name    string
+		input   string
+		strict  bool
+		want    string
+		wantOut string
+	}{
+		{name: "candidate", input: "app1\n", want: "app1"},
+		{name: "not a candidate", input: "billing\n", want: "billing"},
+		{name: "prefix of a candidate", input: "payments\n", want: "payments"},
+		{name: "unique prefix when strict", input: "payments-a\n", strict: true, want: "payments-api", wantOut: "Using payments-api."},
+		{name: "ambiguous prefix when strict", input: "payments\napp1\n", strict: true, want: "app1", wantOut: "More than one match:"},
+		{name: "missing when strict", input: "ap2\napp1\n", strict: true, want: "app1", wantOut: `"ap2" not found, did you mean app1?`},
+		{name: "listing", input: "payments?\napp1\n", want: "app1", wantOut: "  payments-api\n  payments-web\n"},
+		{name: "empty", input: "\napp1\n", want: "app1", wantOut: "A value is required."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			p := newPrompter(context.Background(), strings.NewReader(tt.input), &out)
+			got, err := p.Choose("App name", candidates, tt.strict)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if !strings.Contains(out.String(), tt.wantOut) {
+				t.Errorf("got output %q, want it to contain %q", out.String(), tt.wantOut)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-plugin/client"
+)
+
+// ruleDetails is the --json output of "admin show", with the rule and sync
+// information as sent by the API.
+type ruleDetails struct {
+	Rule      json.RawMessage
+	RulesSync json.RawMessage
+}
+
+var AdminShowRuleCmd = &cobra.Command{
+	Use:   "show [service name] [rule id]",
+	Short: "Show a rule with its metadata and every sync attempt",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		serviceName, ruleID := serviceInstanceName(args, 1)
+		c := newClient(serviceName)
+		jsonOutput, _ := cmd.Flags().GetBool("json")
+		if jsonOutput {
+			var details ruleDetails
+			var err error
+			if details.Rule, err = c.GetRuleJSON(cmd.Context(), ruleID); err != nil {
+				return err
+			}
+			if details.RulesSync, err = c.GetRuleSyncJSON(cmd.Context(), ruleID); err != nil {
+				return err
+			}
+			data, err := json.Marshal(details)
+			if err != nil {
+				return err
+			}
+			return printJSON(data)
+		}
+		r, err := c.GetRule(cmd.Context(), ruleID)
+		if err != nil {
+			return err
+		}
+		rulesSync, err := c.GetRuleSync(cmd.Context(), ruleID)
+		if err != nil {
+			return err
+		}
+		renderRule(*r)
+		fmt.Println("\nSync history:")
+		renderSyncHistory(rulesSync)
+		return nil
+	},
+}
+
+var AdminRemoveRuleCmd = &cobra.Command{
+	Use:   "remove [rule id]...",
+	Short: "Remove custom rules",
+	Long: `Remove custom rules directly from the ACL API.
+
+Rules belonging to service instances are refused, they must be removed with
+"tsuru acl rules remove" or they would be recreated by the instance.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, ruleIDs []string) error {
+		serviceName, _ := cmd.Flags().GetString("service")
+		c := newClient(serviceName)
+		summary := runBulk(cmd.Context(), ruleIDs, 1, func(ctx context.Context, i int, ruleID string) error {
+			fmt.Printf("%d/%d Removing rule %s\n", i+1, len(ruleIDs), ruleID)
+			r, err := c.GetRule(ctx, ruleID)
+			if err != nil {
+				return err
+			}
+			if err = checkCustomRule(*r); err != nil {
+				return err
+			}
+			return c.RemoveCustomRule(ctx, ruleID)
+		})
+		summary.Print(os.Stdout)
+		if len(summary.Failed()) > 0 || summary.Interrupted {
+			return errors.New("some rules were not removed")
+		}
+		return nil
+	},
+}
+
+var AdminSetOwnerCmd = &cobra.Command{
+	Use:   "set-owner [service name] [rule id] [owner]",
+	Short: "Change the owner of a custom rule",
+	Long: `Change the owner of a custom rule.
+
+As the ACL API has no way to update a rule, a new rule is created with the
+changes, once it's synced the old rule is removed. If the new rule cannot be
+created or synced the old rule is kept untouched. The rule ID changes.`,
+	Args: cobra.RangeArgs(2, 3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		serviceName, ruleID := serviceInstanceName(args, 2)
+		owner := args[len(args)-1]
+		if owner == "" {
+			return errors.New("owner must not be empty")
+		}
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		return updateCustomRuleMetadata(cmd.Context(), newClient(serviceName), ruleID, timeout, func(metadata map[string]string) {
+			metadata["owner"] = owner
+		})
+	},
+}
+
+var AdminSetMetadataCmd = &cobra.Command{
+	Use:   "set-metadata [service name] [rule id] [key=value]...",
+	Short: "Change the metadata of a custom rule",
+	Long: `Change the metadata of a custom rule.
+
+As the ACL API has no way to update a rule, a new rule is created with the
+changes, once it's synced the old rule is removed. If the new rule cannot be
+created or synced the old rule is kept untouched. The rule ID changes.`,
+	Example: `
+# Set the ticket and remove the reason of a rule
+tsuru acl admin set-metadata <RULE ID> ticket=INC-1234 --unset reason
+	`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		nameArgs := 0
+		for nameArgs < len(args) && !strings.Contains(args[nameArgs], "=") {
+			nameArgs++
+		}
+		if nameArgs == 0 || nameArgs > 2 {
+			return errors.New("expected an optional service name and a rule ID followed by key=value arguments")
+		}
+		serviceName, ruleID := serviceInstanceName(args[:nameArgs], 1)
+		toSet := map[string]string{}
+		for _, arg := range args[nameArgs:] {
+			parts := strings.SplitN(arg, "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return errors.Errorf("metadata arguments must be in the format <key>=<value>, got %q", arg)
+			}
+			toSet[parts[0]] = parts[1]
+		}
+		toUnset, _ := cmd.Flags().GetStringSlice("unset")
+		if len(toSet) == 0 && len(toUnset) == 0 {
+			return errors.New("nothing to change, at least one key=value argument or --unset must be set")
+		}
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		return updateCustomRuleMetadata(cmd.Context(), newClient(serviceName), ruleID, timeout, func(metadata map[string]string) {
+			for k, v := range toSet {
+				metadata[k] = v
+			}
+			for _, k := range toUnset {
+				delete(metadata, k)
+			}
+		})
+	},
+}
+
+func checkCustomRule(r types.Rule) error {
+	if r.Metadata["owner"] == ownerAclFromHell {
+		return errors.Errorf("rule %s belongs to service instance %q, use \"tsuru acl rules\" to manage it", r.RuleID, r.Metadata["instance-name"])
+	}
+	return nil
+}
+
+func updateCustomRuleMetadata(ctx context.Context, c *client.Client, ruleID string, timeout time.Duration, update func(map[string]string)) error {
+	oldRule, err := c.GetRule(ctx, ruleID)
+	if err != nil {
+		return err
+	}
+	if err = checkCustomRule(*oldRule); err != nil {
+		return err
+	}
+	if oldRule.Removed {
+		return errors.Errorf("rule %s is removed", ruleID)
+	}
+	metadata := map[string]string{}
+	for k, v := range oldRule.Metadata {
+		metadata[k] = v
+	}
+	update(metadata)
+	newRule, err := replaceCustomRule(ctx, c, *oldRule, metadata, timeout)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Rule successfully changed.")
+	renderRule(*newRule)
+	return nil
+}
+
+// replaceCustomRule replaces oldRule with a copy having metadata, following
+// the same steps as replaceRule.
+func replaceCustomRule(ctx context.Context, c *client.Client, oldRule types.Rule, metadata map[string]string, timeout time.Duration) (*types.Rule, error) {
+	if oldRule.RuleName != "" {
+		return nil, errors.Errorf("rule %s is named %q and the ACL API doesn't allow reusing names, even of removed rules, so it can't be replaced; remove it with \"tsuru acl admin remove\" and add it again", oldRule.RuleID, oldRule.RuleName)
+	}
+	var newRule *types.Rule
+	err := swapRule(ctx, oldRule.RuleID, func(ctx context.Context) (string, error) {
+		var err error
+		newRule, err = c.AddCustomRule(ctx, types.Rule{
+			Source:      oldRule.Source,
+			Destination: oldRule.Destination,
+			Metadata:    metadata,
+		})
+		if err != nil {
+			return "", err
+		}
+		return newRule.RuleID, nil
+	}, func(ctx context.Context, ruleID string) error {
+		return waitCustomRuleSync(ctx, c, ruleID, timeout)
+	}, c.RemoveCustomRule)
+	if err != nil {
+		return nil, err
+	}
+	return newRule, nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/acl-api/api/types"
+)
+
+func TestReplaceCustomRule(t *testing.T) {
+	tests := []struct {
+		name     string
+		syncErr  error
+		wantErr  string
+		wantKept bool
+	}{
+		{name: "synced"},
+		{name: "sync failed", syncErr: errors.New("boom"), wantErr: "removed and the old rule was kept", wantKept: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			srv, c := newTestServer(t)
+			srv.SetSyncFunc(func(r types.Rule, engine string) (interface{}, error) {
+				return nil, tt.syncErr
+			})
+			oldRule := types.Rule{
+				Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+				Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "example.org"}},
+				Metadata:    map[string]string{"owner": "team-a"},
+			}
+			oldRule.RuleID = srv.AddRule(oldRule)
+
+			newRule, err := replaceCustomRule(ctx, c, oldRule, map[string]string{"owner": "team-b"}, time.Second)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want it to contain %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			var active []string
+			for _, r := range srv.Rules() {
+				if !r.Removed {
+					active = append(active, r.RuleID+" "+r.Metadata["owner"])
+				}
+			}
+			want := oldRule.RuleID + " team-a"
+			if !tt.wantKept {
+				want = newRule.RuleID + " team-b"
+			}
+			if len(active) != 1 || active[0] != want {
+				t.Errorf("got active rules %q, want only %q", active, want)
+			}
+		})
+	}
+}
+
+func TestReplaceCustomRuleNamed(t *testing.T) {
+	srv, c := newTestServer(t)
+	_, err := replaceCustomRule(context.Background(), c, types.Rule{RuleID: "r1", RuleName: "partner"}, nil, time.Second)
+	if err == nil || !strings.Contains(err.Error(), `rule r1 is named "partner"`) {
+		t.Fatalf("got error %v, want the rule name refused", err)
+	}
+	if calls := srv.Calls(); len(calls) != 0 {
+		t.Errorf("got calls %v, want none", calls)
+	}
+}
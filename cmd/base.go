@@ -2,35 +2,33 @@ package cmd
 
 import (
 	"fmt"
-	"io"
-	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/Masterminds/semver"
-	"github.com/pkg/errors"
 	"github.com/spf13/viper"
 	"github.com/tsuru/acl-api/api/version"
+	"github.com/tsuru/acl-plugin/client"
 )
 
 const (
-	defaultServiceName = "acl"
-	userAgent          = "AclFromHell-Plugin-http-client/1.0"
+	defaultServiceName = client.DefaultServiceName
 )
 
 var (
 	baseClient = &http.Client{
-		Transport: &http.Transport{
-			Dial: (&net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 30 * time.Second,
-			}).Dial,
-			TLSHandshakeTimeout: 10 * time.Second,
-			IdleConnTimeout:     20 * time.Second,
+		Transport: &versionCheckTransport{
+			base: &http.Transport{
+				Dial: (&net.Dialer{
+					Timeout:   30 * time.Second,
+					KeepAlive: 30 * time.Second,
+				}).Dial,
+				TLSHandshakeTimeout: 10 * time.Second,
+				IdleConnTimeout:     20 * time.Second,
+			},
 		},
 		Timeout: time.Minute,
 	}
@@ -50,50 +48,31 @@ func serviceInstanceName(args []string, minArgs int) (string, string) {
 	return serviceName, instanceName
 }
 
-func doProxyAdminRequest(method, service, path string, body io.Reader) (*http.Response, error) {
-	baseURL := viper.GetString("tsuru.target")
-	fullUrl := fmt.Sprintf("%s/services/proxy/service/%s?callback=%s",
-		strings.TrimSuffix(baseURL, "/"),
-		service,
-		path,
+func newClient(serviceName string) *client.Client {
+	return client.New(
+		viper.GetString("tsuru.target"),
+		viper.GetString("tsuru.token"),
+		serviceName,
+		baseClient,
 	)
-	return doProxyURLRequest(method, fullUrl, body)
 }
 
-func doProxyRequest(method, service, instance, path string, body io.Reader) (*http.Response, error) {
-	baseURL := viper.GetString("tsuru.target")
-	fullUrl := fmt.Sprintf("%s/1.20/services/%s/resources/%s%s",
-		strings.TrimSuffix(baseURL, "/"),
-		service,
-		instance,
-		path,
-	)
-	return doProxyURLRequest(method, fullUrl, body)
+type versionCheckTransport struct {
+	base http.RoundTripper
 }
 
-func doProxyURLRequest(method, fullUrl string, body io.Reader) (*http.Response, error) {
-	token := viper.GetString("tsuru.token")
-	req, err := http.NewRequest(method, fullUrl, body)
-	if err != nil {
-		return nil, err
-	}
-	if body != nil {
-		req.Header.Set("Content-Type", "application/json")
-	}
-	req.Header.Set("Authorization", "bearer "+token)
-	req.Header.Set("User-Agent", userAgent)
-	rsp, err := baseClient.Do(req)
+// RoundTrip warns once about a newer ACL API version. Only ACL API responses
+// have the version header, tsuru API responses are ignored.
+func (t *versionCheckTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rsp, err := t.base.RoundTrip(req)
 	if err != nil {
 		return nil, err
 	}
-	if rsp.StatusCode < 200 || rsp.StatusCode >= 400 {
-		data, _ := ioutil.ReadAll(rsp.Body)
-		rsp.Body.Close()
-		return nil, errors.Errorf("invalid status code %d: %q", rsp.StatusCode, string(data))
+	if rsp.StatusCode >= 200 && rsp.StatusCode < 400 && rsp.Header.Get(version.VersionHeader) != "" {
+		warnOnce.Do(func() {
+			warnVersion(rsp.Header)
+		})
 	}
-	warnOnce.Do(func() {
-		warnVersion(rsp.Header)
-	})
 	return rsp, nil
 }
 
@@ -0,0 +1,53 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tsuru/acl-plugin/acltest"
+	"github.com/tsuru/acl-plugin/client"
+)
+
+func TestVersionCheckTransportIgnoresTsuruResponses(t *testing.T) {
+	srv := acltest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.SetVersion("999.0.0")
+	srv.AddServiceInstance("inst")
+	warnOnce = sync.Once{}
+	t.Cleanup(func() { warnOnce = sync.Once{} })
+	c := client.New(srv.URL, "token", "", &http.Client{
+		Transport: &versionCheckTransport{base: srv.Client().Transport},
+	})
+
+	stderr := os.Stderr
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stderr = w
+	t.Cleanup(func() { os.Stderr = stderr })
+	ctx := context.Background()
+	if _, err = c.ListTsuruApps(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.ListRules(ctx, "inst"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	output, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(output), "There is a new version of the acl plugin available") {
+		t.Errorf("got stderr %q, want the new version warning from the ACL API response", output)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// errSkipped is returned by the calls of runBulk which did nothing because
+// ctx was cancelled, they are reported as skipped.
+var errSkipped = errors.New("skipped")
+
+type bulkResult struct {
+	ID      string
+	Err     error
+	Skipped bool
+}
+
+type bulkSummary struct {
+	Results     []bulkResult
+	Interrupted bool
+}
+
+// runBulk calls fn for every id using at most concurrency goroutines. Once
+// ctx is cancelled no new calls are started, calls already in flight are
+// allowed to finish and every remaining id is reported as skipped, as well
+// as the ones whose call returned errSkipped.
+func runBulk(ctx context.Context, ids []string, concurrency int, fn func(ctx context.Context, i int, id string) error) *bulkSummary {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	summary := &bulkSummary{
+		Results: make([]bulkResult, len(ids)),
+	}
+	for i, id := range ids {
+		summary.Results[i] = bulkResult{ID: id, Skipped: true}
+	}
+	requestCtx := withoutCancel(ctx)
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		select {
+		case <-ctx.Done():
+		case sem <- struct{}{}:
+		}
+		if ctx.Err() != nil {
+			summary.Interrupted = true
+			break
+		}
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := fn(requestCtx, i, id)
+			if errors.Is(err, errSkipped) {
+				return
+			}
+			summary.Results[i] = bulkResult{ID: id, Err: err}
+		}(i, id)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		summary.Interrupted = true
+	}
+	return summary
+}
+
+func (s *bulkSummary) Failed() []bulkResult {
+	var failed []bulkResult
+	for _, r := range s.Results {
+		if r.Err != nil {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+func (s *bulkSummary) Skipped() []bulkResult {
+	var skipped []bulkResult
+	for _, r := range s.Results {
+		if r.Skipped {
+			skipped = append(skipped, r)
+		}
+	}
+	return skipped
+}
+
+func (s *bulkSummary) Print(w io.Writer) {
+	failed := s.Failed()
+	skipped := s.Skipped()
+	succeeded := len(s.Results) - len(failed) - len(skipped)
+	if s.Interrupted {
+		fmt.Fprintln(w, "\nInterrupted, no new requests were sent.")
+	}
+	fmt.Fprintf(w, "\n%d succeeded, %d failed, %d skipped.\n", succeeded, len(failed), len(skipped))
+	for _, r := range failed {
+		fmt.Fprintf(w, "Failed %s: %v\n", r.ID, r.Err)
+	}
+	for _, r := range skipped {
+		fmt.Fprintf(w, "Skipped %s\n", r.ID)
+	}
+}
+
+// detachedContext keeps the values of its parent but is never cancelled, it
+// allows requests already sent to finish after the user interrupts a command.
+type detachedContext struct {
+	parent context.Context
+}
+
+func withoutCancel(parent context.Context) context.Context {
+	return detachedContext{parent: parent}
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// bulkProgress prints a line as each call of a bulk operation finishes, it's
+// safe to use from the goroutines started by runBulk.
+type bulkProgress struct {
+	mu    sync.Mutex
+	w     io.Writer
+	total int
+	done  int
+}
+
+func newBulkProgress(w io.Writer, total int) *bulkProgress {
+	return &bulkProgress{w: w, total: total}
+}
+
+func (p *bulkProgress) Done(id string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	if errors.Is(err, errSkipped) {
+		fmt.Fprintf(p.w, "[%d/%d] %s skipped\n", p.done, p.total, id)
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(p.w, "[%d/%d] %s failed: %v\n", p.done, p.total, id, err)
+		return
+	}
+	fmt.Fprintf(p.w, "[%d/%d] %s done\n", p.done, p.total, id)
+}
+
+// rateLimiter spaces calls of a bulk operation so at most perSecond calls
+// start each second. A nil rateLimiter doesn't limit anything.
+type rateLimiter struct {
+	ticker *time.Ticker
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{ticker: time.NewTicker(time.Duration(float64(time.Second) / perSecond))}
+}
+
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-l.ticker.C:
+		return nil
+	}
+}
+
+func (l *rateLimiter) Stop() {
+	if l != nil {
+		l.ticker.Stop()
+	}
+}
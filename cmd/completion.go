@@ -0,0 +1,339 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	completionCacheTTL = time.Minute
+	completionTimeout  = 5 * time.Second
+)
+
+var CompletionCmd = &cobra.Command{
+	Use:   "completion [bash|zsh|fish]",
+	Short: "Generate the shell completion script",
+	Long: `Generate the completion script of the plugin for bash, zsh or fish.
+
+Completion works when the plugin is called directly as "acl", e.g. through an
+alias to the plugin binary, with TSURU_TARGET and TSURU_TOKEN set. Service
+instances, rule IDs, apps, pools and rpaas instances are completed from the
+ACL and tsuru APIs and cached for a minute.`,
+	Example: `
+# Load completion in the current bash session
+alias acl=~/.tsuru/plugins/acl
+source <(acl completion bash)
+
+# Load completion in every zsh session
+acl completion zsh > "${fpath[1]}/_acl"
+
+# Load completion in every fish session
+acl completion fish > ~/.config/fish/completions/acl.fish
+	`,
+	ValidArgs: []string{"bash", "zsh", "fish"},
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		root := cmd.Root()
+		switch args[0] {
+		case "bash":
+			return root.GenBashCompletionV2(os.Stdout, true)
+		case "zsh":
+			return root.GenZshCompletion(os.Stdout)
+		}
+		return root.GenFishCompletion(os.Stdout, true)
+	},
+}
+
+// RegisterCompletions sets the completion functions of the arguments and
+// flags of every command, it must be called after flags are added.
+func RegisterCompletions() {
+	for _, c := range []*cobra.Command{AddRuleCmd, ListRuleCmd, ExpireRulesCmd, ImportRulesCmd, RenderRuleCmd, WaitRulesCmd} {
+		c.ValidArgsFunction = completeInstanceArgs
+	}
+	for _, c := range []*cobra.Command{RemoveRuleCmd, ShowRuleCmd, EditRuleCmd} {
+		c.ValidArgsFunction = completeRuleArgs
+	}
+	AdminShowRuleCmd.ValidArgsFunction = completeAdminRuleArgs
+	AdminSetOwnerCmd.ValidArgsFunction = completeAdminRuleArgs
+	AdminSetMetadataCmd.ValidArgsFunction = completeAdminRuleArgs
+	AdminRemoveRuleCmd.ValidArgsFunction = completeFlagAdminRules
+	ForceSyncCmd.ValidArgsFunction = func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return completeApps(cmd, args, toComplete)
+	}
+
+	flagCompletions := map[string]func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective){
+		"app":          completeApps,
+		"src-app":      completeApps,
+		"app-pool":     completePools,
+		"src-app-pool": completePools,
+		"pool":         completePools,
+		"rpaas":        completeRpaasInstances,
+		"src-rpaas":    completeRpaasInstances,
+		"instance":     completeFlagInstances,
+		"rule-id":      completeFlagAdminRules,
+		"id":           completeFlagRules,
+		"rule":         completeFlagRules,
+	}
+	for _, c := range []*cobra.Command{AddRuleCmd, AddCustomRuleCmd, RenderRuleCmd, ForceSyncCmd, WaitRulesCmd} {
+		for name, fn := range flagCompletions {
+			if c.Flags().Lookup(name) == nil {
+				continue
+			}
+			// Flags shared between commands are registered only once.
+			_ = c.RegisterFlagCompletionFunc(name, fn)
+		}
+	}
+}
+
+// completeInstanceArgs completes "[service name] [instance name]".
+func completeInstanceArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	switch len(args) {
+	case 0:
+		return serviceInstances(cmd, defaultServiceName), cobra.ShellCompDirectiveNoFileComp
+	case 1:
+		return serviceInstances(cmd, args[0]), cobra.ShellCompDirectiveNoFileComp
+	}
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeRuleArgs completes "[service name] [instance name] [id]".
+func completeRuleArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	switch len(args) {
+	case 0:
+		return serviceInstances(cmd, defaultServiceName), cobra.ShellCompDirectiveNoFileComp
+	case 1:
+		values := instanceRules(cmd, defaultServiceName, args[0])
+		values = append(values, serviceInstances(cmd, args[0])...)
+		return values, cobra.ShellCompDirectiveNoFileComp
+	case 2:
+		return instanceRules(cmd, args[0], args[1]), cobra.ShellCompDirectiveNoFileComp
+	}
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeAdminRuleArgs completes the rule ID in the first argument, or in
+// the second one after a service name.
+func completeAdminRuleArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	defaultRules := adminRules(cmd, defaultServiceName)
+	switch {
+	case len(args) == 0:
+		return defaultRules, cobra.ShellCompDirectiveNoFileComp
+	case len(args) == 1 && !hasCompletionValue(defaultRules, args[0]):
+		return adminRules(cmd, args[0]), cobra.ShellCompDirectiveNoFileComp
+	}
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
+
+// hasCompletionValue tells whether value is one of values, ignoring their
+// descriptions.
+func hasCompletionValue(values []string, value string) bool {
+	for _, v := range values {
+		if strings.SplitN(v, "\t", 2)[0] == value {
+			return true
+		}
+	}
+	return false
+}
+
+func completeApps(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return completionValues(cmd, "apps", func(ctx context.Context) ([]string, error) {
+		apps, err := newClient(defaultServiceName).ListTsuruApps(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var values []string
+		for _, a := range apps {
+			values = append(values, a.Name+"\t"+a.Pool)
+		}
+		return values, nil
+	}), cobra.ShellCompDirectiveNoFileComp
+}
+
+func completePools(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return completionValues(cmd, "pools", func(ctx context.Context) ([]string, error) {
+		pools, err := newClient(defaultServiceName).ListTsuruPools(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var values []string
+		for _, p := range pools {
+			values = append(values, p.Name)
+		}
+		return values, nil
+	}), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeRpaasInstances completes "<service>/<instance>" with the instances
+// of every service whose name has "rpaas".
+func completeRpaasInstances(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return completionValues(cmd, "rpaas", func(ctx context.Context) ([]string, error) {
+		services, err := newClient(defaultServiceName).ListTsuruServices(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var values []string
+		for _, s := range services {
+			if !strings.Contains(s.Service, "rpaas") {
+				continue
+			}
+			for _, instance := range s.Instances {
+				values = append(values, s.Service+"/"+instance)
+			}
+		}
+		return values, nil
+	}), cobra.ShellCompDirectiveNoFileComp
+}
+
+func completeFlagInstances(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	serviceName, _ := cmd.Flags().GetString("service")
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	return serviceInstances(cmd, serviceName), cobra.ShellCompDirectiveNoFileComp
+}
+
+func completeFlagAdminRules(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	serviceName, _ := cmd.Flags().GetString("service")
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	return adminRules(cmd, serviceName), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeFlagRules completes the rule IDs of the instance in the
+// "[service name] [instance name]" arguments.
+func completeFlagRules(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) == 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	serviceName, instanceName := serviceInstanceName(args, 1)
+	return instanceRules(cmd, serviceName, instanceName), cobra.ShellCompDirectiveNoFileComp
+}
+
+func serviceInstances(cmd *cobra.Command, serviceName string) []string {
+	return completionValues(cmd, "instances/"+serviceName, func(ctx context.Context) ([]string, error) {
+		return newClient(serviceName).ListTsuruServiceInstances(ctx, serviceName)
+	})
+}
+
+func instanceRules(cmd *cobra.Command, serviceName, instanceName string) []string {
+	return completionValues(cmd, "rules/"+serviceName+"/"+instanceName, func(ctx context.Context) ([]string, error) {
+		ruleData, err := newClient(serviceName).ListRules(ctx, instanceName)
+		if err != nil {
+			return nil, err
+		}
+		var values []string
+		for _, r := range ruleData.ServiceInstance.BaseRules {
+			values = append(values, r.RuleID+"\t"+r.Destination.String())
+		}
+		return values, nil
+	})
+}
+
+func adminRules(cmd *cobra.Command, serviceName string) []string {
+	return completionValues(cmd, "admin-rules/"+serviceName, func(ctx context.Context) ([]string, error) {
+		rules, err := newClient(serviceName).ListAllRules(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var values []string
+		for _, r := range rules {
+			if !r.Removed {
+				values = append(values, r.RuleID+"\t"+r.Source.String()+" -> "+r.Destination.String())
+			}
+		}
+		return values, nil
+	})
+}
+
+// completionCache is a list of completions saved on disk.
+type completionCache struct {
+	Created time.Time
+	Values  []string
+}
+
+// completionValues returns the values cached for key, calling fetch and
+// caching its result when the cache is missing or older than
+// completionCacheTTL. Errors are only logged to the completion debug log.
+func completionValues(cmd *cobra.Command, key string, fetch func(ctx context.Context) ([]string, error)) []string {
+	path, err := completionCachePath(key)
+	if err == nil {
+		var cache completionCache
+		data, readErr := ioutil.ReadFile(path)
+		if readErr == nil && json.Unmarshal(data, &cache) == nil && time.Since(cache.Created) < completionCacheTTL {
+			return cache.Values
+		}
+	}
+	ctx := cmd.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, completionTimeout)
+	defer cancel()
+	values, fetchErr := fetch(ctx)
+	if fetchErr != nil {
+		cobra.CompDebugln("unable to complete "+key+": "+fetchErr.Error(), true)
+		return nil
+	}
+	sort.Strings(values)
+	if err == nil {
+		err = saveCompletionCache(path, completionCache{Created: time.Now(), Values: values})
+	}
+	if err != nil {
+		cobra.CompDebugln("unable to cache completions: "+err.Error(), true)
+	}
+	return values
+}
+
+// completionCachePath returns the cache file of key, which is specific to
+// the tsuru target and token.
+func completionCachePath(key string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256([]byte(viper.GetString("tsuru.target") + "\x00" + viper.GetString("tsuru.token") + "\x00" + key))
+	return filepath.Join(dir, "tsuru-acl", "completion-"+hex.EncodeToString(hash[:8])+".json"), nil
+}
+
+func saveCompletionCache(path string, cache completionCache) error {
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrap(err, "unable to create cache directory")
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
@@ -0,0 +1,201 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-plugin/acltest"
+)
+
+// newCompletionTest points completions at a new fake server, caching them
+// in a temporary directory.
+func newCompletionTest(t *testing.T) *acltest.Server {
+	t.Helper()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	srv, _ := newTestServer(t)
+	target, token := viper.GetString("tsuru.target"), viper.GetString("tsuru.token")
+	viper.Set("tsuru.target", srv.URL)
+	viper.Set("tsuru.token", "token")
+	t.Cleanup(func() {
+		viper.Set("tsuru.target", target)
+		viper.Set("tsuru.token", token)
+	})
+	return srv
+}
+
+func TestCompletionValuesCache(t *testing.T) {
+	newCompletionTest(t)
+	cmd := &cobra.Command{}
+	fetches := 0
+	fetch := func(ctx context.Context) ([]string, error) {
+		fetches++
+		return []string{"b", "a"}, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		if got := completionValues(cmd, "key", fetch); !reflect.DeepEqual(got, []string{"a", "b"}) {
+			t.Errorf("got %q, want the sorted values", got)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("got %d fetches, want the second call to use the cache", fetches)
+	}
+
+	completionValues(cmd, "other-key", fetch)
+	if fetches != 2 {
+		t.Errorf("got %d fetches, want keys cached apart", fetches)
+	}
+
+	viper.Set("tsuru.token", "other-token")
+	completionValues(cmd, "key", fetch)
+	if fetches != 3 {
+		t.Errorf("got %d fetches, want tokens cached apart", fetches)
+	}
+	viper.Set("tsuru.token", "token")
+
+	path, err := completionCachePath("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(completionCache{Created: time.Now().Add(-completionCacheTTL), Values: []string{"old"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := completionValues(cmd, "key", fetch); !reflect.DeepEqual(got, []string{"a", "b"}) || fetches != 4 {
+		t.Errorf("got %q after %d fetches, want the expired cache fetched again", got, fetches)
+	}
+}
+
+func TestCompletionValuesFetchError(t *testing.T) {
+	newCompletionTest(t)
+	cmd := &cobra.Command{}
+	fetches := 0
+	fetch := func(ctx context.Context) ([]string, error) {
+		fetches++
+		return nil, errors.New("boom")
+	}
+	for i := 0; i < 2; i++ {
+		if got := completionValues(cmd, "key", fetch); got != nil {
+			t.Errorf("got %q, want no values", got)
+		}
+	}
+	if fetches != 2 {
+		t.Errorf("got %d fetches, want errors not cached", fetches)
+	}
+}
+
+func TestSaveCompletionCacheLeavesNoTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/cache/completion.json"
+	for i := 0; i < 2; i++ {
+		if err := saveCompletionCache(path, completionCache{Values: []string{"a"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := os.ReadDir(dir + "/cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "completion.json" {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("got files %q, want only the cache", names)
+	}
+}
+
+func completionNames(values []string) []string {
+	names := make([]string, len(values))
+	for i, v := range values {
+		names[i] = strings.SplitN(v, "\t", 2)[0]
+	}
+	return names
+}
+
+func TestCompleteRuleArgs(t *testing.T) {
+	srv := newCompletionTest(t)
+	srv.AddTsuruServiceInstance("acl", "inst1")
+	srv.AddTsuruServiceInstance("acl", "inst2")
+	srv.AddTsuruServiceInstance("acl-dev", "dev1")
+	srv.AddServiceInstance("inst1")
+	srv.AddServiceInstance("dev1")
+	c := newClient(defaultServiceName)
+	ctx := context.Background()
+	rule := types.Rule{Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "example.org"}}}
+	instRule, err := c.AddRule(ctx, "inst1", rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devRule, err := c.AddRule(ctx, "dev1", rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "instance", want: []string{"inst1", "inst2"}},
+		{name: "rule of the default service or instance of a service", args: []string{"inst1"}, want: []string{instRule.RuleID}},
+		{name: "instance of a service", args: []string{"acl-dev"}, want: []string{"dev1"}},
+		{name: "rule of a service", args: []string{"acl-dev", "dev1"}, want: []string{devRule.RuleID}},
+		{name: "after the rule", args: []string{"acl-dev", "dev1", devRule.RuleID}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, directive := completeRuleArgs(&cobra.Command{}, tt.args, "")
+			if names := completionNames(got); !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("got %q, want %q", names, tt.want)
+			}
+			if directive != cobra.ShellCompDirectiveNoFileComp {
+				t.Errorf("got directive %v, want no file completion", directive)
+			}
+		})
+	}
+}
+
+func TestCompleteAdminRuleArgs(t *testing.T) {
+	srv := newCompletionTest(t)
+	ruleID := srv.AddRule(types.Rule{Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "example.org"}}})
+	tests := []struct {
+		name      string
+		args      []string
+		want      []string
+		wantCalls int
+	}{
+		{name: "rule of the default service", want: []string{ruleID}, wantCalls: 1},
+		{name: "after a rule of the default service", args: []string{ruleID}, want: []string{}},
+		{name: "rule of a service", args: []string{"acl-dev"}, want: []string{ruleID}, wantCalls: 1},
+		{name: "after a rule of a service", args: []string{"acl-dev", ruleID}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := len(srv.Calls())
+			got, _ := completeAdminRuleArgs(&cobra.Command{}, tt.args, "")
+			if names := completionNames(got); !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("got %q, want %q", names, tt.want)
+			}
+			if n := len(srv.Calls()) - calls; n != tt.wantCalls {
+				t.Errorf("got %d calls, want %d", n, tt.wantCalls)
+			}
+		})
+	}
+}
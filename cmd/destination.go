@@ -0,0 +1,113 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/acl-api/api/types"
+)
+
+// parseIPDestination parses an --ip argument, a network, an IP, defaulting
+// to /32 or /128, or an IP with a port, e.g. "10.1.2.3:443". Networks are
+// canonicalized by validateRuleType.
+func parseIPDestination(raw string, ports types.ProtoPorts) (*types.ExternalIPRule, error) {
+	host, port, err := splitDestinationPort(raw)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid --ip %q", raw)
+	}
+	n, err := parseNetwork(host)
+	if err != nil {
+		if isDNSName(host) {
+			return nil, errors.Errorf("invalid --ip %q: it's a DNS name, use --dns %s", raw, raw)
+		}
+		return nil, errors.Errorf("invalid --ip %q: must be an IP or a network, e.g. 10.0.0.1 or 10.0.0.0/24", raw)
+	}
+	ip := n.String()
+	if strings.Contains(host, "/") {
+		ip = host
+	}
+	return &types.ExternalIPRule{IP: ip, Ports: withPort(ports, port)}, nil
+}
+
+// parseDNSDestination parses a --dns argument, a name or a name with a
+// port, e.g. "example.org:443". Names are validated by validateRuleType.
+func parseDNSDestination(raw string, ports types.ProtoPorts) (*types.ExternalDNSRule, error) {
+	if strings.Contains(raw, "://") {
+		return nil, errors.Errorf("invalid --dns %q: it's a URL, use --url %s", raw, raw)
+	}
+	host, port, err := splitDestinationPort(raw)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid --dns %q", raw)
+	}
+	if _, err = parseNetwork(host); err == nil {
+		return nil, errors.Errorf("invalid --dns %q: it's an IP, use --ip %s", raw, raw)
+	}
+	return &types.ExternalDNSRule{Name: host, Ports: withPort(ports, port)}, nil
+}
+
+// parseURLDestination parses a --url argument into a DNS or IP destination
+// to the host of the URL, on its port or the default port of its scheme.
+func parseURLDestination(raw string, ports types.ProtoPorts) (*types.RuleType, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Hostname() == "" {
+		return nil, errors.Errorf("invalid --url %q: must be an absolute URL, e.g. https://example.org:8443/path", raw)
+	}
+	rawPort := u.Port()
+	if rawPort == "" {
+		rawPort = strings.ToLower(u.Scheme)
+	}
+	port, err := parsePortNumber(rawPort)
+	if err != nil {
+		return nil, errors.Errorf("invalid --url %q: unknown default port for scheme %q, add the port to the URL", raw, u.Scheme)
+	}
+	urlPort := &types.ProtoPort{Protocol: "tcp", Port: uint16(port)}
+	host := u.Hostname()
+	if n, err := parseNetwork(host); err == nil {
+		return &types.RuleType{ExternalIP: &types.ExternalIPRule{IP: n.String(), Ports: withPort(ports, urlPort)}}, nil
+	}
+	return &types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: host, Ports: withPort(ports, urlPort)}}, nil
+}
+
+// splitDestinationPort splits an optional tcp port from raw. IPv6 addresses
+// with a port must be in brackets, e.g. "[2001:db8::1]:443".
+func splitDestinationPort(raw string) (string, *types.ProtoPort, error) {
+	host, rawPort, err := net.SplitHostPort(raw)
+	if err != nil {
+		return raw, nil, nil
+	}
+	port, err := parsePortNumber(rawPort)
+	if err != nil {
+		return "", nil, err
+	}
+	return host, &types.ProtoPort{Protocol: "tcp", Port: uint16(port)}, nil
+}
+
+// withPort returns a copy of ports with port, if it's not nil.
+func withPort(ports types.ProtoPorts, port *types.ProtoPort) types.ProtoPorts {
+	result := append(types.ProtoPorts{}, ports...)
+	if port != nil && !containsPort(result, *port) {
+		result = append(result, *port)
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+func isDNSName(name string) bool {
+	if name == "" || strings.ContainsAny(name, "/:") {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			return false
+		}
+	}
+	return strings.ContainsAny(name, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+}
@@ -0,0 +1,314 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-plugin/client"
+)
+
+var syncPollInterval = 2 * time.Second
+
+var EditRuleCmd = &cobra.Command{
+	Use:   "edit [service name] [instance name] [id]",
+	Short: "Change the ports of an existing rule",
+	Long: `Change the ports of an existing rule.
+
+As the ACL API has no way to update a rule, a new rule is created with the
+changes, once it's synced the old rule is removed. If the new rule cannot be
+created or synced the old rule is kept untouched. The rule ID changes.`,
+	Example: `
+# Replace every port of a rule
+tsuru acl rules edit <ACL SERVICE> <RULE ID> --port tcp:8443
+
+# Add and remove ports of a rule
+tsuru acl rules edit <ACL SERVICE> <RULE ID> --add-port tcp:8443 --remove-port tcp:443
+	`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		serviceName, instanceName := serviceInstanceName(args, 2)
+		ruleID := args[len(args)-1]
+		c := newClient(serviceName)
+
+		ruleData, err := c.ListRules(cmd.Context(), instanceName)
+		if err != nil {
+			return err
+		}
+		var oldRule *types.ServiceRule
+		for i, r := range ruleData.ServiceInstance.BaseRules {
+			if r.RuleID == ruleID {
+				oldRule = &ruleData.ServiceInstance.BaseRules[i]
+				break
+			}
+		}
+		if oldRule == nil {
+			return errors.Errorf("rule %q not found in instance %q", ruleID, instanceName)
+		}
+
+		dst, err := editRuleType(oldRule.Destination, cmd.Flags())
+		if err != nil {
+			return err
+		}
+		warnings, problems := validateRuleType(dst)
+		printWarnings(warnings)
+		if err = problemsError(problems); err != nil {
+			return err
+		}
+		if dst.String() == oldRule.Destination.String() {
+			return errors.New("nothing to change, the rule already has the requested ports")
+		}
+
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		newRule, err := replaceRule(cmd.Context(), c, instanceName, *oldRule, *dst, timeout)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Rule successfully changed, new rule ID: %s\n", newRule.RuleID)
+		return nil
+	},
+}
+
+// editRuleType returns a copy of rt with the ports changed according to the
+// --port, --add-port and --remove-port flags.
+func editRuleType(rt types.RuleType, flags *pflag.FlagSet) (*types.RuleType, error) {
+	var ports *types.ProtoPorts
+	if rt.ExternalIP != nil {
+		ipRule := *rt.ExternalIP
+		rt.ExternalIP = &ipRule
+		ports = &rt.ExternalIP.Ports
+	}
+	if rt.ExternalDNS != nil {
+		dnsRule := *rt.ExternalDNS
+		rt.ExternalDNS = &dnsRule
+		ports = &rt.ExternalDNS.Ports
+	}
+	if ports == nil {
+		return nil, errors.Errorf("only --ip and --dns rules have ports, cannot edit rule with destination %q", rt.String())
+	}
+
+	newPorts := append(types.ProtoPorts(nil), (*ports)...)
+	if flags.Changed("port") {
+		rawPorts, _ := flags.GetStringSlice("port")
+		parsed, err := parsePorts(rawPorts, "port")
+		if err != nil {
+			return nil, err
+		}
+		newPorts = parsed
+	}
+
+	rawPorts, _ := flags.GetStringSlice("add-port")
+	toAdd, err := parsePorts(rawPorts, "add-port")
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range toAdd {
+		if !containsPort(newPorts, p) {
+			newPorts = append(newPorts, p)
+		}
+	}
+
+	rawPorts, _ = flags.GetStringSlice("remove-port")
+	toRemove, err := parsePorts(rawPorts, "remove-port")
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range toRemove {
+		if !containsPort(newPorts, p) {
+			return nil, errors.Errorf("cannot remove port %s, the rule has no such port", p.String())
+		}
+		filtered := types.ProtoPorts{}
+		for _, existing := range newPorts {
+			if !samePort(existing, p) {
+				filtered = append(filtered, existing)
+			}
+		}
+		newPorts = filtered
+	}
+	if len(newPorts) == 0 {
+		return nil, errors.New("the rule must keep at least one port, a rule without ports allows every port; remove the rule with \"tsuru acl rules remove\" instead")
+	}
+
+	*ports = newPorts
+	return &rt, nil
+}
+
+func samePort(a, b types.ProtoPort) bool {
+	return a.Port == b.Port && strings.EqualFold(a.Protocol, b.Protocol)
+}
+
+func containsPort(ports []types.ProtoPort, p types.ProtoPort) bool {
+	for _, existing := range ports {
+		if samePort(existing, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceRule replaces oldRule with a new rule to dst. The new rule is
+// created first and only after it's synced the old rule is removed, so
+// traffic allowed by both rules is never denied. If the new rule fails to sync
+// it's removed and the old rule is kept.
+func replaceRule(ctx context.Context, c *client.Client, instanceName string, oldRule types.ServiceRule, dst types.RuleType, timeout time.Duration) (*types.ServiceRule, error) {
+	if err := checkReplaceable(oldRule); err != nil {
+		return nil, err
+	}
+	var newRule *types.ServiceRule
+	err := swapRule(ctx, oldRule.RuleID, func(ctx context.Context) (string, error) {
+		var err error
+		newRule, err = c.AddRule(ctx, instanceName, types.Rule{
+			Destination: dst,
+			Metadata:    oldRule.Metadata,
+		})
+		if err != nil {
+			return "", err
+		}
+		return newRule.RuleID, nil
+	}, func(ctx context.Context, ruleID string) error {
+		return waitRuleSync(ctx, c, instanceName, ruleID, timeout)
+	}, func(ctx context.Context, ruleID string) error {
+		return c.RemoveRule(ctx, instanceName, ruleID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newRule, nil
+}
+
+// swapRule creates a rule with add and waits for it to be synced with wait
+// before removing oldRuleID with remove. When the new rule isn't synced it's
+// removed and the old rule is kept.
+func swapRule(ctx context.Context, oldRuleID string, add func(context.Context) (string, error), wait, remove func(context.Context, string) error) error {
+	newRuleID, err := add(ctx)
+	if err != nil {
+		return errors.Wrap(err, "unable to create new rule, the old rule was kept")
+	}
+	fmt.Printf("Rule %s created, waiting for it to be synced...\n", newRuleID)
+
+	err = wait(ctx, newRuleID)
+	if err != nil {
+		rollbackErr := remove(withoutCancel(ctx), newRuleID)
+		if rollbackErr != nil {
+			return errors.Errorf("%v, unable to remove new rule %s: %v", err, newRuleID, rollbackErr)
+		}
+		return errors.Wrapf(err, "new rule %s removed and the old rule was kept", newRuleID)
+	}
+
+	err = remove(ctx, oldRuleID)
+	if err != nil {
+		return errors.Wrapf(err, "new rule %s created but unable to remove old rule %s", newRuleID, oldRuleID)
+	}
+	return nil
+}
+
+// checkReplaceable returns an error when the ACL API would refuse a new rule
+// while oldRule exists. Rule names stay in use after the rule is removed,
+// and a rule without ports is a duplicate of any rule to its destination.
+func checkReplaceable(oldRule types.ServiceRule) error {
+	if oldRule.RuleName != "" {
+		return errors.Errorf("rule %s is named %q and the ACL API doesn't allow reusing names, even of removed rules, so it can't be replaced; remove it with \"tsuru acl rules remove\" and add it again", oldRule.RuleID, oldRule.RuleName)
+	}
+	var ports types.ProtoPorts
+	switch {
+	case oldRule.Destination.ExternalDNS != nil:
+		ports = oldRule.Destination.ExternalDNS.Ports
+	case oldRule.Destination.ExternalIP != nil:
+		ports = oldRule.Destination.ExternalIP.Ports
+	default:
+		return nil
+	}
+	if len(ports) == 0 {
+		return errors.Errorf("rule %s allows every port and the ACL API considers any rule to %s a duplicate of it, so it can't be replaced; remove it with \"tsuru acl rules remove\" and add it again with the ports", oldRule.RuleID, oldRule.Destination.String())
+	}
+	return nil
+}
+
+// waitRuleSync waits until every expanded rule of the base rule ruleID has a
+// successful sync in every engine.
+func waitRuleSync(ctx context.Context, c *client.Client, instanceName, ruleID string, timeout time.Duration) error {
+	return pollRulesSynced(ctx, ruleID, timeout, func(ctx context.Context) ([]string, []types.RuleSyncInfo, error) {
+		ruleData, err := c.ListRules(ctx, instanceName)
+		if err != nil {
+			return nil, nil, err
+		}
+		var ruleIDs []string
+		for _, r := range ruleData.ExpandedRules {
+			if r.Metadata["base-ruleid"] == ruleID && !r.Removed {
+				ruleIDs = append(ruleIDs, r.RuleID)
+			}
+		}
+		return ruleIDs, ruleData.RulesSync, nil
+	})
+}
+
+// waitCustomRuleSync waits until ruleID has a successful sync in every
+// engine.
+func waitCustomRuleSync(ctx context.Context, c *client.Client, ruleID string, timeout time.Duration) error {
+	return pollRulesSynced(ctx, ruleID, timeout, func(ctx context.Context) ([]string, []types.RuleSyncInfo, error) {
+		rulesSync, err := c.GetRuleSync(ctx, ruleID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []string{ruleID}, rulesSync, nil
+	})
+}
+
+func pollRulesSynced(ctx context.Context, ruleID string, timeout time.Duration, fetch func(ctx context.Context) ([]string, []types.RuleSyncInfo, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		ruleIDs, rulesSync, err := fetch(ctx)
+		if err != nil {
+			return err
+		}
+		synced, err := rulesSynced(ruleIDs, rulesSync)
+		if err != nil {
+			return err
+		}
+		if synced {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "rule %s not synced", ruleID)
+		case <-time.After(syncPollInterval):
+		}
+	}
+}
+
+// rulesSynced reports whether every rule in ruleIDs has a successful latest
+// sync in every engine, returning an error as soon as one of them failed.
+func rulesSynced(ruleIDs []string, rulesSync []types.RuleSyncInfo) (bool, error) {
+	syncsByRule := map[string][]types.RuleSyncInfo{}
+	for _, rs := range rulesSync {
+		syncsByRule[rs.RuleID] = append(syncsByRule[rs.RuleID], rs)
+	}
+	synced := true
+	for _, ruleID := range ruleIDs {
+		ruleSyncs := syncsByRule[ruleID]
+		if len(ruleSyncs) == 0 {
+			synced = false
+		}
+		for _, rs := range ruleSyncs {
+			latestSync := rs.LatestSync()
+			if latestSync == nil {
+				synced = false
+				continue
+			}
+			if !latestSync.Successful {
+				return false, errors.Errorf("rule %s failed to sync in engine %q: %s", ruleID, rs.Engine, latestSync.Error)
+			}
+		}
+	}
+	return synced, nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-plugin/acltest"
+	"github.com/tsuru/acl-plugin/client"
+)
+
+func newTestServer(t *testing.T) (*acltest.Server, *client.Client) {
+	t.Helper()
+	srv := acltest.NewServer()
+	t.Cleanup(srv.Close)
+	interval := syncPollInterval
+	syncPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { syncPollInterval = interval })
+	return srv, client.New(srv.URL, "token", "", srv.Client())
+}
+
+func TestReplaceRule(t *testing.T) {
+	ctx := context.Background()
+	srv, c := newTestServer(t)
+	srv.AddServiceInstance("inst", "app1")
+	oldRule, err := c.AddRule(ctx, "inst", types.Rule{
+		Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{
+			Name:  "example.org",
+			Ports: types.ProtoPorts{{Protocol: "tcp", Port: 443}},
+		}},
+		Metadata: map[string]string{"owner": "team-a"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := types.RuleType{ExternalDNS: &types.ExternalDNSRule{
+		Name:  "example.org",
+		Ports: types.ProtoPorts{{Protocol: "tcp", Port: 8443}},
+	}}
+	newRule, err := replaceRule(ctx, c, "inst", *oldRule, dst, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ruleData, err := c.ListRules(ctx, "inst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var active []string
+	for _, r := range ruleData.ServiceInstance.BaseRules {
+		if !r.Removed {
+			active = append(active, r.RuleID)
+		}
+	}
+	if len(active) != 1 || active[0] != newRule.RuleID {
+		t.Errorf("active rules = %v, want only %s", active, newRule.RuleID)
+	}
+	if newRule.Metadata["owner"] != "team-a" {
+		t.Errorf("new rule metadata = %v, want the old metadata", newRule.Metadata)
+	}
+}
+
+func TestReplaceRuleRefused(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    types.ServiceRule
+		wantErr string
+	}{
+		{
+			name: "named rule",
+			rule: types.ServiceRule{Rule: types.Rule{
+				RuleID:      "r1",
+				RuleName:    "partner",
+				Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "example.org", Ports: types.ProtoPorts{{Protocol: "tcp", Port: 443}}}},
+			}},
+			wantErr: `rule r1 is named "partner"`,
+		},
+		{
+			name: "dns rule without ports",
+			rule: types.ServiceRule{Rule: types.Rule{
+				RuleID:      "r2",
+				Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "example.org"}},
+			}},
+			wantErr: "rule r2 allows every port",
+		},
+		{
+			name: "ip rule without ports",
+			rule: types.ServiceRule{Rule: types.Rule{
+				RuleID:      "r3",
+				Destination: types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "10.0.0.0/8"}},
+			}},
+			wantErr: "rule r3 allows every port",
+		},
+		{
+			name: "rule with empty ports",
+			rule: types.ServiceRule{Rule: types.Rule{
+				RuleID:      "r4",
+				Destination: types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "10.0.0.1/32", Ports: types.ProtoPorts{}}},
+			}},
+			wantErr: "rule r4 allows every port",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, c := newTestServer(t)
+			srv.AddServiceInstance("inst", "app1")
+			dst := types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "example.org", Ports: types.ProtoPorts{{Protocol: "tcp", Port: 8443}}}}
+			_, err := replaceRule(context.Background(), c, "inst", tt.rule, dst, time.Second)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want it to contain %q", err, tt.wantErr)
+			}
+			if calls := srv.Calls(); len(calls) != 0 {
+				t.Errorf("got calls %v, want none", calls)
+			}
+		})
+	}
+}
+
+func TestEditRuleType(t *testing.T) {
+	dnsRule := types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "example.org", Ports: types.ProtoPorts{
+		{Protocol: "tcp", Port: 443},
+		{Protocol: "tcp", Port: 8443},
+	}}}
+	tests := []struct {
+		name    string
+		rt      types.RuleType
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{name: "replace ports", rt: dnsRule, args: []string{"--port", "tcp:80"}, want: "DNS: example.org, Ports: tcp:80"},
+		{name: "add port", rt: dnsRule, args: []string{"--add-port", "udp:53,tcp:443"}, want: "DNS: example.org, Ports: tcp:443, tcp:8443, udp:53"},
+		{name: "remove port", rt: dnsRule, args: []string{"--remove-port", "tcp:8443"}, want: "DNS: example.org, Ports: tcp:443"},
+		{name: "remove missing port", rt: dnsRule, args: []string{"--remove-port", "tcp:80"}, wantErr: "cannot remove port tcp:80"},
+		{name: "remove every port", rt: dnsRule, args: []string{"--remove-port", "tcp:443,tcp:8443"}, wantErr: "the rule must keep at least one port"},
+		{name: "empty ports", rt: dnsRule, args: []string{"--port", ""}, wantErr: "the rule must keep at least one port"},
+		{name: "app rule", rt: types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}}, args: []string{"--port", "tcp:80"}, wantErr: "only --ip and --dns rules have ports"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := pflag.NewFlagSet("", pflag.ContinueOnError)
+			flags.StringSlice("port", nil, "")
+			flags.StringSlice("add-port", nil, "")
+			flags.StringSlice("remove-port", nil, "")
+			if err := flags.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			rt, err := editRuleType(tt.rt, flags)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rt.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if got := dnsRule.String(); got != "DNS: example.org, Ports: tcp:443, tcp:8443" {
+				t.Errorf("original rule changed to %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-plugin/client"
+	"github.com/tsuru/tablecli"
+)
+
+type expiredRule struct {
+	Instance    string
+	RuleID      string
+	Destination string
+	ExpiresAt   time.Time
+}
+
+var ExpireRulesCmd = &cobra.Command{
+	Use:   "expire [service name] [instance name]",
+	Short: "Remove rules past their expiration time",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		serviceName, instanceName := serviceInstanceName(args, 1)
+		c := newClient(serviceName)
+		ruleData, err := c.ListRules(cmd.Context(), instanceName)
+		if err != nil {
+			return err
+		}
+		expired := expiredServiceRules([]types.ServiceInstance{ruleData.ServiceInstance}, time.Now())
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		return removeExpiredRules(cmd.Context(), c, expired, dryRun)
+	},
+}
+
+var AdminExpireRulesCmd = &cobra.Command{
+	Use:   "expire [service name]",
+	Short: "Remove rules past their expiration time from every instance, including custom rules",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		serviceName, _ := serviceInstanceName(args, 1)
+		c := newClient(serviceName)
+		serviceInstances, err := c.ListServiceInstances(cmd.Context())
+		if err != nil {
+			return err
+		}
+		rules, err := c.ListAllRules(cmd.Context())
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		expired := append(expiredServiceRules(serviceInstances, now), expiredCustomRules(rules, now)...)
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		return removeExpiredRules(cmd.Context(), c, expired, dryRun)
+	},
+}
+
+func expiredServiceRules(serviceInstances []types.ServiceInstance, now time.Time) []expiredRule {
+	var expired []expiredRule
+	for _, si := range serviceInstances {
+		for _, r := range si.BaseRules {
+			if r.Removed || !ruleExpired(r.Metadata, now) {
+				continue
+			}
+			expiresAt, _ := ruleExpiresAt(r.Metadata)
+			expired = append(expired, expiredRule{
+				Instance:    si.InstanceName,
+				RuleID:      r.RuleID,
+				Destination: r.Destination.String(),
+				ExpiresAt:   expiresAt,
+			})
+		}
+	}
+	return expired
+}
+
+// expiredCustomRules returns the expired rules not belonging to a service
+// instance, rules expanded from instance rules are left to
+// expiredServiceRules.
+func expiredCustomRules(rules []types.Rule, now time.Time) []expiredRule {
+	var expired []expiredRule
+	for _, r := range rules {
+		if r.Removed || r.Metadata["owner"] == ownerAclFromHell || !ruleExpired(r.Metadata, now) {
+			continue
+		}
+		expiresAt, _ := ruleExpiresAt(r.Metadata)
+		expired = append(expired, expiredRule{
+			RuleID:      r.RuleID,
+			Destination: r.Destination.String(),
+			ExpiresAt:   expiresAt,
+		})
+	}
+	return expired
+}
+
+// removeExpiredRules removes expired rules, using the service instance path
+// for rules belonging to an instance and the admin path for custom rules.
+func removeExpiredRules(ctx context.Context, c *client.Client, expired []expiredRule, dryRun bool) error {
+	if len(expired) == 0 {
+		fmt.Println("No expired rules found.")
+		return nil
+	}
+	table := tablecli.NewTable()
+	table.Headers = tablecli.Row{"Instance", "ID", "Destination", "Expired At"}
+	byID := map[string]expiredRule{}
+	ruleIDs := make([]string, len(expired))
+	for i, r := range expired {
+		byID[r.RuleID] = r
+		ruleIDs[i] = r.RuleID
+		table.AddRow(tablecli.Row{
+			r.Instance,
+			r.RuleID,
+			r.Destination,
+			r.ExpiresAt.Local().Format(time.RFC3339),
+		})
+	}
+	if dryRun {
+		fmt.Println("Expired rules that would be removed:")
+		fmt.Print(table.String())
+		return nil
+	}
+	fmt.Println("Expired rules:")
+	fmt.Print(table.String())
+	summary := runBulk(ctx, ruleIDs, 1, func(ctx context.Context, i int, ruleID string) error {
+		r := byID[ruleID]
+		fmt.Printf("%d/%d Removing rule %s\n", i+1, len(ruleIDs), ruleID)
+		if r.Instance == "" {
+			return c.RemoveCustomRule(ctx, ruleID)
+		}
+		return c.RemoveRule(ctx, r.Instance, ruleID)
+	})
+	summary.Print(os.Stdout)
+	if len(summary.Failed()) > 0 || summary.Interrupted {
+		return errors.New("some expired rules were not removed")
+	}
+	return nil
+}
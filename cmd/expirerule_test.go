@@ -0,0 +1,88 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tsuru/acl-api/api/types"
+)
+
+var expireTestDestination = types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "example.org"}}
+
+func TestExpiredServiceRules(t *testing.T) {
+	now := time.Date(2023, 10, 1, 15, 0, 0, 0, time.UTC)
+	rule := func(id string, removed bool, metadata map[string]string) types.ServiceRule {
+		return types.ServiceRule{Rule: types.Rule{RuleID: id, Removed: removed, Destination: expireTestDestination, Metadata: metadata}}
+	}
+	serviceInstances := []types.ServiceInstance{
+		{
+			InstanceName: "inst1",
+			BaseRules: []types.ServiceRule{
+				rule("expired", false, map[string]string{"expires-at": "2023-10-01T14:00:00Z"}),
+				rule("expiring-now", false, map[string]string{"expires-at": "2023-10-01T15:00:00Z"}),
+				rule("not-expired", false, map[string]string{"expires-at": "2023-10-01T16:00:00Z"}),
+				rule("removed", true, map[string]string{"expires-at": "2023-10-01T14:00:00Z"}),
+				rule("no-expiry", false, map[string]string{"owner": "team-a"}),
+				rule("no-metadata", false, nil),
+				rule("malformed", false, map[string]string{"expires-at": "2023-10-01"}),
+			},
+		},
+		{
+			InstanceName: "inst2",
+			BaseRules: []types.ServiceRule{
+				rule("other-instance", false, map[string]string{"expires-at": "2023-09-30T15:00:00-03:00"}),
+			},
+		},
+	}
+	want := []expiredRule{
+		{Instance: "inst1", RuleID: "expired", Destination: "DNS: example.org", ExpiresAt: time.Date(2023, 10, 1, 14, 0, 0, 0, time.UTC)},
+		{Instance: "inst1", RuleID: "expiring-now", Destination: "DNS: example.org", ExpiresAt: now},
+		{Instance: "inst2", RuleID: "other-instance", Destination: "DNS: example.org", ExpiresAt: time.Date(2023, 9, 30, 18, 0, 0, 0, time.UTC)},
+	}
+	got := expiredServiceRules(serviceInstances, now)
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i].Instance != want[i].Instance || got[i].RuleID != want[i].RuleID || got[i].Destination != want[i].Destination || !got[i].ExpiresAt.Equal(want[i].ExpiresAt) {
+			t.Errorf("got rule %d %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestExpiredCustomRules(t *testing.T) {
+	now := time.Date(2023, 10, 1, 15, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		rule types.Rule
+		want bool
+	}{
+		{name: "expired", rule: types.Rule{Metadata: map[string]string{"expires-at": "2023-10-01T14:00:00Z"}}, want: true},
+		{name: "expired custom owner", rule: types.Rule{Metadata: map[string]string{"owner": "team-a", "expires-at": "2023-10-01T14:00:00Z"}}, want: true},
+		{name: "not expired", rule: types.Rule{Metadata: map[string]string{"expires-at": "2023-10-01T16:00:00Z"}}},
+		{name: "removed", rule: types.Rule{Removed: true, Metadata: map[string]string{"expires-at": "2023-10-01T14:00:00Z"}}},
+		{name: "no expiry", rule: types.Rule{Metadata: map[string]string{"owner": "team-a"}}},
+		{name: "no metadata", rule: types.Rule{}},
+		{name: "malformed expires-at", rule: types.Rule{Metadata: map[string]string{"expires-at": "tomorrow"}}},
+		{name: "service instance rule", rule: types.Rule{Metadata: map[string]string{"owner": ownerAclFromHell, "expires-at": "2023-10-01T14:00:00Z"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.RuleID = "r1"
+			tt.rule.Destination = expireTestDestination
+			got := expiredCustomRules([]types.Rule{tt.rule}, now)
+			var want []expiredRule
+			if tt.want {
+				want = []expiredRule{{RuleID: "r1", Destination: "DNS: example.org", ExpiresAt: time.Date(2023, 10, 1, 14, 0, 0, 0, time.UTC)}}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
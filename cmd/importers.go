@@ -0,0 +1,411 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/acl-api/api/types"
+)
+
+// ipRuleImporter accumulates ExternalIP rules, merging the ports of rules to
+// the same network. A network allowed with no ports allows every port, so
+// it absorbs any port allowed by other entries.
+type ipRuleImporter struct {
+	rules    []importedRule
+	byIP     map[string]int
+	problems []importProblem
+}
+
+func newIPRuleImporter() *ipRuleImporter {
+	return &ipRuleImporter{byIP: map[string]int{}}
+}
+
+func (im *ipRuleImporter) Add(origin, cidr string, ports types.ProtoPorts) {
+	n, err := parseNetwork(cidr)
+	if err != nil {
+		im.Problem(origin, cidr, "invalid CIDR")
+		return
+	}
+	if isEveryAddress(n) {
+		im.Problem(origin, cidr, "egress to every destination")
+		return
+	}
+	ip := n.String()
+	i, ok := im.byIP[ip]
+	if !ok {
+		im.byIP[ip] = len(im.rules)
+		im.rules = append(im.rules, importedRule{
+			Origin:      origin,
+			Destination: types.RuleType{ExternalIP: &types.ExternalIPRule{IP: ip, Ports: ports}},
+		})
+		return
+	}
+	existing := &im.rules[i]
+	if !strings.Contains(existing.Origin, origin) {
+		existing.Origin += ", " + origin
+	}
+	ipRule := existing.Destination.ExternalIP
+	if len(ipRule.Ports) == 0 {
+		return
+	}
+	if len(ports) == 0 {
+		ipRule.Ports = nil
+		return
+	}
+	for _, p := range ports {
+		if !containsPort(ipRule.Ports, p) {
+			ipRule.Ports = append(ipRule.Ports, p)
+		}
+	}
+}
+
+// isEveryAddress tells whether n is 0.0.0.0/0 or ::/0, e.g. the default
+// egress permission of AWS security groups, which is not imported as a rule
+// allowing everything.
+func isEveryAddress(n *net.IPNet) bool {
+	ones, _ := n.Mask.Size()
+	return ones == 0
+}
+
+func (im *ipRuleImporter) Problem(origin, item, reason string) {
+	im.problems = append(im.problems, importProblem{Origin: origin, Item: item, Reason: reason})
+}
+
+func (im *ipRuleImporter) Result() ([]importedRule, []importProblem) {
+	for _, r := range im.rules {
+		ports := r.Destination.ExternalIP.Ports
+		sort.Slice(ports, func(i, j int) bool {
+			if ports[i].Protocol == ports[j].Protocol {
+				return ports[i].Port < ports[j].Port
+			}
+			return ports[i].Protocol < ports[j].Protocol
+		})
+	}
+	return im.rules, im.problems
+}
+
+type awsSecurityGroups struct {
+	SecurityGroups []awsSecurityGroup
+}
+
+type awsSecurityGroup struct {
+	GroupId             string
+	GroupName           string
+	IpPermissions       []awsIPPermission
+	IpPermissionsEgress []awsIPPermission
+}
+
+type awsIPPermission struct {
+	IpProtocol string
+	FromPort   *int
+	ToPort     *int
+	IpRanges   []struct {
+		CidrIp string
+	}
+	Ipv6Ranges []struct {
+		CidrIpv6 string
+	}
+	UserIdGroupPairs []struct {
+		GroupId string
+	}
+	PrefixListIds []struct {
+		PrefixListId string
+	}
+}
+
+var awsProtocolNumbers = map[string]string{"6": "tcp", "17": "udp"}
+
+// importAWSSecurityGroups translates the egress permissions of the output of
+// "aws ec2 describe-security-groups", or a list of security groups.
+func importAWSSecurityGroups(data []byte) ([]importedRule, []importProblem, error) {
+	var groups awsSecurityGroups
+	if err := json.Unmarshal(data, &groups); err != nil || groups.SecurityGroups == nil {
+		if err = json.Unmarshal(data, &groups.SecurityGroups); err != nil {
+			return nil, nil, errors.Wrap(err, "invalid AWS security groups JSON")
+		}
+	}
+	im := newIPRuleImporter()
+	for _, sg := range groups.SecurityGroups {
+		origin := "AWS SG " + sg.GroupId
+		if sg.GroupName != "" {
+			origin = fmt.Sprintf("AWS SG %s (%s)", sg.GroupId, sg.GroupName)
+		}
+		if len(sg.IpPermissions) > 0 {
+			im.Problem(origin, "IpPermissions", "only egress permissions are imported")
+		}
+		for i, perm := range sg.IpPermissionsEgress {
+			item := fmt.Sprintf("IpPermissionsEgress[%d]", i)
+			ports, err := awsPermissionPorts(perm)
+			if err != nil {
+				im.Problem(origin, item, err.Error())
+				continue
+			}
+			for _, r := range perm.IpRanges {
+				im.Add(origin, r.CidrIp, ports)
+			}
+			for _, r := range perm.Ipv6Ranges {
+				im.Add(origin, r.CidrIpv6, ports)
+			}
+			for _, pair := range perm.UserIdGroupPairs {
+				im.Problem(origin, item, fmt.Sprintf("security group destination %s is not supported", pair.GroupId))
+			}
+			for _, prefixList := range perm.PrefixListIds {
+				im.Problem(origin, item, fmt.Sprintf("prefix list destination %s is not supported", prefixList.PrefixListId))
+			}
+		}
+	}
+	rules, problems := im.Result()
+	return rules, problems, nil
+}
+
+func awsPermissionPorts(perm awsIPPermission) (types.ProtoPorts, error) {
+	protocol := strings.ToLower(perm.IpProtocol)
+	if p, ok := awsProtocolNumbers[protocol]; ok {
+		protocol = p
+	}
+	switch protocol {
+	case "-1", "all":
+		return nil, nil
+	case "tcp", "udp":
+	default:
+		return nil, errors.Errorf("protocol %q is not supported", perm.IpProtocol)
+	}
+	if perm.FromPort == nil || perm.ToPort == nil {
+		return nil, errors.Errorf("every %s port is not supported, only specific ports", protocol)
+	}
+	return expandPortRange(protocol, *perm.FromPort, *perm.ToPort)
+}
+
+type gcpFirewallRule struct {
+	Name              string
+	Direction         string
+	Disabled          bool
+	DestinationRanges []string
+	Allowed           []gcpFirewallPorts
+	Denied            []gcpFirewallPorts
+}
+
+type gcpFirewallPorts struct {
+	IPProtocol string
+	Ports      []string
+}
+
+// importGCPFirewallRules translates the egress rules of the output of
+// "gcloud compute firewall-rules list --format=json".
+func importGCPFirewallRules(data []byte) ([]importedRule, []importProblem, error) {
+	var firewallRules []gcpFirewallRule
+	if err := json.Unmarshal(data, &firewallRules); err != nil {
+		var single gcpFirewallRule
+		if singleErr := json.Unmarshal(data, &single); singleErr != nil {
+			return nil, nil, errors.Wrap(err, "invalid GCP firewall rules JSON")
+		}
+		firewallRules = []gcpFirewallRule{single}
+	}
+	im := newIPRuleImporter()
+	for _, fw := range firewallRules {
+		origin := "GCP firewall " + fw.Name
+		switch {
+		case fw.Disabled:
+			im.Problem(origin, "disabled", "disabled rules are not imported")
+			continue
+		case !strings.EqualFold(fw.Direction, "EGRESS"):
+			im.Problem(origin, "direction", "only EGRESS rules are imported")
+			continue
+		case len(fw.Denied) > 0:
+			im.Problem(origin, "denied", "deny rules are not supported")
+			continue
+		case len(fw.DestinationRanges) == 0:
+			im.Problem(origin, "destinationRanges", "egress to every destination")
+			continue
+		}
+		var ports types.ProtoPorts
+		allPorts := false
+		for i, allowed := range fw.Allowed {
+			item := fmt.Sprintf("allowed[%d]", i)
+			allowedPorts, err := gcpAllowedPorts(allowed)
+			if err != nil {
+				im.Problem(origin, item, err.Error())
+				continue
+			}
+			if allowedPorts == nil {
+				allPorts = true
+			}
+			ports = append(ports, allowedPorts...)
+		}
+		if !allPorts && len(ports) == 0 {
+			continue
+		}
+		if allPorts {
+			ports = nil
+		}
+		for _, cidr := range fw.DestinationRanges {
+			im.Add(origin, cidr, ports)
+		}
+	}
+	rules, problems := im.Result()
+	return rules, problems, nil
+}
+
+func gcpAllowedPorts(allowed gcpFirewallPorts) (types.ProtoPorts, error) {
+	protocol := strings.ToLower(allowed.IPProtocol)
+	switch protocol {
+	case "all":
+		return nil, nil
+	case "tcp", "udp":
+	default:
+		return nil, errors.Errorf("protocol %q is not supported", allowed.IPProtocol)
+	}
+	if len(allowed.Ports) == 0 {
+		return nil, errors.Errorf("every %s port is not supported, only specific ports", protocol)
+	}
+	var ports types.ProtoPorts
+	for _, raw := range allowed.Ports {
+		expanded, err := parseImportedPortRange(protocol, raw, "-")
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, expanded...)
+	}
+	return ports, nil
+}
+
+// parseImportedPortRange parses a single port or a range of ports separated
+// by sep, e.g. "8000-8080".
+func parseImportedPortRange(protocol, raw, sep string) (types.ProtoPorts, error) {
+	parts := strings.SplitN(raw, sep, 2)
+	first, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, errors.Errorf("invalid port %q", raw)
+	}
+	last := first
+	if len(parts) == 2 {
+		last, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, errors.Errorf("invalid port %q", raw)
+		}
+	}
+	return expandPortRange(protocol, first, last)
+}
+
+// importIPTables translates the ACCEPT rules of chain in the filter table of
+// an iptables-save or ip6tables-save dump.
+func importIPTables(data []byte, chain string) ([]importedRule, []importProblem, error) {
+	im := newIPRuleImporter()
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	table := "filter"
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "*") {
+			table = strings.TrimPrefix(line, "*")
+			continue
+		}
+		if table != "filter" || !strings.HasPrefix(line, "-A "+chain+" ") {
+			continue
+		}
+		origin := fmt.Sprintf("iptables line %d", lineNumber)
+		dst, ports, err := parseIPTablesRule(strings.Fields(line))
+		if err == errIPTablesNotAccept {
+			continue
+		}
+		if err != nil {
+			im.Problem(origin, line, err.Error())
+			continue
+		}
+		im.Add(origin, dst, ports)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	rules, problems := im.Result()
+	return rules, problems, nil
+}
+
+var errIPTablesNotAccept = errors.New("not an ACCEPT rule")
+
+func parseIPTablesRule(fields []string) (string, types.ProtoPorts, error) {
+	var dst, protocol, target string
+	var rawPorts []string
+	// unsupported is only reported for ACCEPT rules, others are skipped.
+	var unsupported error
+	for i := 2; i < len(fields); i++ {
+		arg := fields[i]
+		var value string
+		if i+1 < len(fields) {
+			value = fields[i+1]
+		}
+		switch arg {
+		case "!":
+			unsupported = errors.New("negated matches are not supported")
+		case "-d", "--destination":
+			dst = value
+			i++
+		case "-p", "--protocol":
+			protocol = strings.ToLower(value)
+			i++
+		case "--dport", "--destination-port":
+			rawPorts = []string{value}
+			i++
+		case "--dports", "--destination-ports":
+			rawPorts = strings.Split(value, ",")
+			i++
+		case "-j", "--jump":
+			target = value
+			i++
+		case "-s", "--source":
+			unsupported = errors.New("source matches are not supported, rules apply to every app of the instance")
+			i++
+		case "-o", "--out-interface":
+			unsupported = errors.New("output interface matches are not supported")
+			i++
+		case "--sport", "--source-port", "--sports", "--source-ports":
+			unsupported = errors.New("source port matches are not supported")
+			i++
+		case "-m", "--match", "--comment":
+			i++
+		}
+	}
+	if target != "ACCEPT" {
+		return "", nil, errIPTablesNotAccept
+	}
+	if unsupported != nil {
+		return "", nil, unsupported
+	}
+	if dst == "" {
+		return "", nil, errors.New("egress to every destination")
+	}
+	if protocol == "" || protocol == "all" {
+		if len(rawPorts) > 0 {
+			return "", nil, errors.New("ports without protocol")
+		}
+		return dst, nil, nil
+	}
+	switch protocol {
+	case "tcp", "udp":
+	default:
+		return "", nil, errors.Errorf("protocol %q is not supported", protocol)
+	}
+	if len(rawPorts) == 0 {
+		return "", nil, errors.Errorf("every %s port is not supported, only specific ports", protocol)
+	}
+	var ports types.ProtoPorts
+	for _, raw := range rawPorts {
+		expanded, err := parseImportedPortRange(protocol, raw, ":")
+		if err != nil {
+			return "", nil, err
+		}
+		ports = append(ports, expanded...)
+	}
+	return dst, ports, nil
+}
@@ -0,0 +1,226 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tsuru/acl-api/api/types"
+)
+
+func TestImportAWSSecurityGroups(t *testing.T) {
+	tests := []struct {
+		name         string
+		json         string
+		wantRules    []string
+		wantProblems []string
+		wantErr      string
+	}{
+		{
+			name: "describe-security-groups output",
+			json: `{"SecurityGroups": [{
+				"GroupId": "sg-1",
+				"GroupName": "payments",
+				"IpPermissions": [{"IpProtocol": "tcp", "FromPort": 22, "ToPort": 22, "IpRanges": [{"CidrIp": "10.0.0.0/8"}]}],
+				"IpPermissionsEgress": [
+					{"IpProtocol": "tcp", "FromPort": 443, "ToPort": 443, "IpRanges": [{"CidrIp": "10.0.0.0/24"}]},
+					{"IpProtocol": "6", "FromPort": 8000, "ToPort": 8001, "IpRanges": [{"CidrIp": "10.0.0.0/24"}, {"CidrIp": "10.1.2.3"}]},
+					{"IpProtocol": "udp", "FromPort": 53, "ToPort": 53, "Ipv6Ranges": [{"CidrIpv6": "2001:db8::/32"}]},
+					{"IpProtocol": "-1", "IpRanges": [{"CidrIp": "0.0.0.0/0"}], "Ipv6Ranges": [{"CidrIpv6": "::/0"}]},
+					{"IpProtocol": "-1", "IpRanges": [{"CidrIp": "10.9.0.0/16"}]},
+					{"IpProtocol": "icmp", "FromPort": -1, "ToPort": -1, "IpRanges": [{"CidrIp": "10.0.0.0/24"}]},
+					{"IpProtocol": "tcp", "IpRanges": [{"CidrIp": "10.0.0.0/24"}]},
+					{"IpProtocol": "tcp", "FromPort": 443, "ToPort": 443, "UserIdGroupPairs": [{"GroupId": "sg-2"}], "PrefixListIds": [{"PrefixListId": "pl-1"}]},
+					{"IpProtocol": "tcp", "FromPort": 443, "ToPort": 443, "IpRanges": [{"CidrIp": "invalid"}]}
+				]
+			}]}`,
+			wantRules: []string{
+				"AWS SG sg-1 (payments)||IP: 10.0.0.0/24, Ports: tcp:443, tcp:8000, tcp:8001",
+				"AWS SG sg-1 (payments)||IP: 10.1.2.3/32, Ports: tcp:8000, tcp:8001",
+				"AWS SG sg-1 (payments)||IP: 2001:db8::/32, Ports: udp:53",
+				"AWS SG sg-1 (payments)||IP: 10.9.0.0/16",
+			},
+			wantProblems: []string{
+				"AWS SG sg-1 (payments)|IpPermissions|only egress permissions are imported",
+				"AWS SG sg-1 (payments)|0.0.0.0/0|egress to every destination",
+				"AWS SG sg-1 (payments)|::/0|egress to every destination",
+				`AWS SG sg-1 (payments)|IpPermissionsEgress[5]|protocol "icmp" is not supported`,
+				"AWS SG sg-1 (payments)|IpPermissionsEgress[6]|every tcp port is not supported, only specific ports",
+				"AWS SG sg-1 (payments)|IpPermissionsEgress[7]|security group destination sg-2 is not supported",
+				"AWS SG sg-1 (payments)|IpPermissionsEgress[7]|prefix list destination pl-1 is not supported",
+				"AWS SG sg-1 (payments)|invalid|invalid CIDR",
+			},
+		},
+		{
+			name: "list of groups merging ports",
+			json: `[
+				{"GroupId": "sg-1", "IpPermissionsEgress": [{"IpProtocol": "tcp", "FromPort": 443, "ToPort": 443, "IpRanges": [{"CidrIp": "10.0.0.1/32"}]}]},
+				{"GroupId": "sg-2", "IpPermissionsEgress": [
+					{"IpProtocol": "tcp", "FromPort": 80, "ToPort": 80, "IpRanges": [{"CidrIp": "10.0.0.1/32"}]},
+					{"IpProtocol": "all", "IpRanges": [{"CidrIp": "10.0.0.2/32"}]},
+					{"IpProtocol": "tcp", "FromPort": 22, "ToPort": 22, "IpRanges": [{"CidrIp": "10.0.0.2/32"}]}
+				]}
+			]`,
+			wantRules: []string{
+				"AWS SG sg-1, AWS SG sg-2||IP: 10.0.0.1/32, Ports: tcp:443, tcp:80",
+				"AWS SG sg-2||IP: 10.0.0.2/32",
+			},
+		},
+		{
+			name:    "invalid json",
+			json:    `{"SecurityGroups": `,
+			wantErr: "invalid AWS security groups JSON",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, problems, err := importAWSSecurityGroups([]byte(tt.json))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkImported(t, rules, problems, tt.wantRules, tt.wantProblems)
+		})
+	}
+}
+
+func TestImportGCPFirewallRules(t *testing.T) {
+	tests := []struct {
+		name         string
+		json         string
+		wantRules    []string
+		wantProblems []string
+		wantErr      string
+	}{
+		{
+			name: "firewall-rules list output",
+			json: `[
+				{"name": "partner", "direction": "EGRESS", "destinationRanges": ["10.0.0.0/24", "10.1.0.1"], "allowed": [{"IPProtocol": "tcp", "ports": ["443", "8000-8001"]}, {"IPProtocol": "udp", "ports": ["53"]}]},
+				{"name": "everything", "direction": "EGRESS", "destinationRanges": ["10.2.0.0/16"], "allowed": [{"IPProtocol": "all"}]},
+				{"name": "internet", "direction": "EGRESS", "destinationRanges": ["0.0.0.0/0"], "allowed": [{"IPProtocol": "tcp", "ports": ["443"]}]},
+				{"name": "ssh", "direction": "INGRESS", "sourceRanges": ["10.0.0.0/8"], "allowed": [{"IPProtocol": "tcp", "ports": ["22"]}]},
+				{"name": "off", "direction": "EGRESS", "disabled": true, "destinationRanges": ["10.3.0.0/16"], "allowed": [{"IPProtocol": "all"}]},
+				{"name": "deny", "direction": "EGRESS", "destinationRanges": ["10.4.0.0/16"], "denied": [{"IPProtocol": "all"}]},
+				{"name": "anywhere", "direction": "EGRESS", "allowed": [{"IPProtocol": "all"}]},
+				{"name": "ping", "direction": "EGRESS", "destinationRanges": ["10.5.0.0/16"], "allowed": [{"IPProtocol": "icmp"}, {"IPProtocol": "tcp"}, {"IPProtocol": "tcp", "ports": ["x"]}]}
+			]`,
+			wantRules: []string{
+				"GCP firewall partner||IP: 10.0.0.0/24, Ports: tcp:443, tcp:8000, tcp:8001, udp:53",
+				"GCP firewall partner||IP: 10.1.0.1/32, Ports: tcp:443, tcp:8000, tcp:8001, udp:53",
+				"GCP firewall everything||IP: 10.2.0.0/16",
+			},
+			wantProblems: []string{
+				"GCP firewall internet|0.0.0.0/0|egress to every destination",
+				"GCP firewall ssh|direction|only EGRESS rules are imported",
+				"GCP firewall off|disabled|disabled rules are not imported",
+				"GCP firewall deny|denied|deny rules are not supported",
+				"GCP firewall anywhere|destinationRanges|egress to every destination",
+				`GCP firewall ping|allowed[0]|protocol "icmp" is not supported`,
+				"GCP firewall ping|allowed[1]|every tcp port is not supported, only specific ports",
+				`GCP firewall ping|allowed[2]|invalid port "x"`,
+			},
+		},
+		{
+			name:      "single rule",
+			json:      `{"name": "partner", "direction": "egress", "destinationRanges": ["10.0.0.1/32"], "allowed": [{"IPProtocol": "tcp", "ports": ["443"]}]}`,
+			wantRules: []string{"GCP firewall partner||IP: 10.0.0.1/32, Ports: tcp:443"},
+		},
+		{
+			name:    "invalid json",
+			json:    `[{"name": `,
+			wantErr: "invalid GCP firewall rules JSON",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, problems, err := importGCPFirewallRules([]byte(tt.json))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkImported(t, rules, problems, tt.wantRules, tt.wantProblems)
+		})
+	}
+}
+
+func TestParseIPTablesRule(t *testing.T) {
+	tests := []struct {
+		rule    string
+		want    string
+		wantErr string
+	}{
+		{rule: "-A EGRESS -d 10.0.0.0/24 -p tcp -m tcp --dport 443 -j ACCEPT", want: "IP: 10.0.0.0/24, Ports: tcp:443"},
+		{rule: "-A EGRESS -d 10.0.0.1/32 -p udp -m multiport --dports 53,8000:8001 -j ACCEPT", want: "IP: 10.0.0.1/32, Ports: udp:53, udp:8000, udp:8001"},
+		{rule: "-A EGRESS --destination 10.0.0.1 -m comment --comment partner --jump ACCEPT", want: "IP: 10.0.0.1"},
+		{rule: "-A EGRESS -d 10.0.0.1/32 -p tcp --dport 443 -j DROP", wantErr: errIPTablesNotAccept.Error()},
+		{rule: "-A EGRESS -d 10.0.0.1/32 -p tcp --dport 443 -j LOG", wantErr: errIPTablesNotAccept.Error()},
+		{rule: "-A EGRESS -p tcp --dport 443 -j ACCEPT", wantErr: "egress to every destination"},
+		{rule: "-A EGRESS ! -d 10.0.0.0/8 -j ACCEPT", wantErr: "negated matches are not supported"},
+		{rule: "-A EGRESS -s 10.2.0.0/16 -d 10.0.0.1/32 -j ACCEPT", wantErr: "source matches are not supported"},
+		{rule: "-A EGRESS -s 10.2.0.0/16 -j DROP", wantErr: errIPTablesNotAccept.Error()},
+		{rule: "-A EGRESS -o eth1 -d 10.0.0.1/32 -j ACCEPT", wantErr: "output interface matches are not supported"},
+		{rule: "-A EGRESS -d 10.0.0.1/32 -p tcp --sport 1024 --dport 443 -j ACCEPT", wantErr: "source port matches are not supported"},
+		{rule: "-A EGRESS -d 10.0.0.1/32 --dport 443 -j ACCEPT", wantErr: "ports without protocol"},
+		{rule: "-A EGRESS -d 10.0.0.1/32 -p icmp -j ACCEPT", wantErr: `protocol "icmp" is not supported`},
+		{rule: "-A EGRESS -d 10.0.0.1/32 -p tcp -j ACCEPT", wantErr: "every tcp port is not supported"},
+		{rule: "-A EGRESS -d 10.0.0.1/32 -p tcp --dport https -j ACCEPT", wantErr: `invalid port "https"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			dst, ports, err := parseIPTablesRule(strings.Fields(tt.rule))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := (&types.RuleType{ExternalIP: &types.ExternalIPRule{IP: dst, Ports: ports}}).String()
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportIPTables(t *testing.T) {
+	dump := `*nat
+-A EGRESS -d 10.9.0.0/16 -j ACCEPT
+COMMIT
+*filter
+:EGRESS - [0:0]
+-A EGRESS -d 10.0.0.1/32 -p tcp -m tcp --dport 443 -j ACCEPT
+-A OTHER -d 10.0.0.2/32 -j ACCEPT
+-A EGRESS -d 10.0.0.1/32 -p tcp -m tcp --dport 80 -j ACCEPT
+-A EGRESS -d 0.0.0.0/0 -p tcp -m tcp --dport 443 -j ACCEPT
+-A EGRESS -s 10.2.0.0/16 -d 10.0.0.3/32 -j ACCEPT
+-A EGRESS -j DROP
+COMMIT
+`
+	rules, problems, err := importIPTables([]byte(dump), "EGRESS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkImported(t, rules, problems,
+		[]string{"iptables line 6, iptables line 8||IP: 10.0.0.1/32, Ports: tcp:443, tcp:80"},
+		[]string{
+			"iptables line 9|0.0.0.0/0|egress to every destination",
+			"iptables line 10|-A EGRESS -s 10.2.0.0/16 -d 10.0.0.3/32 -j ACCEPT|source matches are not supported, rules apply to every app of the instance",
+		},
+	)
+}
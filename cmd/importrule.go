@@ -0,0 +1,469 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-plugin/client"
+	"github.com/tsuru/tablecli"
+	"gopkg.in/yaml.v3"
+)
+
+var ImportRulesCmd = &cobra.Command{
+	Use:   "import [service name] [instance name]",
+	Short: "Import rules from NetworkPolicies, cloud firewalls or iptables",
+	Long: `Import rules into an instance from one of these sources:
+
+--from-netpol imports the egress rules of Kubernetes NetworkPolicies. Every
+egress peer becomes a rule: ipBlocks become --ip rules, pods selected by the
+--app-label or --pool-label become --app or --app-pool rules, pods selected by
+the rpaas labels become --rpaas rules and pods of a namespace selected by the
+--service-label become --service rules. The pods selected by the policy itself
+are ignored, rules apply to the apps bound to the instance.
+
+--from-aws-sg imports the egress permissions of "aws ec2
+describe-security-groups", --from-gcp-firewall the EGRESS rules of "gcloud
+compute firewall-rules list --format=json" and --from-iptables the ACCEPT rules
+of the --iptables-chain in the filter table of iptables-save. Every CIDR
+becomes an --ip rule with the union of its ports, port ranges of up to
+1000 ports are expanded into single ports.
+
+--from-manifest imports a manifest written by --manifest, which saves the
+rules to a file instead of adding them, so they can be reviewed first.
+
+Anything that can't be translated is reported and not imported.`,
+	Example: `
+# Preview the rules imported from a manifest
+tsuru acl rules import <ACL SERVICE> --from-netpol policy.yaml --dry-run
+
+# Import the rules, labelled as migrated
+tsuru acl rules import <ACL SERVICE> --from-netpol policy.yaml --label migrated=true
+
+# Save the egress rules of AWS security groups to a manifest, then import it
+aws ec2 describe-security-groups --group-ids sg-0123 | tsuru acl rules import <ACL SERVICE> --from-aws-sg - --manifest rules.json
+tsuru acl rules import <ACL SERVICE> --from-manifest rules.json
+
+# Import the rules of a custom chain of iptables
+tsuru acl rules import <ACL SERVICE> --from-iptables rules.v4 --iptables-chain EGRESS-APP
+	`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		rules, problems, err := importRulesFromFlags(cmd.Flags())
+		if err != nil {
+			return err
+		}
+		rules, problems, warnings := validateImportedRules(rules, problems)
+		serviceName, instanceName := serviceInstanceName(args, 1)
+		c := newClient(serviceName)
+		if skip, _ := cmd.Flags().GetBool("skip-validation"); !skip {
+			rules, problems, err = validateImportedRulesInTsuru(cmd.Context(), newTsuruValidator(c), rules, problems)
+			if err != nil {
+				return err
+			}
+		}
+		if checkDNS, _ := cmd.Flags().GetBool("check-dns"); checkDNS {
+			rts := make([]types.RuleType, len(rules))
+			for i, r := range rules {
+				rts[i] = r.Destination
+			}
+			warnings = append(warnings, checkDNSResolution(cmd.Context(), newDNSResolver(""), rts)...)
+		}
+		printWarnings(warnings)
+		renderImportPreview(rules, problems)
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		if dryRun || len(rules) == 0 {
+			return nil
+		}
+		name, metadata, err := parseRuleMetadata(cmd.Flags())
+		if err != nil {
+			return err
+		}
+		manifestPath, _ := cmd.Flags().GetString("manifest")
+		if manifestPath != "" {
+			return writeImportManifest(manifestPath, rules, name, metadata)
+		}
+		return createImportedRules(cmd.Context(), c, instanceName, rules, name, metadata)
+	},
+}
+
+var importSourceFlags = []string{"from-netpol", "from-aws-sg", "from-gcp-firewall", "from-iptables", "from-manifest"}
+
+// importRulesFromFlags reads and translates the file set by the only
+// --from-* flag set.
+func importRulesFromFlags(flags *pflag.FlagSet) ([]importedRule, []importProblem, error) {
+	var source, path string
+	for _, f := range importSourceFlags {
+		value, _ := flags.GetString(f)
+		if value == "" {
+			continue
+		}
+		if source != "" {
+			source = ""
+			break
+		}
+		source, path = f, value
+	}
+	if source == "" {
+		return nil, nil, errors.Errorf("only one of --%s must be set", strings.Join(importSourceFlags, ", --"))
+	}
+	data, err := readImportFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch source {
+	case "from-netpol":
+		return importNetworkPolicies(data, parseLabelConventions(flags))
+	case "from-aws-sg":
+		return importAWSSecurityGroups(data)
+	case "from-gcp-firewall":
+		return importGCPFirewallRules(data)
+	case "from-iptables":
+		chain, _ := flags.GetString("iptables-chain")
+		return importIPTables(data, chain)
+	}
+	return importManifest(data)
+}
+
+// validateImportedRules validates and canonicalizes the destination of
+// every rule, reporting invalid ones as problems and removing duplicates.
+func validateImportedRules(rules []importedRule, problems []importProblem) ([]importedRule, []importProblem, []string) {
+	var valid []importedRule
+	var warnings []string
+	for _, r := range rules {
+		rtWarnings, rtProblems := validateRuleType(&r.Destination)
+		for _, w := range rtWarnings {
+			warnings = append(warnings, r.Origin+": "+w)
+		}
+		for _, p := range rtProblems {
+			problems = append(problems, importProblem{Origin: r.Origin, Item: "destination", Reason: p})
+		}
+		if len(rtProblems) == 0 {
+			valid = appendImportedRule(valid, r)
+		}
+	}
+	return valid, problems, warnings
+}
+
+// validateImportedRulesInTsuru reports rules to apps, pools or rpaas
+// instances missing in tsuru as problems.
+func validateImportedRulesInTsuru(ctx context.Context, v *tsuruValidator, rules []importedRule, problems []importProblem) ([]importedRule, []importProblem, error) {
+	var valid []importedRule
+	for _, r := range rules {
+		problem, err := v.Validate(ctx, r.Destination)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "unable to validate destinations, use --skip-validation to import rules without validation")
+		}
+		if problem != "" {
+			problems = append(problems, importProblem{Origin: r.Origin, Item: "destination", Reason: problem})
+			continue
+		}
+		valid = append(valid, r)
+	}
+	return valid, problems, nil
+}
+
+// importedRule is a destination translated from a foreign format, Origin
+// describes where it came from, e.g. "NetworkPolicy ns/name".
+type importedRule struct {
+	Origin      string
+	Name        string
+	Destination types.RuleType
+	Metadata    map[string]string
+}
+
+// importProblem is something that could not be translated into a rule.
+type importProblem struct {
+	Origin string
+	Item   string
+	Reason string
+}
+
+func readImportFile(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read import file")
+	}
+	return data, nil
+}
+
+// appendImportedRule appends rule to rules unless a rule with the same
+// destination is already there.
+func appendImportedRule(rules []importedRule, rule importedRule) []importedRule {
+	for _, r := range rules {
+		if r.Destination.String() == rule.Destination.String() {
+			return rules
+		}
+	}
+	return append(rules, rule)
+}
+
+func renderImportPreview(rules []importedRule, problems []importProblem) {
+	fmt.Printf("Rules to import (%d):\n", len(rules))
+	table := tablecli.NewTable()
+	table.Headers = tablecli.Row{"Origin", "Name", "Destination"}
+	for _, r := range rules {
+		table.AddRow(tablecli.Row{r.Origin, r.Name, r.Destination.String()})
+	}
+	fmt.Print(table.String())
+	if len(problems) == 0 {
+		return
+	}
+	fmt.Printf("\nNot imported (%d):\n", len(problems))
+	table = tablecli.NewTable()
+	table.Headers = tablecli.Row{"Origin", "Item", "Reason"}
+	for _, p := range problems {
+		table.AddRow(tablecli.Row{p.Origin, p.Item, p.Reason})
+	}
+	fmt.Print(table.String())
+}
+
+// importedRuleToRule returns the rule added for r. A non empty name replaces
+// the name of the rule, metadata is added to the rule. The name is kept in the
+// metadata as several rules are usually imported from the same policy and
+// rule names must be unique.
+func importedRuleToRule(r importedRule, name string, metadata map[string]string) types.Rule {
+	ruleMetadata := map[string]string{metadataDescription: "Imported from " + r.Origin}
+	if r.Name != "" {
+		ruleMetadata[metadataName] = r.Name
+	}
+	for k, v := range r.Metadata {
+		ruleMetadata[k] = v
+	}
+	for k, v := range metadata {
+		ruleMetadata[k] = v
+	}
+	if name != "" {
+		ruleMetadata[metadataName] = name
+	}
+	return types.Rule{
+		Destination: r.Destination,
+		Metadata:    ruleMetadata,
+	}
+}
+
+// createImportedRules adds rules to an instance.
+func createImportedRules(ctx context.Context, c *client.Client, instanceName string, rules []importedRule, name string, metadata map[string]string) error {
+	ids := make([]string, len(rules))
+	for i := range rules {
+		ids[i] = strconv.Itoa(i)
+	}
+	fmt.Println()
+	summary := runBulk(ctx, ids, 1, func(ctx context.Context, i int, _ string) error {
+		r := rules[i]
+		created, err := c.AddRule(ctx, instanceName, importedRuleToRule(r, name, metadata))
+		if err != nil {
+			fmt.Printf("%d/%d Failed to add rule to %s: %v\n", i+1, len(rules), r.Destination.String(), err)
+			return err
+		}
+		fmt.Printf("%d/%d Added rule %s to %s\n", i+1, len(rules), created.RuleID, r.Destination.String())
+		return nil
+	})
+	failed, skipped := len(summary.Failed()), len(summary.Skipped())
+	fmt.Printf("\n%d succeeded, %d failed, %d skipped.\n", len(rules)-failed-skipped, failed, skipped)
+	if summary.Interrupted {
+		return ctx.Err()
+	}
+	if failed > 0 {
+		return errors.Errorf("%d rules were not imported", failed)
+	}
+	return nil
+}
+
+// importManifestRule is a rule in a manifest written by --manifest. The name
+// is in the metadata, RuleName is only read from older manifests.
+type importManifestRule struct {
+	RuleName    string `json:",omitempty"`
+	Destination types.RuleType
+	Metadata    map[string]string `json:",omitempty"`
+}
+
+func writeImportManifest(path string, rules []importedRule, name string, metadata map[string]string) error {
+	manifest := make([]importManifestRule, len(rules))
+	for i, r := range rules {
+		rule := importedRuleToRule(r, name, metadata)
+		manifest[i] = importManifestRule{Destination: rule.Destination, Metadata: rule.Metadata}
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path, append(data, '\n'), 0644)
+	if err != nil {
+		return errors.Wrap(err, "unable to write manifest")
+	}
+	fmt.Printf("\n%d rules written to %s, import them with --from-manifest %s.\n", len(rules), path, path)
+	return nil
+}
+
+// importManifest reads the rules of a manifest written by --manifest.
+func importManifest(data []byte) ([]importedRule, []importProblem, error) {
+	var manifest []importManifestRule
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, nil, errors.Wrap(err, "invalid manifest")
+	}
+	var rules []importedRule
+	for i, m := range manifest {
+		origin := fmt.Sprintf("manifest rule %d", i+1)
+		name := m.RuleName
+		if name == "" {
+			name = m.Metadata[metadataName]
+		}
+		rules = append(rules, importedRule{Origin: origin, Name: name, Destination: m.Destination, Metadata: m.Metadata})
+	}
+	return rules, nil, nil
+}
+
+// importNetworkPolicies translates the egress peers of every NetworkPolicy in
+// the YAML documents in data into rules.
+func importNetworkPolicies(data []byte, conventions labelConventions) ([]importedRule, []importProblem, error) {
+	var rules []importedRule
+	var problems []importProblem
+	dec := yaml.NewDecoder(strings.NewReader(string(data)))
+	for doc := 1; ; doc++ {
+		var policy networkPolicy
+		err := dec.Decode(&policy)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid YAML document %d", doc)
+		}
+		if policy.Kind == "" {
+			continue
+		}
+		origin := fmt.Sprintf("%s %s", policy.Kind, policy.Metadata.Name)
+		if policy.Metadata.Namespace != "" {
+			origin = fmt.Sprintf("%s %s/%s", policy.Kind, policy.Metadata.Namespace, policy.Metadata.Name)
+		}
+		if policy.Kind != "NetworkPolicy" {
+			problems = append(problems, importProblem{Origin: origin, Item: "document " + strconv.Itoa(doc), Reason: "not a NetworkPolicy"})
+			continue
+		}
+		if len(policy.Spec.Ingress) > 0 {
+			problems = append(problems, importProblem{Origin: origin, Item: "ingress", Reason: "only egress rules are imported"})
+		}
+		for i, egress := range policy.Spec.Egress {
+			item := fmt.Sprintf("egress[%d]", i)
+			ports, err := importPolicyPorts(egress.Ports)
+			if err != nil {
+				problems = append(problems, importProblem{Origin: origin, Item: item + ".ports", Reason: err.Error()})
+				continue
+			}
+			if len(egress.To) == 0 {
+				problems = append(problems, importProblem{Origin: origin, Item: item, Reason: "egress to every destination"})
+				continue
+			}
+			for j, peer := range egress.To {
+				peerItem := fmt.Sprintf("%s.to[%d]", item, j)
+				dst, err := importPolicyPeer(peer, ports, conventions)
+				if err != nil {
+					problems = append(problems, importProblem{Origin: origin, Item: peerItem, Reason: err.Error()})
+					continue
+				}
+				rules = appendImportedRule(rules, importedRule{Origin: origin, Name: policy.Metadata.Name, Destination: *dst})
+			}
+		}
+	}
+	return rules, problems, nil
+}
+
+func importPolicyPorts(policyPorts []networkPolicyPort) (types.ProtoPorts, error) {
+	var ports types.ProtoPorts
+	for _, p := range policyPorts {
+		if p.Port == nil {
+			return nil, errors.New("ports without a port number are not supported")
+		}
+		if p.Port.StrVal != "" {
+			return nil, errors.Errorf("named port %q is not supported", p.Port.StrVal)
+		}
+		protocol := strings.ToLower(p.Protocol)
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		endPort := p.EndPort
+		if endPort == 0 {
+			endPort = p.Port.IntVal
+		}
+		expanded, err := expandPortRange(protocol, p.Port.IntVal, endPort)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, expanded...)
+	}
+	return ports, nil
+}
+
+func importPolicyPeer(peer networkPolicyPeer, ports types.ProtoPorts, conventions labelConventions) (*types.RuleType, error) {
+	if peer.IPBlock != nil {
+		if len(peer.IPBlock.Except) > 0 {
+			return nil, errors.New("ipBlock with except is not supported")
+		}
+		n, err := parseNetwork(peer.IPBlock.CIDR)
+		if err != nil {
+			return nil, errors.Errorf("invalid ipBlock cidr %q", peer.IPBlock.CIDR)
+		}
+		if isEveryAddress(n) {
+			return nil, errors.New("egress to every destination")
+		}
+		return &types.RuleType{ExternalIP: &types.ExternalIPRule{IP: n.String(), Ports: ports}}, nil
+	}
+	for _, sel := range []*labelSelectorSpec{peer.PodSelector, peer.NamespaceSelector} {
+		if sel != nil && len(sel.MatchExpressions) > 0 {
+			return nil, errors.New("matchExpressions are not supported")
+		}
+	}
+	if peer.PodSelector == nil || len(peer.PodSelector.MatchLabels) == 0 {
+		return nil, errors.New("peers selecting every pod are not supported")
+	}
+	labels := peer.PodSelector.MatchLabels
+	var namespace string
+	if peer.NamespaceSelector != nil {
+		namespace = peer.NamespaceSelector.MatchLabels[namespaceNameLabel]
+	}
+	var rt types.RuleType
+	switch {
+	case labels[conventions.AppLabel] != "":
+		rt.TsuruApp = &types.TsuruAppRule{AppName: labels[conventions.AppLabel]}
+	case labels[conventions.PoolLabel] != "":
+		rt.TsuruApp = &types.TsuruAppRule{PoolName: labels[conventions.PoolLabel]}
+	case labels[rpaasServiceLabel] != "" && labels[rpaasInstanceLabel] != "":
+		rt.RpaasInstance = &types.RpaasInstanceRule{ServiceName: labels[rpaasServiceLabel], Instance: labels[rpaasInstanceLabel]}
+	case labels[conventions.ServiceLabel] != "" && namespace != "":
+		rt.KubernetesService = &types.KubernetesServiceRule{Namespace: namespace, ServiceName: labels[conventions.ServiceLabel]}
+	case labels[conventions.ServiceLabel] != "":
+		return nil, errors.Errorf("pods selected by %s need a namespaceSelector with %s", conventions.ServiceLabel, namespaceNameLabel)
+	default:
+		return nil, errors.Errorf("pod labels %s don't match any app, pool, rpaas or service label", formatLabels(labels))
+	}
+	if len(ports) > 0 {
+		return nil, errors.Errorf("ports are only supported with ipBlock peers, %s allows every port", rt.String())
+	}
+	return &rt, nil
+}
+
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
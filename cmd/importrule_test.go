@@ -0,0 +1,252 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testLabelConventions = labelConventions{
+	AppLabel:     "tsuru.io/app-name",
+	PoolLabel:    "tsuru.io/app-pool",
+	JobLabel:     "tsuru.io/job-name",
+	ServiceLabel: "app.kubernetes.io/name",
+}
+
+func TestImportNetworkPolicies(t *testing.T) {
+	tests := []struct {
+		name         string
+		yaml         string
+		wantRules    []string
+		wantProblems []string
+		wantErr      string
+	}{
+		{
+			name: "ipBlocks with ports",
+			yaml: `
+apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: partner
+  namespace: apps
+spec:
+  egress:
+  - to:
+    - ipBlock:
+        cidr: 10.0.0.0/24
+    - ipBlock:
+        cidr: 10.1.2.3/32
+    ports:
+    - port: 443
+    - protocol: UDP
+      port: 53
+      endPort: 54
+`,
+			wantRules: []string{
+				"NetworkPolicy apps/partner|partner|IP: 10.0.0.0/24, Ports: tcp:443, udp:53, udp:54",
+				"NetworkPolicy apps/partner|partner|IP: 10.1.2.3/32, Ports: tcp:443, udp:53, udp:54",
+			},
+		},
+		{
+			name: "pod selectors",
+			yaml: `
+kind: NetworkPolicy
+metadata:
+  name: internal
+spec:
+  egress:
+  - to:
+    - podSelector:
+        matchLabels:
+          tsuru.io/app-name: app1
+    - podSelector:
+        matchLabels:
+          tsuru.io/app-pool: prod
+    - podSelector:
+        matchLabels:
+          rpaas.extensions.tsuru.io/service-name: rpaasv2
+          rpaas.extensions.tsuru.io/instance-name: front
+    - namespaceSelector:
+        matchLabels:
+          kubernetes.io/metadata.name: default
+      podSelector:
+        matchLabels:
+          app.kubernetes.io/name: redis
+`,
+			wantRules: []string{
+				"NetworkPolicy internal|internal|Tsuru APP: app1",
+				"NetworkPolicy internal|internal|Tsuru Pool: prod",
+				"NetworkPolicy internal|internal|Rpaas: rpaasv2/front",
+				"NetworkPolicy internal|internal|Kubernetes Service: default/redis",
+			},
+		},
+		{
+			name: "duplicated destinations",
+			yaml: `
+kind: NetworkPolicy
+metadata:
+  name: first
+spec:
+  egress:
+  - to:
+    - ipBlock:
+        cidr: 10.0.0.1
+---
+kind: NetworkPolicy
+metadata:
+  name: second
+spec:
+  egress:
+  - to:
+    - ipBlock:
+        cidr: 10.0.0.1/32
+`,
+			wantRules: []string{"NetworkPolicy first|first|IP: 10.0.0.1/32"},
+		},
+		{
+			name: "unsupported items",
+			yaml: `
+kind: ConfigMap
+metadata:
+  name: config
+---
+kind: NetworkPolicy
+metadata:
+  name: mixed
+spec:
+  ingress:
+  - {}
+  egress:
+  - ports:
+    - port: 443
+  - ports:
+    - port: https
+    to:
+    - ipBlock:
+        cidr: 10.0.0.0/8
+  - to:
+    - ipBlock:
+        cidr: 10.0.0.0/8
+        except:
+        - 10.1.0.0/16
+    - ipBlock:
+        cidr: invalid
+    - ipBlock:
+        cidr: 0.0.0.0/0
+    - podSelector: {}
+    - podSelector:
+        matchExpressions:
+        - key: tsuru.io/app-name
+          operator: Exists
+    - podSelector:
+        matchLabels:
+          other: label
+    - podSelector:
+        matchLabels:
+          app.kubernetes.io/name: redis
+  - to:
+    - podSelector:
+        matchLabels:
+          tsuru.io/app-name: app1
+    ports:
+    - port: 8080
+`,
+			wantProblems: []string{
+				"ConfigMap config|document 1|not a NetworkPolicy",
+				"NetworkPolicy mixed|ingress|only egress rules are imported",
+				"NetworkPolicy mixed|egress[0]|egress to every destination",
+				`NetworkPolicy mixed|egress[1].ports|named port "https" is not supported`,
+				"NetworkPolicy mixed|egress[2].to[0]|ipBlock with except is not supported",
+				`NetworkPolicy mixed|egress[2].to[1]|invalid ipBlock cidr "invalid"`,
+				"NetworkPolicy mixed|egress[2].to[2]|egress to every destination",
+				"NetworkPolicy mixed|egress[2].to[3]|peers selecting every pod are not supported",
+				"NetworkPolicy mixed|egress[2].to[4]|matchExpressions are not supported",
+				"NetworkPolicy mixed|egress[2].to[5]|pod labels other=label don't match any app, pool, rpaas or service label",
+				"NetworkPolicy mixed|egress[2].to[6]|pods selected by app.kubernetes.io/name need a namespaceSelector with kubernetes.io/metadata.name",
+				"NetworkPolicy mixed|egress[3].to[0]|ports are only supported with ipBlock peers, Tsuru APP: app1 allows every port",
+			},
+		},
+		{
+			name:    "invalid yaml",
+			yaml:    "kind: [",
+			wantErr: "invalid YAML document 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, problems, err := importNetworkPolicies([]byte(tt.yaml), testLabelConventions)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkImported(t, rules, problems, tt.wantRules, tt.wantProblems)
+		})
+	}
+}
+
+// checkImported compares rules, formatted as "origin|name|destination", and
+// problems, formatted as "origin|item|reason", with the expected ones.
+func checkImported(t *testing.T, rules []importedRule, problems []importProblem, wantRules, wantProblems []string) {
+	t.Helper()
+	var gotRules []string
+	for _, r := range rules {
+		gotRules = append(gotRules, r.Origin+"|"+r.Name+"|"+r.Destination.String())
+	}
+	if !reflect.DeepEqual(gotRules, wantRules) {
+		t.Errorf("got rules:\n%s\nwant:\n%s", strings.Join(gotRules, "\n"), strings.Join(wantRules, "\n"))
+	}
+	var gotProblems []string
+	for _, p := range problems {
+		gotProblems = append(gotProblems, p.Origin+"|"+p.Item+"|"+p.Reason)
+	}
+	if !reflect.DeepEqual(gotProblems, wantProblems) {
+		t.Errorf("got problems:\n%s\nwant:\n%s", strings.Join(gotProblems, "\n"), strings.Join(wantProblems, "\n"))
+	}
+}
+
+func TestImportedRuleToRuleKeepsNamesInMetadata(t *testing.T) {
+	rules, _, err := importNetworkPolicies([]byte(`
+kind: NetworkPolicy
+metadata:
+  name: partner
+spec:
+  egress:
+  - to:
+    - ipBlock:
+        cidr: 10.0.0.1
+    - ipBlock:
+        cidr: 10.0.0.2
+`), testLabelConventions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"", "override"} {
+		wantName := name
+		if wantName == "" {
+			wantName = "partner"
+		}
+		for _, r := range rules {
+			rule := importedRuleToRule(r, name, map[string]string{"ticket": "INC-1"})
+			if rule.RuleName != "" {
+				t.Errorf("rule to %s has RuleName %q, want it empty as rule names must be unique", rule.Destination.String(), rule.RuleName)
+			}
+			want := map[string]string{
+				metadataName:        wantName,
+				metadataDescription: "Imported from NetworkPolicy partner",
+				"ticket":            "INC-1",
+			}
+			if !reflect.DeepEqual(rule.Metadata, want) {
+				t.Errorf("rule to %s metadata = %v, want %v", rule.Destination.String(), rule.Metadata, want)
+			}
+		}
+	}
+}
@@ -8,145 +8,251 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-plugin/client"
 	"github.com/tsuru/tablecli"
 )
 
-type serviceRuleData struct {
-	ServiceInstance types.ServiceInstance
-	ExpandedRules   []types.Rule
-	RulesSync       []types.RuleSyncInfo
-}
-
 var ListAllRulesCmd = &cobra.Command{
 	Use:   "list [service name]",
 	Short: "List all rules",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		serviceName, _ := serviceInstanceName(args, 1)
-		rsp, err := doProxyAdminRequest(http.MethodGet, serviceName, "/rules", nil)
-		if err != nil {
-			return err
-		}
-		defer rsp.Body.Close()
-		data, err := ioutil.ReadAll(rsp.Body)
+		selector, err := parseLabelSelector(cmd.Flags())
 		if err != nil {
 			return err
 		}
-		jsonOutput, _ := cmd.Flags().GetBool("json")
-		if jsonOutput {
-			var prettyJSON bytes.Buffer
-			err := json.Indent(&prettyJSON, data, "", "  ")
-			if err != nil {
-				return errors.Wrap(err, "failed to prettify JSON")
-			}
-			fmt.Println(prettyJSON.String())
-			return nil
-		}
-		var rules []types.Rule
-		err = json.Unmarshal(data, &rules)
-		if err != nil {
-			return errors.Wrapf(err, "unable to unmarshal %q", string(data))
-		}
-		rsp, err = doProxyAdminRequest(http.MethodGet, serviceName, "/rules/sync", nil)
+		statusOpts, err := parseStatusOptions(cmd.Flags())
 		if err != nil {
 			return err
 		}
-		defer rsp.Body.Close()
-		data, err = ioutil.ReadAll(rsp.Body)
+		serviceName, _ := serviceInstanceName(args, 1)
+		c := newClient(serviceName)
+		rulesJSON, err := c.ListAllRulesJSON(cmd.Context())
 		if err != nil {
 			return err
 		}
-		var rulesSync []types.RuleSyncInfo
-		err = json.Unmarshal(data, &rulesSync)
-		if err != nil {
-			return errors.Wrapf(err, "unable to unmarshal %q", string(data))
+		var rules []types.Rule
+		if err = json.Unmarshal(rulesJSON, &rules); err != nil {
+			return errors.Wrapf(err, "unable to unmarshal %q", string(rulesJSON))
 		}
-		rsp, err = doProxyAdminRequest(http.MethodGet, serviceName, "/services", nil)
+		rulesSync, err := c.ListSync(cmd.Context())
 		if err != nil {
 			return err
 		}
-		defer rsp.Body.Close()
-		data, err = ioutil.ReadAll(rsp.Body)
+		serviceInstances, err := c.ListServiceInstances(cmd.Context())
 		if err != nil {
 			return err
 		}
-		var serviceInstances []types.ServiceInstance
-		err = json.Unmarshal(data, &serviceInstances)
-		if err != nil {
-			return errors.Wrapf(err, "unable to unmarshal %q", string(data))
+		serviceInstances, rules, rulesSync = filterServiceRules(serviceInstances, rules, rulesSync, selector)
+		serviceInstances, rules, rulesSync = filterRulesByStatus(serviceInstances, rules, rulesSync, statusOpts)
+		jsonOutput, _ := cmd.Flags().GetBool("json")
+		if jsonOutput {
+			if !selector.Empty() || len(statusOpts.Filter) > 0 {
+				if rulesJSON, err = filterJSONRules(rulesJSON, ruleIDs(rules)); err != nil {
+					return err
+				}
+			}
+			if err = printJSON(rulesJSON); err != nil {
+				return err
+			}
+			return checkExpectedStatus(rules, rulesSync, statusOpts)
+		}
+		for _, si := range serviceInstances {
+			inheritRuleNames(si.BaseRules, rules)
 		}
 		fmt.Println("Service Rules:")
 		renderServiceRules(serviceInstances, true)
+		matrix, _ := cmd.Flags().GetBool("matrix")
+		if matrix {
+			fmt.Println("Sync matrix:")
+			renderSyncMatrix(rules, rulesSync, statusOpts.StaleAfter)
+			return checkExpectedStatus(rules, rulesSync, statusOpts)
+		}
 		fmt.Println("Expanded Rules:")
-		renderExpandedRules(rules, rulesSync)
+		renderExpandedRules(rules, rulesSync, statusOpts.StaleAfter)
 		renderSyncInfo(rulesSync)
 		extraSync, _ := cmd.Flags().GetBool("show-extra-sync")
 		if extraSync {
-			renderExtraSyncInfo(rules, rulesSync)
+			renderExtraSyncInfo(rules, rulesSync, statusOpts.StaleAfter)
 		}
-		return nil
+		return checkExpectedStatus(rules, rulesSync, statusOpts)
 	},
 }
 
 var ListRuleCmd = &cobra.Command{
 	Use:   "list [service name] [instance name]",
 	Short: "List rules",
-	Args:  cobra.MinimumNArgs(1),
+	Example: `
+# List rules failing to sync in some engine
+tsuru acl rules list <ACL SERVICE> --status failing,partial
+
+# Exit with an error unless every rule is synced
+tsuru acl rules list <ACL SERVICE> --expect synced
+
+# Show the status of each rule in each engine
+tsuru acl rules list <ACL SERVICE> --matrix
+
+# Show the addresses DNS destinations resolve to and when they were last synced
+tsuru acl rules list <ACL SERVICE> --resolve --dns-server 8.8.8.8
+	`,
+	Args: cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		serviceName, instanceName := serviceInstanceName(args, 1)
-		rsp, err := doProxyRequest(http.MethodGet, serviceName, instanceName, "/rule", nil)
+		selector, err := parseLabelSelector(cmd.Flags())
+		if err != nil {
+			return err
+		}
+		statusOpts, err := parseStatusOptions(cmd.Flags())
 		if err != nil {
 			return err
 		}
-		defer rsp.Body.Close()
-		data, err := ioutil.ReadAll(rsp.Body)
+		serviceName, instanceName := serviceInstanceName(args, 1)
+		ruleDataJSON, err := newClient(serviceName).ListRulesJSON(cmd.Context(), instanceName)
 		if err != nil {
 			return err
 		}
+		var ruleData client.ServiceRules
+		if err = json.Unmarshal(ruleDataJSON, &ruleData); err != nil {
+			return errors.Wrapf(err, "unable to unmarshal %q", string(ruleDataJSON))
+		}
+		instances, expandedRules, rulesSync := filterServiceRules([]types.ServiceInstance{ruleData.ServiceInstance}, ruleData.ExpandedRules, ruleData.RulesSync, selector)
+		instances, expandedRules, rulesSync = filterRulesByStatus(instances, expandedRules, rulesSync, statusOpts)
+		ruleData.ServiceInstance, ruleData.ExpandedRules, ruleData.RulesSync = instances[0], expandedRules, rulesSync
 		jsonOutput, _ := cmd.Flags().GetBool("json")
 		if jsonOutput {
-			var prettyJSON bytes.Buffer
-			err := json.Indent(&prettyJSON, data, "", "  ")
-			if err != nil {
-				return errors.Wrap(err, "failed to prettify JSON")
+			if !selector.Empty() || len(statusOpts.Filter) > 0 {
+				if ruleDataJSON, err = filterJSONServiceRules(ruleDataJSON, &ruleData); err != nil {
+					return err
+				}
 			}
-			fmt.Println(prettyJSON.String())
-			return nil
-		}
-		var ruleData serviceRuleData
-		err = json.Unmarshal(data, &ruleData)
-		if err != nil {
-			return errors.Wrapf(err, "unable to unmarshal %q", string(data))
+			if err = printJSON(ruleDataJSON); err != nil {
+				return err
+			}
+			return checkExpectedStatus(ruleData.ExpandedRules, ruleData.RulesSync, statusOpts)
 		}
+		inheritRuleNames(ruleData.ServiceInstance.BaseRules, ruleData.ExpandedRules)
 		fmt.Println("Rules:")
 		renderServiceRules([]types.ServiceInstance{ruleData.ServiceInstance}, false)
+		matrix, _ := cmd.Flags().GetBool("matrix")
+		if matrix {
+			fmt.Println("Sync matrix (for each bound app):")
+			renderSyncMatrix(ruleData.ExpandedRules, ruleData.RulesSync, statusOpts.StaleAfter)
+			return checkExpectedStatus(ruleData.ExpandedRules, ruleData.RulesSync, statusOpts)
+		}
 		fmt.Println("Expanded Rules (for each bound app):")
-		renderExpandedRules(ruleData.ExpandedRules, ruleData.RulesSync)
+		renderExpandedRules(ruleData.ExpandedRules, ruleData.RulesSync, statusOpts.StaleAfter)
 		showSync, _ := cmd.Flags().GetBool("show-sync")
 		extraSync, _ := cmd.Flags().GetBool("show-extra-sync")
 		if showSync || extraSync {
 			renderSyncInfo(ruleData.RulesSync)
 		}
 		if extraSync {
-			renderExtraSyncInfo(ruleData.ExpandedRules, ruleData.RulesSync)
+			renderExtraSyncInfo(ruleData.ExpandedRules, ruleData.RulesSync, statusOpts.StaleAfter)
 		}
-		return nil
+		if resolver := parseResolver(cmd.Flags()); resolver != nil {
+			renderDNSResolutions(resolveDNSRules(cmd.Context(), resolver, ruleData.ExpandedRules, ruleData.RulesSync))
+		}
+		return checkExpectedStatus(ruleData.ExpandedRules, ruleData.RulesSync, statusOpts)
 	},
 }
 
-func renderExtraSyncInfo(rules []types.Rule, rulesSync []types.RuleSyncInfo) {
+// printJSON prints data as sent by the API, only indented, so --json keeps
+// fields unknown to the types package.
+func printJSON(data []byte) error {
+	var prettyJSON bytes.Buffer
+	err := json.Indent(&prettyJSON, data, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to prettify JSON")
+	}
+	fmt.Println(prettyJSON.String())
+	return nil
+}
+
+// filterJSONList keeps the items of the JSON list data for which keep
+// returns true, without decoding them into the types package.
+func filterJSONList(data json.RawMessage, keep func(item json.RawMessage) (bool, error)) (json.RawMessage, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, errors.Wrap(err, "unable to parse JSON list")
+	}
+	filtered := []json.RawMessage{}
+	for _, item := range items {
+		ok, err := keep(item)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to parse JSON list item")
+		}
+		if ok {
+			filtered = append(filtered, item)
+		}
+	}
+	return json.Marshal(filtered)
+}
+
+// filterJSONRules keeps the rules, or sync information, in the JSON list
+// data whose RuleID is in ids.
+func filterJSONRules(data json.RawMessage, ids map[string]bool) (json.RawMessage, error) {
+	return filterJSONList(data, func(item json.RawMessage) (bool, error) {
+		var r struct{ RuleID string }
+		err := json.Unmarshal(item, &r)
+		return ids[r.RuleID], err
+	})
+}
+
+// filterJSONServiceRules filters the JSON of a client.ServiceRules, keeping
+// only the rules still in ruleData.
+func filterJSONServiceRules(data json.RawMessage, ruleData *client.ServiceRules) (json.RawMessage, error) {
+	var fields, instance map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, errors.Wrap(err, "unable to parse rules JSON")
+	}
+	if err := json.Unmarshal(fields["ServiceInstance"], &instance); err != nil {
+		return nil, errors.Wrap(err, "unable to parse service instance JSON")
+	}
+	var err error
+	baseIDs := map[string]bool{}
+	for _, r := range ruleData.ServiceInstance.BaseRules {
+		baseIDs[r.RuleID] = true
+	}
+	if instance["BaseRules"], err = filterJSONRules(instance["BaseRules"], baseIDs); err != nil {
+		return nil, err
+	}
+	if fields["ServiceInstance"], err = json.Marshal(instance); err != nil {
+		return nil, err
+	}
+	ids := ruleIDs(ruleData.ExpandedRules)
+	if fields["ExpandedRules"], err = filterJSONRules(fields["ExpandedRules"], ids); err != nil {
+		return nil, err
+	}
+	if fields["RulesSync"], err = filterJSONRules(fields["RulesSync"], ids); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+func ruleIDs(rules []types.Rule) map[string]bool {
+	ids := map[string]bool{}
+	for _, r := range rules {
+		ids[r.RuleID] = true
+	}
+	return ids
+}
+
+func renderExtraSyncInfo(rules []types.Rule, rulesSync []types.RuleSyncInfo, staleAfter time.Duration) {
 	rulesSyncMap := map[string][]types.RuleSyncInfo{}
 	for _, rs := range rulesSync {
 		rulesSyncMap[rs.RuleID] = append(rulesSyncMap[rs.RuleID], rs)
 	}
+	statuses := ruleStatuses(rules, rulesSync, staleAfter)
 	fmt.Println("\nDetailed sync results:")
 	for _, r := range rules {
 		ruleSyncs, ok := rulesSyncMap[r.RuleID]
@@ -156,20 +262,13 @@ func renderExtraSyncInfo(rules []types.Rule, rulesSync []types.RuleSyncInfo) {
 		sort.Slice(ruleSyncs, func(i, j int) bool {
 			return ruleSyncs[i].Engine < ruleSyncs[j].Engine
 		})
-		synced := true
-		for _, rs := range ruleSyncs {
-			latestSync := rs.LatestSync()
-			if latestSync == nil || !latestSync.Successful {
-				synced = false
-				break
-			}
-		}
 		fields := []string{
 			"ID", r.RuleID,
+			"Name", ruleName(r),
 			"Source", r.Source.String(),
 			"Destination", r.Destination.String(),
 			"Deleted", strconv.FormatBool(r.Removed),
-			"Synced", strconv.FormatBool(synced),
+			"Status", statuses[r.RuleID].String(),
 		}
 		fmt.Print("----------------------\n")
 		for i := 0; i < len(fields); i += 2 {
@@ -183,16 +282,126 @@ func renderExtraSyncInfo(rules []types.Rule, rulesSync []types.RuleSyncInfo) {
 			if latestSync.Error != "" {
 				fmt.Printf("Sync error in engine %q: %v\n", rs.Engine, latestSync.Error)
 			}
-			var data interface{} = map[string]interface{}{}
-			err := json.Unmarshal([]byte(latestSync.SyncResult), &data)
-			if err != nil {
-				data = []map[string]interface{}{}
-				_ = json.Unmarshal([]byte(latestSync.SyncResult), &data)
+			fmt.Printf("Sync result in engine %q: %v\n", rs.Engine, renderSyncResult(rs.Engine, latestSync.SyncResult))
+		}
+	}
+}
+
+func renderRule(r types.Rule) {
+	fields := []string{
+		"ID", r.RuleID,
+		"Name", ruleName(r),
+		"Source", r.Source.String(),
+		"Destination", r.Destination.String(),
+		"Deleted", strconv.FormatBool(r.Removed),
+		"Created", r.Created.Local().Format(time.RFC3339),
+		"Creator", r.Creator,
+	}
+	for i := 0; i < len(fields); i += 2 {
+		fmt.Printf("%v: %v\n", fields[i], fields[i+1])
+	}
+	if len(r.Metadata) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(r.Metadata))
+	for k := range r.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fmt.Println("Metadata:")
+	for _, k := range keys {
+		fmt.Printf("  %v: %v\n", k, r.Metadata[k])
+	}
+}
+
+type syncFilter struct {
+	Since  time.Time
+	Engine string
+}
+
+func parseSyncFilter(flags *pflag.FlagSet) (syncFilter, error) {
+	var filter syncFilter
+	filter.Engine, _ = flags.GetString("engine")
+	rawSince, _ := flags.GetString("since")
+	if rawSince == "" {
+		return filter, nil
+	}
+	if d, err := time.ParseDuration(rawSince); err == nil {
+		filter.Since = time.Now().Add(-d)
+		return filter, nil
+	}
+	since, err := time.Parse(time.RFC3339, rawSince)
+	if err != nil {
+		return filter, errors.Errorf("invalid --since %q, must be a duration or a RFC3339 time, e.g. \"24h\" or \"2023-10-01T15:00:00Z\"", rawSince)
+	}
+	filter.Since = since
+	return filter, nil
+}
+
+// Apply returns a copy of rulesSync keeping only the engines and sync
+// attempts matching the filter.
+func (f syncFilter) Apply(rulesSync []types.RuleSyncInfo) []types.RuleSyncInfo {
+	filtered := []types.RuleSyncInfo{}
+	for _, rs := range rulesSync {
+		if f.Engine != "" && rs.Engine != f.Engine {
+			continue
+		}
+		var syncs []types.RuleSyncData
+		for _, s := range rs.Syncs {
+			if !s.StartTime.Before(f.Since) {
+				syncs = append(syncs, s)
 			}
-			indented, _ := json.MarshalIndent(data, "", "  ")
-			fmt.Printf("Sync result in engine %q: %v\n", rs.Engine, string(indented))
 		}
+		rs.Syncs = syncs
+		filtered = append(filtered, rs)
 	}
+	return filtered
+}
+
+// renderSyncHistory renders every recorded sync attempt, not only the
+// latest one, grouped by rule and engine.
+func renderSyncHistory(rulesSync []types.RuleSyncInfo) {
+	sort.Slice(rulesSync, func(i, j int) bool {
+		if rulesSync[i].RuleID == rulesSync[j].RuleID {
+			return rulesSync[i].Engine < rulesSync[j].Engine
+		}
+		return rulesSync[i].RuleID < rulesSync[j].RuleID
+	})
+	table := tablecli.NewTable()
+	table.Headers = tablecli.Row{"Rule ID", "Engine", "Start (duration)", "Success", "Error", "Result"}
+	for _, rs := range rulesSync {
+		for _, s := range rs.Syncs {
+			success := "✓"
+			if !s.Successful {
+				success = ""
+			}
+			table.AddRow(tablecli.Row{
+				rs.RuleID,
+				rs.Engine,
+				fmt.Sprintf("%s (%v)", s.StartTime.Local().Format(time.RFC3339), s.EndTime.Sub(s.StartTime)),
+				success,
+				s.Error,
+				renderSyncResult(rs.Engine, s.SyncResult),
+			})
+		}
+	}
+	fmt.Print(table.String())
+}
+
+func formatSyncResult(syncResult string) string {
+	var data interface{}
+	err := json.Unmarshal([]byte(syncResult), &data)
+	if err != nil {
+		return syncResult
+	}
+	if str, ok := data.(string); ok {
+		return str
+	}
+	if data == nil {
+		return ""
+	}
+	indented, _ := json.MarshalIndent(data, "", "  ")
+	return string(indented)
 }
 
 func renderSyncInfo(rulesSync []types.RuleSyncInfo) {
@@ -225,15 +434,19 @@ func renderSyncInfo(rulesSync []types.RuleSyncInfo) {
 
 func renderServiceRules(serviceInstances []types.ServiceInstance, renderName bool) {
 	table := tablecli.NewTable()
-	table.Headers = tablecli.Row{"ID", "Destination", "Creator"}
+	table.Headers = tablecli.Row{"ID", "Name", "Destination", "Details", "Expires", "Creator"}
 	if renderName {
 		table.Headers = append(tablecli.Row{"Instance"}, table.Headers...)
 	}
+	now := time.Now()
 	for _, si := range serviceInstances {
 		for _, r := range si.BaseRules {
 			row := tablecli.Row{
 				r.RuleID,
+				ruleName(r.Rule),
 				r.Destination.String(),
+				formatRuleMetadata(r.Metadata),
+				formatExpiry(r.Metadata, now),
 				r.Creator,
 			}
 			if renderName {
@@ -245,35 +458,82 @@ func renderServiceRules(serviceInstances []types.ServiceInstance, renderName boo
 	fmt.Println(table.String())
 }
 
-func renderExpandedRules(rules []types.Rule, rulesSync []types.RuleSyncInfo) {
-	ruleSyncByRule := map[string][]types.RuleSyncInfo{}
-	for _, rs := range rulesSync {
-		ruleSyncByRule[rs.RuleID] = append(ruleSyncByRule[rs.RuleID], rs)
-	}
+func renderExpandedRules(rules []types.Rule, rulesSync []types.RuleSyncInfo, staleAfter time.Duration) {
+	statuses := ruleStatuses(rules, rulesSync, staleAfter)
 	table := tablecli.NewTable()
-	table.Headers = tablecli.Row{"ID", "Source", "Destination", "Deleted", "Synced"}
+	table.Headers = tablecli.Row{"ID", "Name", "Source", "Destination", "Deleted", "Status"}
 	for _, r := range rules {
 		deleted := ""
 		if r.Removed {
 			deleted = "✓"
 		}
-		var synced string
-		for _, rs := range ruleSyncByRule[r.RuleID] {
-			latestSync := rs.LatestSync()
-			if latestSync != nil && latestSync.Successful {
-				synced = "✓"
-			} else {
-				synced = ""
-				break
-			}
-		}
 		table.AddRow(tablecli.Row{
 			r.RuleID,
+			ruleName(r),
 			r.Source.String(),
 			r.Destination.String(),
 			deleted,
-			synced,
+			statuses[r.RuleID].String(),
 		})
 	}
 	fmt.Print(table.String())
 }
+
+// renderSyncMatrix renders rules as rows and engines as columns, each cell
+// with the status of the rule in the engine and how long ago it was last
+// synced, followed by how many rules fail in each engine.
+func renderSyncMatrix(rules []types.Rule, rulesSync []types.RuleSyncInfo, staleAfter time.Duration) {
+	engineSet := map[string]bool{}
+	syncByRuleEngine := map[string]map[string]types.RuleSyncInfo{}
+	for _, rs := range rulesSync {
+		engineSet[rs.Engine] = true
+		if syncByRuleEngine[rs.RuleID] == nil {
+			syncByRuleEngine[rs.RuleID] = map[string]types.RuleSyncInfo{}
+		}
+		syncByRuleEngine[rs.RuleID][rs.Engine] = rs
+	}
+	engines := make([]string, 0, len(engineSet))
+	for engine := range engineSet {
+		engines = append(engines, engine)
+	}
+	sort.Strings(engines)
+
+	table := tablecli.NewTable()
+	table.Headers = append(tablecli.Row{"Rule ID"}, engines...)
+	failing := make([]int, len(engines))
+	total := make([]int, len(engines))
+	now := time.Now()
+	for _, r := range rules {
+		id := r.RuleID
+		if r.Removed {
+			id += " (deleted)"
+		}
+		row := tablecli.Row{id}
+		for i, engine := range engines {
+			rs, ok := syncByRuleEngine[r.RuleID][engine]
+			if !ok {
+				row = append(row, "")
+				continue
+			}
+			total[i]++
+			status := computeRuleStatus([]types.RuleSyncInfo{rs}, now, staleAfter).Status
+			if status == statusFailing {
+				failing[i]++
+			}
+			cell := string(status)
+			if latestSync := rs.LatestSync(); latestSync != nil {
+				cell = fmt.Sprintf("%s (%s ago)", status, humanDuration(now.Sub(latestSync.EndTime)))
+			}
+			row = append(row, cell)
+		}
+		table.AddRow(row)
+	}
+	fmt.Print(table.String())
+	if len(engines) > 0 {
+		counts := make([]string, len(engines))
+		for i, engine := range engines {
+			counts[i] = fmt.Sprintf("%s %d/%d", engine, failing[i], total[i])
+		}
+		fmt.Printf("Failing rules per engine: %s\n", strings.Join(counts, ", "))
+	}
+}
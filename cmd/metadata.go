@@ -0,0 +1,299 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"github.com/tsuru/acl-api/api/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	metadataName        = "name"
+	metadataDescription = "description"
+	metadataTicket      = "ticket"
+	metadataReason      = "reason"
+	metadataLabelPrefix = "label."
+	metadataExpiresAt   = "expires-at"
+
+	// ownerAclFromHell is the owner of rules expanded from service instance
+	// rules by the ACL API.
+	ownerAclFromHell = "aclfromhell"
+)
+
+// parseRuleMetadata returns the rule name and metadata set by the --name,
+// --description, --label, --ticket, --reason, --expires-in and --expires-at
+// flags.
+func parseRuleMetadata(flags *pflag.FlagSet) (string, map[string]string, error) {
+	name, _ := flags.GetString("name")
+	if name != "" {
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return "", nil, errors.Errorf("invalid --name %q: %s", name, strings.Join(errs, ", "))
+		}
+	}
+	metadata := map[string]string{}
+	for _, field := range []string{metadataDescription, metadataTicket, metadataReason} {
+		value, _ := flags.GetString(field)
+		if value != "" {
+			metadata[field] = value
+		}
+	}
+	rawLabels, _ := flags.GetStringArray("label")
+	for _, l := range rawLabels {
+		parts := strings.SplitN(l, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return "", nil, errors.Errorf("--label arguments must be in the format <key>=<value>, e.g. \"--label team=payments\", got %q", l)
+		}
+		metadata[metadataLabelPrefix+parts[0]] = parts[1]
+	}
+	expiresAt, err := parseExpiry(flags)
+	if err != nil {
+		return "", nil, err
+	}
+	if !expiresAt.IsZero() {
+		metadata[metadataExpiresAt] = expiresAt.UTC().Format(time.RFC3339)
+	}
+	return name, metadata, nil
+}
+
+func parseExpiry(flags *pflag.FlagSet) (time.Time, error) {
+	expiresIn, _ := flags.GetDuration("expires-in")
+	rawExpiresAt, _ := flags.GetString("expires-at")
+	if expiresIn != 0 && rawExpiresAt != "" {
+		return time.Time{}, errors.New("only one of --expires-in, --expires-at must be set")
+	}
+	if expiresIn < 0 {
+		return time.Time{}, errors.Errorf("invalid --expires-in %v, must be positive", expiresIn)
+	}
+	if expiresIn > 0 {
+		return time.Now().Add(expiresIn), nil
+	}
+	if rawExpiresAt == "" {
+		return time.Time{}, nil
+	}
+	expiresAt, err := time.Parse(time.RFC3339, rawExpiresAt)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid --expires-at %q, must be in RFC3339 format, e.g. \"2023-10-01T15:00:00Z\"", rawExpiresAt)
+	}
+	if !expiresAt.After(time.Now()) {
+		return time.Time{}, errors.Errorf("invalid --expires-at %q, must be in the future", rawExpiresAt)
+	}
+	return expiresAt, nil
+}
+
+// ruleExpiresAt returns when a rule expires, if it was created with an
+// expiration time.
+func ruleExpiresAt(metadata map[string]string) (time.Time, bool) {
+	raw := metadata[metadataExpiresAt]
+	if raw == "" {
+		return time.Time{}, false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return expiresAt, true
+}
+
+func ruleExpired(metadata map[string]string, now time.Time) bool {
+	expiresAt, ok := ruleExpiresAt(metadata)
+	return ok && !expiresAt.After(now)
+}
+
+// formatExpiry renders the remaining time until a rule expires, e.g.
+// "in 2d23h" or "expired 5m ago".
+func formatExpiry(metadata map[string]string, now time.Time) string {
+	expiresAt, ok := ruleExpiresAt(metadata)
+	if !ok {
+		return ""
+	}
+	if !expiresAt.After(now) {
+		return fmt.Sprintf("expired %s ago", humanDuration(now.Sub(expiresAt)))
+	}
+	return fmt.Sprintf("in %s", humanDuration(expiresAt.Sub(now)))
+}
+
+func humanDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	hours := d / time.Hour
+	d -= hours * time.Hour
+	minutes := d / time.Minute
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd%dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh%dm", hours, minutes)
+	case minutes == 0:
+		return "<1m"
+	default:
+		return fmt.Sprintf("%dm", minutes)
+	}
+}
+
+// ruleName returns the name of a rule. Service instance rules keep their name
+// in the metadata as the ACL API copies the rule name to every expanded rule,
+// which must have unique names.
+func ruleName(r types.Rule) string {
+	if r.RuleName != "" {
+		return r.RuleName
+	}
+	return r.Metadata[metadataName]
+}
+
+// inheritRuleNames names each expanded rule after its base rule, as the ACL
+// API doesn't copy the metadata of base rules to the expanded ones.
+func inheritRuleNames(baseRules []types.ServiceRule, expanded []types.Rule) {
+	names := map[string]string{}
+	for _, r := range baseRules {
+		if name := ruleName(r.Rule); name != "" {
+			names[r.RuleID] = name
+		}
+	}
+	for i, r := range expanded {
+		name, ok := names[r.Metadata["base-ruleid"]]
+		if !ok || ruleName(r) != "" {
+			continue
+		}
+		metadata := map[string]string{metadataName: name}
+		for k, v := range r.Metadata {
+			metadata[k] = v
+		}
+		expanded[i].Metadata = metadata
+	}
+}
+
+func ruleLabels(metadata map[string]string) map[string]string {
+	labels := map[string]string{}
+	for k, v := range metadata {
+		if strings.HasPrefix(k, metadataLabelPrefix) {
+			labels[strings.TrimPrefix(k, metadataLabelPrefix)] = v
+		}
+	}
+	return labels
+}
+
+// formatRuleMetadata renders the description, labels, ticket and reason of a
+// rule, one per line.
+func formatRuleMetadata(metadata map[string]string) string {
+	var lines []string
+	if v := metadata[metadataDescription]; v != "" {
+		lines = append(lines, v)
+	}
+	labels := ruleLabels(metadata)
+	if len(labels) > 0 {
+		var pairs []string
+		for k, v := range labels {
+			pairs = append(pairs, k+"="+v)
+		}
+		sort.Strings(pairs)
+		lines = append(lines, "Labels: "+strings.Join(pairs, ", "))
+	}
+	if v := metadata[metadataTicket]; v != "" {
+		lines = append(lines, "Ticket: "+v)
+	}
+	if v := metadata[metadataReason]; v != "" {
+		lines = append(lines, "Reason: "+v)
+	}
+	return strings.Join(lines, "\n")
+}
+
+type labelRequirement struct {
+	key      string
+	value    string
+	hasValue bool
+}
+
+// labelSelector selects rules by their labels. Each requirement is either
+// "key=value", matching rules with that label value, or "key", matching rules
+// with the label set to any value.
+type labelSelector []labelRequirement
+
+func parseLabelSelector(flags *pflag.FlagSet) (labelSelector, error) {
+	rawSelectors, _ := flags.GetStringSlice("selector")
+	var selector labelSelector
+	for _, s := range rawSelectors {
+		parts := strings.SplitN(s, "=", 2)
+		if parts[0] == "" {
+			return nil, errors.Errorf("invalid --selector %q, must be in the format <key>=<value> or <key>", s)
+		}
+		req := labelRequirement{key: parts[0]}
+		if len(parts) == 2 {
+			req.value = parts[1]
+			req.hasValue = true
+		}
+		selector = append(selector, req)
+	}
+	return selector, nil
+}
+
+func (s labelSelector) Empty() bool {
+	return len(s) == 0
+}
+
+func (s labelSelector) Matches(metadata map[string]string) bool {
+	labels := ruleLabels(metadata)
+	for _, req := range s {
+		value, ok := labels[req.key]
+		if !ok || (req.hasValue && value != req.value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s labelSelector) String() string {
+	parts := make([]string, len(s))
+	for i, req := range s {
+		parts[i] = req.key
+		if req.hasValue {
+			parts[i] = fmt.Sprintf("%s=%s", req.key, req.value)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// filterServiceRules keeps only base rules matching selector, along with the
+// rules expanded from them and their sync information.
+func filterServiceRules(serviceInstances []types.ServiceInstance, rules []types.Rule, rulesSync []types.RuleSyncInfo, selector labelSelector) ([]types.ServiceInstance, []types.Rule, []types.RuleSyncInfo) {
+	if selector.Empty() {
+		return serviceInstances, rules, rulesSync
+	}
+	selectedBase := map[string]bool{}
+	filteredInstances := make([]types.ServiceInstance, len(serviceInstances))
+	for i, si := range serviceInstances {
+		var baseRules []types.ServiceRule
+		for _, r := range si.BaseRules {
+			if selector.Matches(r.Metadata) {
+				selectedBase[r.RuleID] = true
+				baseRules = append(baseRules, r)
+			}
+		}
+		si.BaseRules = baseRules
+		filteredInstances[i] = si
+	}
+	var filteredRules []types.Rule
+	selected := map[string]bool{}
+	for _, r := range rules {
+		if selectedBase[r.Metadata["base-ruleid"]] || selector.Matches(r.Metadata) {
+			selected[r.RuleID] = true
+			filteredRules = append(filteredRules, r)
+		}
+	}
+	var filteredSync []types.RuleSyncInfo
+	for _, rs := range rulesSync {
+		if selected[rs.RuleID] {
+			filteredSync = append(filteredSync, rs)
+		}
+	}
+	return filteredInstances, filteredRules, filteredSync
+}
@@ -0,0 +1,180 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+func newMetadataFlags(t *testing.T, args ...string) *pflag.FlagSet {
+	t.Helper()
+	flags := pflag.NewFlagSet("", pflag.ContinueOnError)
+	flags.String("name", "", "")
+	flags.String("description", "", "")
+	flags.StringArray("label", nil, "")
+	flags.String("ticket", "", "")
+	flags.String("reason", "", "")
+	flags.Duration("expires-in", 0, "")
+	flags.String("expires-at", "", "")
+	flags.StringSliceP("selector", "l", nil, "")
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return flags
+}
+
+func TestParseRuleMetadata(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		wantName     string
+		wantMetadata map[string]string
+		wantErr      string
+	}{
+		{name: "no flags", wantMetadata: map[string]string{}},
+		{
+			name:     "every field",
+			args:     []string{"--name", "partner-api", "--description", "partner's API", "--ticket", "INC-1234", "--reason", "integration", "--label", "team=payments", "--label", "env=prod", "--expires-at", "2100-01-01T00:00:00-03:00"},
+			wantName: "partner-api",
+			wantMetadata: map[string]string{
+				"description": "partner's API",
+				"ticket":      "INC-1234",
+				"reason":      "integration",
+				"label.team":  "payments",
+				"label.env":   "prod",
+				"expires-at":  "2100-01-01T03:00:00Z",
+			},
+		},
+		{name: "label with empty value", args: []string{"--label", "team="}, wantMetadata: map[string]string{"label.team": ""}},
+		{name: "label with equal sign in the value", args: []string{"--label", "query=a=b"}, wantMetadata: map[string]string{"label.query": "a=b"}},
+		{name: "invalid name", args: []string{"--name", "Partner_API"}, wantErr: `invalid --name "Partner_API"`},
+		{name: "label without value", args: []string{"--label", "team"}, wantErr: `--label arguments must be in the format <key>=<value>, e.g. "--label team=payments", got "team"`},
+		{name: "label without key", args: []string{"--label", "=payments"}, wantErr: `got "=payments"`},
+		{name: "expires-in and expires-at", args: []string{"--expires-in", "1h", "--expires-at", "2100-01-01T00:00:00Z"}, wantErr: "only one of --expires-in, --expires-at must be set"},
+		{name: "negative expires-in", args: []string{"--expires-in", "-1h"}, wantErr: "invalid --expires-in -1h0m0s, must be positive"},
+		{name: "malformed expires-at", args: []string{"--expires-at", "2100-01-01"}, wantErr: `invalid --expires-at "2100-01-01", must be in RFC3339 format`},
+		{name: "past expires-at", args: []string{"--expires-at", "2000-01-01T00:00:00Z"}, wantErr: `invalid --expires-at "2000-01-01T00:00:00Z", must be in the future`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, metadata, err := parseRuleMetadata(newMetadataFlags(t, tt.args...))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != tt.wantName {
+				t.Errorf("got name %q, want %q", name, tt.wantName)
+			}
+			if !reflect.DeepEqual(metadata, tt.wantMetadata) {
+				t.Errorf("got metadata %v, want %v", metadata, tt.wantMetadata)
+			}
+		})
+	}
+}
+
+func TestParseRuleMetadataExpiresIn(t *testing.T) {
+	before := time.Now().Add(time.Hour).Truncate(time.Second)
+	_, metadata, err := parseRuleMetadata(newMetadataFlags(t, "--expires-in", "1h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiresAt, ok := ruleExpiresAt(metadata)
+	if !ok || expiresAt.Before(before) || expiresAt.After(time.Now().Add(time.Hour)) {
+		t.Errorf("got expires-at %q, want about an hour from now", metadata["expires-at"])
+	}
+}
+
+func TestParseLabelSelector(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    labelSelector
+		wantErr string
+	}{
+		{name: "no selector"},
+		{
+			name: "key and value",
+			args: []string{"-l", "team=payments"},
+			want: labelSelector{{key: "team", value: "payments", hasValue: true}},
+		},
+		{
+			name: "key only",
+			args: []string{"-l", "team"},
+			want: labelSelector{{key: "team"}},
+		},
+		{
+			name: "empty value",
+			args: []string{"-l", "team="},
+			want: labelSelector{{key: "team", hasValue: true}},
+		},
+		{
+			name: "several requirements",
+			args: []string{"-l", "team=payments,env", "--selector", "tier=db"},
+			want: labelSelector{
+				{key: "team", value: "payments", hasValue: true},
+				{key: "env"},
+				{key: "tier", value: "db", hasValue: true},
+			},
+		},
+		{name: "missing key", args: []string{"-l", "=payments"}, wantErr: `invalid --selector "=payments", must be in the format <key>=<value> or <key>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLabelSelector(newMetadataFlags(t, tt.args...))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLabelSelectorMatches(t *testing.T) {
+	metadata := map[string]string{
+		"label.team": "payments",
+		"label.env":  "",
+		"owner":      "team-a",
+	}
+	tests := []struct {
+		selector labelSelector
+		want     bool
+	}{
+		{selector: nil, want: true},
+		{selector: labelSelector{{key: "team", value: "payments", hasValue: true}}, want: true},
+		{selector: labelSelector{{key: "team", value: "billing", hasValue: true}}, want: false},
+		{selector: labelSelector{{key: "team"}}, want: true},
+		{selector: labelSelector{{key: "env"}}, want: true},
+		{selector: labelSelector{{key: "env", hasValue: true}}, want: true},
+		{selector: labelSelector{{key: "tier"}}, want: false},
+		{selector: labelSelector{{key: "owner"}}, want: false},
+		{selector: labelSelector{{key: "team"}, {key: "tier"}}, want: false},
+		{selector: labelSelector{{key: "team", value: "payments", hasValue: true}, {key: "env"}}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector.String(), func(t *testing.T) {
+			if got := tt.selector.Matches(metadata); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/acl-api/api/types"
+)
+
+// maxPortRange is the largest port range expanded into single ports, as
+// rules have no port ranges and every port is stored and synced on its own.
+const maxPortRange = 1000
+
+// serviceNamePorts are the well known service names accepted instead of a
+// port number, from the IANA service name registry.
+var serviceNamePorts = map[string]uint16{
+	"ftp":           21,
+	"ssh":           22,
+	"telnet":        23,
+	"smtp":          25,
+	"dns":           53,
+	"domain":        53,
+	"http":          80,
+	"kerberos":      88,
+	"pop3":          110,
+	"ntp":           123,
+	"imap":          143,
+	"snmp":          161,
+	"ldap":          389,
+	"https":         443,
+	"smtps":         465,
+	"syslog":        514,
+	"submission":    587,
+	"ldaps":         636,
+	"imaps":         993,
+	"pop3s":         995,
+	"mssql":         1433,
+	"oracle":        1521,
+	"mqtt":          1883,
+	"nfs":           2049,
+	"zookeeper":     2181,
+	"etcd":          2379,
+	"mysql":         3306,
+	"rdp":           3389,
+	"postgres":      5432,
+	"postgresql":    5432,
+	"amqp":          5672,
+	"rabbitmq":      5672,
+	"redis":         6379,
+	"kubernetes":    6443,
+	"http-alt":      8080,
+	"https-alt":     8443,
+	"kafka":         9092,
+	"elasticsearch": 9200,
+	"memcache":      11211,
+	"sentinel":      26379,
+	"mongodb":       27017,
+}
+
+// parsePorts parses --<flagName> arguments in the format <protocol>:<port>,
+// where port is a number, a range of numbers or a service name, e.g.
+// "tcp:443", "tcp:8000-8010" or "tcp:https". Repeated ports are removed.
+func parsePorts(rawPorts []string, flagName string) ([]types.ProtoPort, error) {
+	var ports []types.ProtoPort
+	for _, p := range rawPorts {
+		parsed, err := parsePort(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid --%s %q", flagName, p)
+		}
+		for _, port := range parsed {
+			if !containsPort(ports, port) {
+				ports = append(ports, port)
+			}
+		}
+	}
+	return ports, nil
+}
+
+func parsePort(raw string) ([]types.ProtoPort, error) {
+	parts := strings.Split(raw, ":")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, errors.New("must be in the format <protocol>:<port>, e.g. tcp:443, tcp:8000-8010 or tcp:https")
+	}
+	protocol := strings.ToLower(parts[0])
+	switch protocol {
+	case "tcp", "udp":
+	default:
+		return nil, errors.Errorf("protocol %q is not supported, the ACL API only allows tcp or udp", parts[0])
+	}
+	// Service names may have dashes, e.g. http-alt, so the whole value is
+	// tried before a range.
+	port, err := parsePortNumber(parts[1])
+	if err == nil {
+		return expandPortRange(protocol, port, port)
+	}
+	rangeParts := strings.SplitN(parts[1], "-", 2)
+	if len(rangeParts) != 2 {
+		return nil, err
+	}
+	first, err := parsePortNumber(rangeParts[0])
+	if err != nil {
+		return nil, err
+	}
+	last, err := parsePortNumber(rangeParts[1])
+	if err != nil {
+		return nil, err
+	}
+	return expandPortRange(protocol, first, last)
+}
+
+// parsePortNumber parses a port number or a service name.
+func parsePortNumber(raw string) (int, error) {
+	if port, ok := serviceNamePorts[strings.ToLower(raw)]; ok {
+		return int(port), nil
+	}
+	port, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, errors.Errorf("unknown port %q, use a number or a service name like https", raw)
+	}
+	if port < 1 || port > 65535 {
+		return 0, errors.Errorf("port %d out of range, must be between 1 and 65535", port)
+	}
+	return port, nil
+}
+
+// expandPortRange returns every port from first to last.
+func expandPortRange(protocol string, first, last int) (types.ProtoPorts, error) {
+	if first < 1 || last > 65535 || first > last {
+		return nil, errors.Errorf("invalid port range %d-%d", first, last)
+	}
+	if last-first+1 > maxPortRange {
+		return nil, errors.Errorf("port range %d-%d has %d ports, ranges are expanded into single ports and limited to %d ports", first, last, last-first+1, maxPortRange)
+	}
+	var ports types.ProtoPorts
+	for p := first; p <= last; p++ {
+		ports = append(ports, types.ProtoPort{Protocol: strings.ToLower(protocol), Port: uint16(p)})
+	}
+	return ports, nil
+}
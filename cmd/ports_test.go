@@ -0,0 +1,110 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/tsuru/acl-api/api/types"
+)
+
+func formatPorts(ports []types.ProtoPort) string {
+	var strs []string
+	for _, p := range ports {
+		strs = append(strs, fmt.Sprintf("%s:%d", p.Protocol, p.Port))
+	}
+	return strings.Join(strs, ",")
+}
+
+func TestParsePort(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr string
+	}{
+		{raw: "tcp:443", want: "tcp:443"},
+		{raw: "UDP:53", want: "udp:53"},
+		{raw: "tcp:https", want: "tcp:443"},
+		{raw: "tcp:HTTPS", want: "tcp:443"},
+		{raw: "tcp:8000-8002", want: "tcp:8000,tcp:8001,tcp:8002"},
+		{raw: "tcp:postgres-5433", want: "tcp:5432,tcp:5433"},
+		{raw: "tcp:http-alt", want: "tcp:8080"},
+		{raw: "tcp:HTTPS-ALT", want: "tcp:8443"},
+		{raw: "tcp:8079-http-alt", want: "tcp:8079,tcp:8080"},
+		{raw: "tcp:8000-8100", want: formatPorts(mustExpandPortRange(t, "tcp", 8000, 8100))},
+		{raw: "tcp:1-1001", wantErr: "port range 1-1001 has 1001 ports, ranges are expanded into single ports and limited to 1000 ports"},
+		{raw: "tcp:8100-8000", wantErr: "invalid port range 8100-8000"},
+		{raw: "tcp:65535", want: "tcp:65535"},
+		{raw: "tcp:0", wantErr: "port 0 out of range"},
+		{raw: "tcp:65536", wantErr: "port 65536 out of range"},
+		{raw: "tcp:0-10", wantErr: "port 0 out of range"},
+		{raw: "tcp:unknown", wantErr: `unknown port "unknown"`},
+		{raw: "sctp:443", wantErr: `protocol "sctp" is not supported, the ACL API only allows tcp or udp`},
+		{raw: "icmp:1", wantErr: `protocol "icmp" is not supported`},
+		{raw: "443", wantErr: "must be in the format <protocol>:<port>"},
+		{raw: "tcp:", wantErr: "must be in the format <protocol>:<port>"},
+		{raw: "tcp:443:1", wantErr: "must be in the format <protocol>:<port>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			ports, err := parsePort(tt.raw)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := formatPorts(ports); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func mustExpandPortRange(t *testing.T, protocol string, first, last int) types.ProtoPorts {
+	t.Helper()
+	ports, err := expandPortRange(protocol, first, last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ports
+}
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     []string
+		want    string
+		wantErr string
+	}{
+		{name: "none", raw: nil, want: ""},
+		{name: "duplicates", raw: []string{"tcp:443", "tcp:https", "TCP:443"}, want: "tcp:443"},
+		{name: "overlapping ranges", raw: []string{"tcp:8000-8002", "tcp:8001-8003"}, want: "tcp:8000,tcp:8001,tcp:8002,tcp:8003"},
+		{name: "same port in both protocols", raw: []string{"tcp:53", "udp:dns"}, want: "tcp:53,udp:53"},
+		{name: "invalid port", raw: []string{"tcp:443", "tcp:0"}, wantErr: `invalid --port "tcp:0"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ports, err := parsePorts(tt.raw, "port")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := formatPorts(ports); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
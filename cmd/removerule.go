@@ -5,25 +5,106 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
-	"net/http"
+	"os"
 
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-plugin/client"
 )
 
 var RemoveRuleCmd = &cobra.Command{
 	Use:   "remove [service name] [instance name] [id]",
 	Short: "Remove rule",
-	Args:  cobra.MinimumNArgs(2),
+	Long: `Remove a rule by its ID, or every rule matching --selector.
+
+With --selector no rule ID is taken, the arguments are only the optional
+service name and the instance name.`,
+	Example: `
+# Remove a single rule
+tsuru acl rules remove <ACL SERVICE> <RULE ID>
+
+# List the rules with the label team=payments that would be removed
+tsuru acl rules remove <ACL SERVICE> -l team=payments --dry-run
+
+# Remove every rule with the label team=payments without asking for confirmation
+tsuru acl rules remove <ACL SERVICE> -l team=payments --yes
+	`,
+	Args: cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		selector, err := parseLabelSelector(cmd.Flags())
+		if err != nil {
+			return err
+		}
+		if !selector.Empty() {
+			if len(args) > 2 {
+				return errors.New("a rule ID cannot be used together with --selector, use one or the other")
+			}
+			serviceName, instanceName := serviceInstanceName(args, 1)
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			yes, _ := cmd.Flags().GetBool("yes")
+			return removeRulesBySelector(cmd.Context(), newClient(serviceName), instanceName, selector, dryRun, yes)
+		}
+		if len(args) < 2 {
+			return errors.New("either a rule ID or --selector must be set")
+		}
 		serviceName, instanceName := serviceInstanceName(args, 2)
 		ruleID := args[len(args)-1]
-		rsp, err := doProxyRequest(http.MethodDelete, serviceName, instanceName, "/rule/"+ruleID, nil)
+		err = newClient(serviceName).RemoveRule(cmd.Context(), instanceName, ruleID)
 		if err != nil {
 			return err
 		}
-		defer rsp.Body.Close()
 		fmt.Println("Rule successfully removed.")
 		return nil
 	},
 }
+
+func removeRulesBySelector(ctx context.Context, c *client.Client, instanceName string, selector labelSelector, dryRun, yes bool) error {
+	ruleData, err := c.ListRules(ctx, instanceName)
+	if err != nil {
+		return err
+	}
+	var rules []types.ServiceRule
+	for _, r := range ruleData.ServiceInstance.BaseRules {
+		if selector.Matches(r.Metadata) {
+			rules = append(rules, r)
+		}
+	}
+	if len(rules) == 0 {
+		return errors.Errorf("no rules matching %q", selector.String())
+	}
+	if dryRun {
+		fmt.Printf("%d rules would be removed:\n", len(rules))
+		renderServiceRules([]types.ServiceInstance{{InstanceName: instanceName, BaseRules: rules}}, false)
+		return nil
+	}
+	if !yes {
+		if !isInteractive() {
+			return errors.Errorf("%d rules match %q, use --yes to remove them without confirmation", len(rules), selector.String())
+		}
+		fmt.Printf("%d rules match %q:\n", len(rules), selector.String())
+		renderServiceRules([]types.ServiceInstance{{InstanceName: instanceName, BaseRules: rules}}, false)
+		remove, confirmErr := newPrompter(ctx, os.Stdin, os.Stdout).Confirm("Remove the rules above?", false)
+		if confirmErr != nil {
+			return confirmErr
+		}
+		if !remove {
+			return errors.New("no rules removed")
+		}
+	}
+	ruleIDs := make([]string, len(rules))
+	for i, r := range rules {
+		ruleIDs[i] = r.RuleID
+	}
+	summary := runBulk(ctx, ruleIDs, 1, func(ctx context.Context, i int, ruleID string) error {
+		fmt.Printf("%d/%d Removing rule %s\n", i+1, len(ruleIDs), ruleID)
+		return c.RemoveRule(ctx, instanceName, ruleID)
+	})
+	summary.Print(os.Stdout)
+	if len(summary.Failed()) > 0 || summary.Interrupted {
+		return errors.New("some rules were not removed")
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/tsuru/acl-api/api/types"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	rpaasServiceLabel  = "rpaas.extensions.tsuru.io/service-name"
+	rpaasInstanceLabel = "rpaas.extensions.tsuru.io/instance-name"
+	namespaceNameLabel = "kubernetes.io/metadata.name"
+)
+
+var RenderRuleCmd = &cobra.Command{
+	Use:   "render [service name] [instance name]",
+	Short: "Render the Kubernetes NetworkPolicies a rule would produce",
+	Long: `Render the networking.k8s.io/v1 NetworkPolicies equivalent to the rules of
+an instance, without changing anything.
+
+Rules are selected by --id, or described with the same destination flags as
+"rules add" and expanded for every app and job bound to the instance. DNS
+destinations are resolved locally. The policies are an approximation of the
+ones created by the engines, which may use other names and labels.`,
+	Example: `
+# Render an existing rule
+tsuru acl rules render <ACL SERVICE> --id <RULE ID>
+
+# Render a rule before adding it
+tsuru acl rules render <ACL SERVICE> --dns example.org --port tcp:443
+	`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		serviceName, instanceName := serviceInstanceName(args, 1)
+		ruleData, err := newClient(serviceName).ListRules(cmd.Context(), instanceName)
+		if err != nil {
+			return err
+		}
+		ruleID, _ := cmd.Flags().GetString("id")
+		var rules []types.Rule
+		if ruleID != "" {
+			details, err := findServiceRuleDetails(ruleData.ServiceInstance, ruleData.ExpandedRules, nil, ruleID)
+			if err != nil {
+				return err
+			}
+			for _, r := range details.ExpandedRules {
+				if !r.Removed {
+					rules = append(rules, r)
+				}
+			}
+		} else {
+			dst, warnings, err := parseRuleType(cmd.Flags())
+			printWarnings(warnings)
+			if err != nil {
+				return err
+			}
+			rules = expandRule(ruleData.ServiceInstance, types.Rule{Destination: *dst})
+		}
+		if len(rules) == 0 {
+			return errors.Errorf("no rules to render, instance %q has no bound apps or jobs", instanceName)
+		}
+		conventions := parseLabelConventions(cmd.Flags())
+		server, _ := cmd.Flags().GetString("dns-server")
+		return renderNetworkPolicies(cmd.Context(), os.Stdout, rules, conventions, newDNSResolver(server))
+	},
+}
+
+// labelConventions are the labels and namespace identifying tsuru pods.
+type labelConventions struct {
+	Namespace    string
+	AppLabel     string
+	PoolLabel    string
+	JobLabel     string
+	ServiceLabel string
+}
+
+func parseLabelConventions(flags *pflag.FlagSet) labelConventions {
+	var c labelConventions
+	c.Namespace, _ = flags.GetString("namespace")
+	c.AppLabel, _ = flags.GetString("app-label")
+	c.PoolLabel, _ = flags.GetString("pool-label")
+	c.JobLabel, _ = flags.GetString("job-label")
+	c.ServiceLabel, _ = flags.GetString("service-label")
+	return c
+}
+
+// expandRule expands rule for every app and job bound to si, the same way
+// the ACL API does.
+func expandRule(si types.ServiceInstance, rule types.Rule) []types.Rule {
+	var rules []types.Rule
+	for _, app := range si.BindApps {
+		r := rule
+		r.RuleID = "new-" + app
+		r.Source = types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: app}}
+		rules = append(rules, r)
+	}
+	for _, job := range si.BindJobs {
+		r := rule
+		r.RuleID = "job-new-" + job
+		r.Source = types.RuleType{TsuruJob: &types.TsuruJobRule{JobName: job}}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+type networkPolicy struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   objectMeta        `yaml:"metadata"`
+	Spec       networkPolicySpec `yaml:"spec"`
+}
+
+type objectMeta struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+type networkPolicySpec struct {
+	PodSelector labelSelectorSpec         `yaml:"podSelector"`
+	PolicyTypes []string                  `yaml:"policyTypes"`
+	Egress      []networkPolicyEgressRule `yaml:"egress"`
+	Ingress     []interface{}             `yaml:"ingress,omitempty"`
+}
+
+type labelSelectorSpec struct {
+	MatchLabels      map[string]string          `yaml:"matchLabels,omitempty"`
+	MatchExpressions []labelSelectorRequirement `yaml:"matchExpressions,omitempty"`
+}
+
+type labelSelectorRequirement struct {
+	Key      string   `yaml:"key"`
+	Operator string   `yaml:"operator"`
+	Values   []string `yaml:"values,omitempty"`
+}
+
+type networkPolicyEgressRule struct {
+	To    []networkPolicyPeer `yaml:"to,omitempty"`
+	Ports []networkPolicyPort `yaml:"ports,omitempty"`
+}
+
+type networkPolicyPeer struct {
+	PodSelector       *labelSelectorSpec `yaml:"podSelector,omitempty"`
+	NamespaceSelector *labelSelectorSpec `yaml:"namespaceSelector,omitempty"`
+	IPBlock           *ipBlock           `yaml:"ipBlock,omitempty"`
+}
+
+type ipBlock struct {
+	CIDR   string   `yaml:"cidr"`
+	Except []string `yaml:"except,omitempty"`
+}
+
+type networkPolicyPort struct {
+	Protocol string       `yaml:"protocol,omitempty"`
+	Port     *intOrString `yaml:"port,omitempty"`
+	EndPort  int          `yaml:"endPort,omitempty"`
+}
+
+// intOrString is a port number or a named port.
+type intOrString struct {
+	IntVal int
+	StrVal string
+}
+
+func (v intOrString) MarshalYAML() (interface{}, error) {
+	if v.StrVal != "" {
+		return v.StrVal, nil
+	}
+	return v.IntVal, nil
+}
+
+func (v *intOrString) UnmarshalYAML(node *yaml.Node) error {
+	if node.Tag == "!!int" {
+		return node.Decode(&v.IntVal)
+	}
+	return node.Decode(&v.StrVal)
+}
+
+func renderNetworkPolicies(ctx context.Context, w io.Writer, rules []types.Rule, conventions labelConventions, resolver dnsResolver) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	for _, r := range rules {
+		policy, err := ruleNetworkPolicy(ctx, r, conventions, resolver)
+		if err != nil {
+			return errors.Wrapf(err, "unable to render rule %s", r.RuleID)
+		}
+		if err = enc.Encode(policy); err != nil {
+			return err
+		}
+	}
+	return enc.Close()
+}
+
+// ruleNetworkPolicy translates r into a NetworkPolicy allowing the egress
+// traffic from the source pods to the destination.
+func ruleNetworkPolicy(ctx context.Context, r types.Rule, conventions labelConventions, resolver dnsResolver) (*networkPolicy, error) {
+	podSelector, err := sourcePodSelector(r.Source, conventions)
+	if err != nil {
+		return nil, err
+	}
+	egress, err := destinationEgressRule(ctx, r.Destination, conventions, resolver)
+	if err != nil {
+		return nil, err
+	}
+	policy := &networkPolicy{
+		APIVersion: "networking.k8s.io/v1",
+		Kind:       "NetworkPolicy",
+		Metadata: objectMeta{
+			Name:      "acl-" + strings.ToLower(r.RuleID),
+			Namespace: conventions.Namespace,
+			Annotations: map[string]string{
+				"acl.tsuru.io/source":      r.Source.String(),
+				"acl.tsuru.io/destination": r.Destination.String(),
+			},
+		},
+		Spec: networkPolicySpec{
+			PodSelector: *podSelector,
+			PolicyTypes: []string{"Egress"},
+			Egress:      []networkPolicyEgressRule{*egress},
+		},
+	}
+	return policy, nil
+}
+
+func sourcePodSelector(src types.RuleType, conventions labelConventions) (*labelSelectorSpec, error) {
+	switch {
+	case src.TsuruApp != nil && src.TsuruApp.AppName != "":
+		return &labelSelectorSpec{MatchLabels: map[string]string{conventions.AppLabel: src.TsuruApp.AppName}}, nil
+	case src.TsuruApp != nil && src.TsuruApp.PoolName != "":
+		return &labelSelectorSpec{MatchLabels: map[string]string{conventions.PoolLabel: src.TsuruApp.PoolName}}, nil
+	case src.TsuruJob != nil:
+		return &labelSelectorSpec{MatchLabels: map[string]string{conventions.JobLabel: src.TsuruJob.JobName}}, nil
+	}
+	return nil, errors.Errorf("source %q cannot be rendered, only apps, pools and jobs are supported", src.String())
+}
+
+func destinationEgressRule(ctx context.Context, dst types.RuleType, conventions labelConventions, resolver dnsResolver) (*networkPolicyEgressRule, error) {
+	var egress networkPolicyEgressRule
+	anyNamespace := &labelSelectorSpec{}
+	switch {
+	case dst.ExternalIP != nil:
+		n, err := parseNetwork(dst.ExternalIP.IP)
+		if err != nil {
+			return nil, err
+		}
+		egress.To = []networkPolicyPeer{{IPBlock: &ipBlock{CIDR: n.String()}}}
+		egress.Ports = policyPorts(dst.ExternalIP.Ports)
+	case dst.ExternalDNS != nil:
+		addrs, err := resolver.LookupHost(ctx, dst.ExternalDNS.Name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to resolve %s", dst.ExternalDNS.Name)
+		}
+		sort.Strings(addrs)
+		for _, addr := range addrs {
+			n, err := parseNetwork(addr)
+			if err != nil {
+				continue
+			}
+			egress.To = append(egress.To, networkPolicyPeer{IPBlock: &ipBlock{CIDR: n.String()}})
+		}
+		egress.Ports = policyPorts(dst.ExternalDNS.Ports)
+	case dst.TsuruApp != nil && dst.TsuruApp.AppName != "":
+		egress.To = []networkPolicyPeer{{
+			NamespaceSelector: anyNamespace,
+			PodSelector:       &labelSelectorSpec{MatchLabels: map[string]string{conventions.AppLabel: dst.TsuruApp.AppName}},
+		}}
+	case dst.TsuruApp != nil && dst.TsuruApp.PoolName != "":
+		egress.To = []networkPolicyPeer{{
+			NamespaceSelector: anyNamespace,
+			PodSelector:       &labelSelectorSpec{MatchLabels: map[string]string{conventions.PoolLabel: dst.TsuruApp.PoolName}},
+		}}
+	case dst.TsuruJob != nil:
+		egress.To = []networkPolicyPeer{{
+			NamespaceSelector: anyNamespace,
+			PodSelector:       &labelSelectorSpec{MatchLabels: map[string]string{conventions.JobLabel: dst.TsuruJob.JobName}},
+		}}
+	case dst.KubernetesService != nil:
+		egress.To = []networkPolicyPeer{{
+			NamespaceSelector: &labelSelectorSpec{MatchLabels: map[string]string{namespaceNameLabel: dst.KubernetesService.Namespace}},
+			PodSelector:       &labelSelectorSpec{MatchLabels: map[string]string{conventions.ServiceLabel: dst.KubernetesService.ServiceName}},
+		}}
+	case dst.RpaasInstance != nil:
+		egress.To = []networkPolicyPeer{{
+			NamespaceSelector: anyNamespace,
+			PodSelector: &labelSelectorSpec{MatchLabels: map[string]string{
+				rpaasServiceLabel:  dst.RpaasInstance.ServiceName,
+				rpaasInstanceLabel: dst.RpaasInstance.Instance,
+			}},
+		}}
+	default:
+		return nil, errors.Errorf("destination %q cannot be rendered", dst.String())
+	}
+	// An egress rule without peers allows traffic to every destination.
+	if len(egress.To) == 0 {
+		return nil, errors.Errorf("destination %q has no addresses, the policy would allow traffic to everywhere", dst.String())
+	}
+	return &egress, nil
+}
+
+func policyPorts(ports types.ProtoPorts) []networkPolicyPort {
+	var result []networkPolicyPort
+	for _, p := range ports {
+		result = append(result, networkPolicyPort{
+			Protocol: strings.ToUpper(p.Protocol),
+			Port:     &intOrString{IntVal: int(p.Port)},
+		})
+	}
+	return result
+}
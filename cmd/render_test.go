@@ -0,0 +1,77 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/tsuru/acl-api/api/types"
+)
+
+func TestDestinationEgressRule(t *testing.T) {
+	resolver := &fakeResolver{
+		hosts: map[string][]string{
+			"a.example.org":     {"10.0.0.2", "10.0.0.1"},
+			"empty.example.org": {},
+			"bad.example.org":   {"not-an-ip"},
+		},
+		lookups: map[string]int{},
+	}
+	tests := []struct {
+		name      string
+		dst       types.RuleType
+		wantPeers []string
+		wantErr   string
+	}{
+		{
+			name:      "dns",
+			dst:       types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "a.example.org"}},
+			wantPeers: []string{"10.0.0.1/32", "10.0.0.2/32"},
+		},
+		{
+			name:      "ip",
+			dst:       types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "10.1.0.0/16"}},
+			wantPeers: []string{"10.1.0.0/16"},
+		},
+		{
+			name:    "dns without addresses",
+			dst:     types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "empty.example.org"}},
+			wantErr: "has no addresses",
+		},
+		{
+			name:    "dns without valid addresses",
+			dst:     types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "bad.example.org"}},
+			wantErr: "has no addresses",
+		},
+		{
+			name:    "dns not resolving",
+			dst:     types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "missing.example.org"}},
+			wantErr: "unable to resolve missing.example.org",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			egress, err := destinationEgressRule(context.Background(), tt.dst, labelConventions{}, resolver)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var peers []string
+			for _, p := range egress.To {
+				peers = append(peers, p.IPBlock.CIDR)
+			}
+			if strings.Join(peers, ",") != strings.Join(tt.wantPeers, ",") {
+				t.Errorf("got peers %v, want %v", peers, tt.wantPeers)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/tablecli"
+)
+
+// dnsResolver resolves DNS destinations, *net.Resolver implements it.
+type dnsResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// newDNSResolver returns the system resolver, or a resolver querying only
+// server when it's set. A server without port uses port 53.
+func newDNSResolver(server string) dnsResolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// parseResolver returns the resolver set by the --resolve and --dns-server
+// flags, nil means DNS destinations must not be resolved.
+func parseResolver(flags *pflag.FlagSet) dnsResolver {
+	resolve, _ := flags.GetBool("resolve")
+	if !resolve {
+		return nil
+	}
+	server, _ := flags.GetString("dns-server")
+	return newDNSResolver(server)
+}
+
+// dnsLookupTimeout is how long a single DNS lookup of --resolve may take.
+var dnsLookupTimeout = 5 * time.Second
+
+// dnsLookupConcurrency is how many lookups of --resolve run at the same time.
+const dnsLookupConcurrency = 8
+
+// dnsResolution is what a DNS destination resolves to now, along with the
+// latest successful sync of the rule in an engine. Engines don't report the
+// addresses they allowed, so comparing both is left to the user.
+type dnsResolution struct {
+	RuleID   string
+	Name     string
+	Resolved []string
+	Err      error
+	Engine   string
+	LastSync time.Time
+}
+
+type dnsLookup struct {
+	addrs []string
+	err   error
+}
+
+// resolveDNSRules resolves the DNS destination of every rule not removed,
+// returning a resolution for each engine syncing the rule.
+func resolveDNSRules(ctx context.Context, resolver dnsResolver, rules []types.Rule, rulesSync []types.RuleSyncInfo) []dnsResolution {
+	syncsByRule := map[string][]types.RuleSyncInfo{}
+	for _, rs := range rulesSync {
+		syncsByRule[rs.RuleID] = append(syncsByRule[rs.RuleID], rs)
+	}
+	var names []string
+	for _, r := range rules {
+		if !r.Removed && r.Destination.ExternalDNS != nil && !containsString(names, r.Destination.ExternalDNS.Name) {
+			names = append(names, r.Destination.ExternalDNS.Name)
+		}
+	}
+	lookups := lookupHosts(ctx, resolver, names)
+	var resolutions []dnsResolution
+	for _, r := range rules {
+		if r.Removed || r.Destination.ExternalDNS == nil {
+			continue
+		}
+		name := r.Destination.ExternalDNS.Name
+		lookup := lookups[name]
+		ruleSyncs := syncsByRule[r.RuleID]
+		sort.Slice(ruleSyncs, func(i, j int) bool {
+			return ruleSyncs[i].Engine < ruleSyncs[j].Engine
+		})
+		base := dnsResolution{RuleID: r.RuleID, Name: name, Resolved: lookup.addrs, Err: lookup.err}
+		if len(ruleSyncs) == 0 {
+			resolutions = append(resolutions, base)
+			continue
+		}
+		for _, rs := range ruleSyncs {
+			res := base
+			res.Engine = rs.Engine
+			res.LastSync = lastSuccessfulSync(rs)
+			resolutions = append(resolutions, res)
+		}
+	}
+	return resolutions
+}
+
+// lookupHosts resolves names concurrently, each lookup limited to
+// dnsLookupTimeout.
+func lookupHosts(ctx context.Context, resolver dnsResolver, names []string) map[string]dnsLookup {
+	results := make([]dnsLookup, len(names))
+	sem := make(chan struct{}, dnsLookupConcurrency)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			lookupCtx, cancel := context.WithTimeout(ctx, dnsLookupTimeout)
+			defer cancel()
+			addrs, err := resolver.LookupHost(lookupCtx, name)
+			sort.Strings(addrs)
+			results[i] = dnsLookup{addrs: addrs, err: err}
+		}(i, name)
+	}
+	wg.Wait()
+	lookups := make(map[string]dnsLookup, len(names))
+	for i, name := range names {
+		lookups[name] = results[i]
+	}
+	return lookups
+}
+
+func lastSuccessfulSync(rs types.RuleSyncInfo) time.Time {
+	for i := len(rs.Syncs) - 1; i >= 0; i-- {
+		if rs.Syncs[i].Successful {
+			return rs.Syncs[i].EndTime
+		}
+	}
+	return time.Time{}
+}
+
+func renderDNSResolutions(resolutions []dnsResolution) {
+	fmt.Println("\nDNS resolution:")
+	table := tablecli.NewTable()
+	table.Headers = tablecli.Row{"Rule ID", "DNS", "Resolved now", "Engine", "Last successful sync"}
+	var names, unresolved []string
+	for _, res := range resolutions {
+		if !containsString(names, res.Name) {
+			names = append(names, res.Name)
+		}
+		resolved := strings.Join(res.Resolved, "\n")
+		if res.Err != nil {
+			resolved = "error: " + res.Err.Error()
+			if !containsString(unresolved, res.Name) {
+				unresolved = append(unresolved, res.Name)
+			}
+		}
+		lastSync := ""
+		switch {
+		case res.Engine == "":
+			lastSync = "not synced"
+		case res.LastSync.IsZero():
+			lastSync = "never"
+		default:
+			lastSync = res.LastSync.Local().Format(time.RFC3339)
+		}
+		table.AddRow(tablecli.Row{res.RuleID, res.Name, resolved, res.Engine, lastSync})
+	}
+	fmt.Print(table.String())
+	if len(unresolved) > 0 {
+		fmt.Printf("Some names don't resolve now, their rules may allow no addresses: %s\n", strings.Join(unresolved, ", "))
+	}
+	if len(names) > 0 {
+		fmt.Printf("Engines resolve names when syncing and don't report the addresses they allowed. If the addresses above changed after the last sync, run \"tsuru acl rules sync-dns %s\" to update them.\n", strings.Join(names, " "))
+	}
+}
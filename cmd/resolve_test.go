@@ -0,0 +1,97 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/acl-api/api/types"
+)
+
+type fakeResolver struct {
+	mu      sync.Mutex
+	hosts   map[string][]string
+	lookups map[string]int
+	block   map[string]bool
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	r.lookups[host]++
+	r.mu.Unlock()
+	if r.block[host] {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	addrs, ok := r.hosts[host]
+	if !ok {
+		return nil, errors.Errorf("lookup %s: no such host", host)
+	}
+	return append([]string(nil), addrs...), nil
+}
+
+func dnsDestination(ruleID, name string) types.Rule {
+	return types.Rule{
+		RuleID:      ruleID,
+		Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: name}},
+	}
+}
+
+func TestResolveDNSRules(t *testing.T) {
+	timeout := dnsLookupTimeout
+	dnsLookupTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { dnsLookupTimeout = timeout })
+	resolver := &fakeResolver{
+		hosts:   map[string][]string{"a.example.org": {"10.0.0.2", "10.0.0.1"}},
+		lookups: map[string]int{},
+		block:   map[string]bool{"slow.example.org": true},
+	}
+	synced := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	rules := []types.Rule{
+		dnsDestination("r1", "a.example.org"),
+		dnsDestination("r2", "a.example.org"),
+		dnsDestination("r3", "missing.example.org"),
+		dnsDestination("r4", "slow.example.org"),
+		{RuleID: "r5", Removed: true, Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "removed.example.org"}}},
+		{RuleID: "r6", Destination: types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "10.0.0.0/8"}}},
+	}
+	rulesSync := []types.RuleSyncInfo{
+		{RuleID: "r1", Engine: "engine-b", Syncs: []types.RuleSyncData{{Successful: true, EndTime: synced}, {Successful: false}}},
+		{RuleID: "r1", Engine: "engine-a", Syncs: []types.RuleSyncData{{Successful: false}}},
+	}
+	resolutions := resolveDNSRules(context.Background(), resolver, rules, rulesSync)
+
+	if len(resolutions) != 5 {
+		t.Fatalf("got %d resolutions, want 5: %+v", len(resolutions), resolutions)
+	}
+	r1a, r1b := resolutions[0], resolutions[1]
+	if r1a.Engine != "engine-a" || !r1a.LastSync.IsZero() {
+		t.Errorf("first resolution of r1 = %+v, want engine-a never synced", r1a)
+	}
+	if r1b.Engine != "engine-b" || !r1b.LastSync.Equal(synced) {
+		t.Errorf("second resolution of r1 = %+v, want engine-b synced at %v", r1b, synced)
+	}
+	for _, res := range resolutions[:3] {
+		if len(res.Resolved) != 2 || res.Resolved[0] != "10.0.0.1" || res.Resolved[1] != "10.0.0.2" {
+			t.Errorf("rule %s resolved to %v, want the sorted addresses", res.RuleID, res.Resolved)
+		}
+	}
+	if res := resolutions[2]; res.RuleID != "r2" || res.Engine != "" {
+		t.Errorf("resolution of r2 = %+v, want one without engine", res)
+	}
+	if res := resolutions[3]; res.RuleID != "r3" || res.Err == nil {
+		t.Errorf("resolution of r3 = %+v, want a lookup error", res)
+	}
+	if res := resolutions[4]; res.RuleID != "r4" || !errors.Is(res.Err, context.DeadlineExceeded) {
+		t.Errorf("resolution of r4 = %+v, want a timeout", res)
+	}
+	if resolver.lookups["a.example.org"] != 1 || resolver.lookups["removed.example.org"] != 0 {
+		t.Errorf("lookups = %v, want each name not removed looked up once", resolver.lookups)
+	}
+}
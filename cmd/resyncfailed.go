@@ -0,0 +1,359 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-plugin/client"
+	"github.com/tsuru/tablecli"
+)
+
+const errorClassNeverSynced = "never synced"
+
+var AdminResyncFailedCmd = &cobra.Command{
+	Use:   "resync-failed [service name]",
+	Short: "Sync again every rule whose latest sync failed or is missing",
+	Long: `Sync again every rule whose latest sync failed or is missing in some engine.
+
+Rules are synced in waves of --wave-size rules with a --pause between them.
+Progress is saved to the --checkpoint file after each rule, an interrupted run
+continues from where it stopped with --resume. The file is removed once every
+rule was synced.`,
+	Example: `
+# Show what would be synced, grouped by error
+tsuru acl admin resync-failed --dry-run
+
+# Sync 20 rules per minute, 2 at a time
+tsuru acl admin resync-failed --wave-size 20 --pause 1m --concurrency 2
+
+# Continue an interrupted run
+tsuru acl admin resync-failed --resume
+	`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		serviceName, _ := serviceInstanceName(args, 1)
+		engine, _ := cmd.Flags().GetString("engine")
+		checkpointPath, _ := cmd.Flags().GetString("checkpoint")
+		resume, _ := cmd.Flags().GetBool("resume")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		c := newClient(serviceName)
+
+		var checkpoint *resyncCheckpoint
+		if resume {
+			var err error
+			checkpoint, err = loadResyncCheckpoint(checkpointPath)
+			if err != nil {
+				return err
+			}
+			if checkpoint.Service != serviceName {
+				return errors.Errorf("checkpoint %s was created for service %q, not %q", checkpointPath, checkpoint.Service, serviceName)
+			}
+			fmt.Printf("Resuming from %s, %d of %d rules already synced\n", checkpointPath, len(checkpoint.Synced), len(checkpoint.RuleIDs))
+		} else {
+			if _, err := os.Stat(checkpointPath); err == nil && !dryRun {
+				return errors.Errorf("checkpoint %s already exists, use --resume to continue the previous run or remove it", checkpointPath)
+			}
+			rulesSync, err := c.ListSync(cmd.Context())
+			if err != nil {
+				return err
+			}
+			rules, err := c.ListAllRules(cmd.Context())
+			if err != nil {
+				return err
+			}
+			groups := groupFailedRules(rules, rulesSync, engine)
+			if len(groups) == 0 {
+				fmt.Println("No failed rules found.")
+				return nil
+			}
+			renderFailedRuleGroups(groups)
+			if dryRun {
+				return nil
+			}
+			checkpoint = newResyncCheckpoint(serviceName, groups)
+		}
+
+		concurrency, _ := cmd.Flags().GetInt("concurrency")
+		waveSize, _ := cmd.Flags().GetInt("wave-size")
+		pause, _ := cmd.Flags().GetDuration("pause")
+		pending := checkpoint.Pending()
+		if dryRun {
+			fmt.Printf("%d rules would be synced.\n", len(pending))
+			return nil
+		}
+		if err := checkpoint.Save(checkpointPath); err != nil {
+			return err
+		}
+		interrupted, err := resyncInWaves(cmd.Context(), c, checkpoint, checkpointPath, pending, waveSize, concurrency, pause)
+		if err != nil {
+			return err
+		}
+		notSynced := len(checkpoint.Pending()) - len(checkpoint.Failed)
+		fmt.Printf("\n%d rules synced, %d failed, %d not synced yet.\n", len(checkpoint.Synced), len(checkpoint.Failed), notSynced)
+		if interrupted {
+			fmt.Printf("Run again with --resume to continue from %s.\n", checkpointPath)
+			return cmd.Context().Err()
+		}
+		if err = os.Remove(checkpointPath); err != nil {
+			return errors.Wrapf(err, "unable to remove checkpoint %s", checkpointPath)
+		}
+		if len(checkpoint.Failed) > 0 {
+			return errors.Errorf("%d rules failed to sync", len(checkpoint.Failed))
+		}
+		return nil
+	},
+}
+
+// failedRuleGroup holds the rules failing with the same class of error.
+type failedRuleGroup struct {
+	Class   string
+	Engines []string
+	RuleIDs []string
+}
+
+var (
+	errorClassIDRegexp     = regexp.MustCompile(`[0-9a-f]{24}(-[\w.-]+)?`)
+	errorClassIPRegexp     = regexp.MustCompile(`\d+\.\d+\.\d+\.\d+(/\d+)?`)
+	errorClassNumberRegexp = regexp.MustCompile(`\d+`)
+)
+
+// errorClass reduces a sync error to its class, removing rule IDs, IPs and
+// numbers, so errors differing only on them are grouped together.
+func errorClass(syncError string) string {
+	class := strings.TrimSpace(strings.SplitN(syncError, "\n", 2)[0])
+	class = errorClassIDRegexp.ReplaceAllString(class, "<id>")
+	class = errorClassIPRegexp.ReplaceAllString(class, "<ip>")
+	class = errorClassNumberRegexp.ReplaceAllString(class, "<n>")
+	if len(class) > 120 {
+		class = class[:120] + "..."
+	}
+	if class == "" {
+		return "failed without error message"
+	}
+	return class
+}
+
+// groupFailedRules groups rules not removed whose latest sync failed or is
+// missing in some engine by error class. A non empty engine only considers
+// that engine.
+func groupFailedRules(rules []types.Rule, rulesSync []types.RuleSyncInfo, engine string) []failedRuleGroup {
+	syncsByRule := map[string][]types.RuleSyncInfo{}
+	for _, rs := range rulesSync {
+		syncsByRule[rs.RuleID] = append(syncsByRule[rs.RuleID], rs)
+	}
+	groups := map[string]*failedRuleGroup{}
+	addRule := func(class, engine, ruleID string) {
+		g, ok := groups[class]
+		if !ok {
+			g = &failedRuleGroup{Class: class}
+			groups[class] = g
+		}
+		if engine != "" && !containsString(g.Engines, engine) {
+			g.Engines = append(g.Engines, engine)
+		}
+		if !containsString(g.RuleIDs, ruleID) {
+			g.RuleIDs = append(g.RuleIDs, ruleID)
+		}
+	}
+	for _, r := range rules {
+		if r.Removed {
+			continue
+		}
+		found := false
+		for _, rs := range syncsByRule[r.RuleID] {
+			if engine != "" && rs.Engine != engine {
+				continue
+			}
+			found = true
+			latestSync := rs.LatestSync()
+			switch {
+			case latestSync == nil:
+				addRule(errorClassNeverSynced, rs.Engine, r.RuleID)
+			case !latestSync.Successful:
+				addRule(errorClass(latestSync.Error), rs.Engine, r.RuleID)
+			}
+		}
+		if !found {
+			addRule(errorClassNeverSynced, engine, r.RuleID)
+		}
+	}
+	var result []failedRuleGroup
+	for _, g := range groups {
+		sort.Strings(g.Engines)
+		result = append(result, *g)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if len(result[i].RuleIDs) == len(result[j].RuleIDs) {
+			return result[i].Class < result[j].Class
+		}
+		return len(result[i].RuleIDs) > len(result[j].RuleIDs)
+	})
+	return result
+}
+
+func renderFailedRuleGroups(groups []failedRuleGroup) {
+	table := tablecli.NewTable()
+	table.Headers = tablecli.Row{"Error", "Engines", "Rules"}
+	for _, g := range groups {
+		table.AddRow(tablecli.Row{g.Class, strings.Join(g.Engines, "\n"), fmt.Sprintf("%d", len(g.RuleIDs))})
+	}
+	fmt.Print(table.String())
+}
+
+// resyncCheckpoint is the progress of a resync-failed run, saved as JSON.
+type resyncCheckpoint struct {
+	Service string
+	Created time.Time
+	RuleIDs []string
+	Synced  map[string]bool
+	Failed  map[string]string
+
+	mu sync.Mutex
+	// saveMu serializes saves, so a save never overwrites a newer one.
+	saveMu sync.Mutex
+}
+
+func newResyncCheckpoint(serviceName string, groups []failedRuleGroup) *resyncCheckpoint {
+	checkpoint := &resyncCheckpoint{
+		Service: serviceName,
+		Created: time.Now(),
+		Synced:  map[string]bool{},
+		Failed:  map[string]string{},
+	}
+	seen := map[string]bool{}
+	for _, g := range groups {
+		for _, id := range g.RuleIDs {
+			if !seen[id] {
+				seen[id] = true
+				checkpoint.RuleIDs = append(checkpoint.RuleIDs, id)
+			}
+		}
+	}
+	return checkpoint
+}
+
+func loadResyncCheckpoint(path string) (*resyncCheckpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read checkpoint")
+	}
+	var checkpoint resyncCheckpoint
+	err = json.Unmarshal(data, &checkpoint)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid checkpoint %s", path)
+	}
+	if checkpoint.Synced == nil {
+		checkpoint.Synced = map[string]bool{}
+	}
+	checkpoint.Failed = map[string]string{}
+	return &checkpoint, nil
+}
+
+// Pending returns the rules not synced yet, including the failed ones.
+func (c *resyncCheckpoint) Pending() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var pending []string
+	for _, id := range c.RuleIDs {
+		if !c.Synced[id] {
+			pending = append(pending, id)
+		}
+	}
+	return pending
+}
+
+// Record records the result of syncing ruleID and saves the checkpoint.
+func (c *resyncCheckpoint) Record(path, ruleID string, syncErr error) error {
+	c.mu.Lock()
+	if syncErr != nil {
+		c.Failed[ruleID] = syncErr.Error()
+	} else {
+		c.Synced[ruleID] = true
+		delete(c.Failed, ruleID)
+	}
+	c.mu.Unlock()
+	return c.Save(path)
+}
+
+// Save writes the checkpoint to path, replacing it atomically.
+func (c *resyncCheckpoint) Save(path string) error {
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+	c.mu.Lock()
+	data, err := json.MarshalIndent(c, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "unable to save checkpoint")
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to save checkpoint")
+	}
+	return errors.Wrap(os.Rename(tmpFile.Name(), path), "unable to save checkpoint")
+}
+
+// resyncInWaves syncs ruleIDs in waves of waveSize rules, pausing between
+// waves, reporting whether ctx was cancelled before every rule was synced.
+func resyncInWaves(ctx context.Context, c *client.Client, checkpoint *resyncCheckpoint, checkpointPath string, ruleIDs []string, waveSize, concurrency int, pause time.Duration) (bool, error) {
+	if waveSize < 1 {
+		waveSize = len(ruleIDs)
+	}
+	waves := (len(ruleIDs) + waveSize - 1) / waveSize
+	var saveErr error
+	var saveErrOnce sync.Once
+	for wave := 0; wave < waves; wave++ {
+		if wave > 0 && pause > 0 {
+			fmt.Printf("Waiting %v before the next wave...\n", pause)
+			select {
+			case <-ctx.Done():
+				return true, nil
+			case <-time.After(pause):
+			}
+		}
+		end := (wave + 1) * waveSize
+		if end > len(ruleIDs) {
+			end = len(ruleIDs)
+		}
+		waveIDs := ruleIDs[wave*waveSize : end]
+		fmt.Printf("\nWave %d/%d: syncing %d rules\n", wave+1, waves, len(waveIDs))
+		progress := newBulkProgress(os.Stdout, len(waveIDs))
+		summary := runBulk(ctx, waveIDs, concurrency, func(ctx context.Context, i int, ruleID string) error {
+			err := c.ForceSyncRule(ctx, ruleID)
+			progress.Done(ruleID, err)
+			if recordErr := checkpoint.Record(checkpointPath, ruleID, err); recordErr != nil {
+				saveErrOnce.Do(func() { saveErr = recordErr })
+			}
+			return err
+		})
+		if saveErr != nil {
+			return false, saveErr
+		}
+		if summary.Interrupted {
+			return true, nil
+		}
+	}
+	return false, nil
+}
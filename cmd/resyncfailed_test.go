@@ -0,0 +1,63 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestResyncCheckpointConcurrentRecord(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "checkpoint.json")
+	var ruleIDs []string
+	for i := 0; i < 50; i++ {
+		ruleIDs = append(ruleIDs, fmt.Sprintf("r%d", i))
+	}
+	checkpoint := newResyncCheckpoint("acl", []failedRuleGroup{{RuleIDs: ruleIDs}})
+	var wg sync.WaitGroup
+	errs := make([]error, len(ruleIDs))
+	for i, id := range ruleIDs {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			var syncErr error
+			if i%10 == 0 {
+				syncErr = errors.New("sync failed")
+			}
+			errs[i] = checkpoint.Record(path, id, syncErr)
+		}(i, id)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("recording %s: %v", ruleIDs[i], err)
+		}
+	}
+
+	loaded, err := loadResyncCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending := loaded.Pending(); len(pending) != 5 {
+		t.Errorf("got pending rules %v, want the 5 failed ones", pending)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("got files %v, want only the checkpoint", names)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-plugin/client"
+)
+
+type serviceRuleDetails struct {
+	BaseRule      types.ServiceRule
+	ExpandedRules []types.Rule
+	RulesSync     []types.RuleSyncInfo
+}
+
+// serviceRuleDetailsJSON is a serviceRuleDetails keeping the JSON sent by
+// the API.
+type serviceRuleDetailsJSON struct {
+	BaseRule      json.RawMessage
+	ExpandedRules json.RawMessage
+	RulesSync     json.RawMessage
+}
+
+var ShowRuleCmd = &cobra.Command{
+	Use:   "show [service name] [instance name] [id]",
+	Short: "Show a rule, its expanded rules and every sync attempt",
+	Example: `
+# Show the sync attempts of the last day in a single engine
+tsuru acl rules show <ACL SERVICE> <RULE ID> --since 24h --engine acl-operator
+	`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filter, err := parseSyncFilter(cmd.Flags())
+		if err != nil {
+			return err
+		}
+		statusOpts, err := parseStatusOptions(cmd.Flags())
+		if err != nil {
+			return err
+		}
+		serviceName, instanceName := serviceInstanceName(args, 2)
+		ruleID := args[len(args)-1]
+		ruleDataJSON, err := newClient(serviceName).ListRulesJSON(cmd.Context(), instanceName)
+		if err != nil {
+			return err
+		}
+		var ruleData client.ServiceRules
+		if err = json.Unmarshal(ruleDataJSON, &ruleData); err != nil {
+			return errors.Wrapf(err, "unable to unmarshal %q", string(ruleDataJSON))
+		}
+		details, err := findServiceRuleDetails(ruleData.ServiceInstance, ruleData.ExpandedRules, ruleData.RulesSync, ruleID)
+		if err != nil {
+			return err
+		}
+		_, details.ExpandedRules, details.RulesSync = filterRulesByStatus(nil, details.ExpandedRules, details.RulesSync, statusOpts)
+		// Statuses are computed from every sync attempt, --since and
+		// --engine only filter the displayed history.
+		statusErr := checkExpectedStatus(details.ExpandedRules, details.RulesSync, statusOpts)
+		allSync := details.RulesSync
+		details.RulesSync = filter.Apply(details.RulesSync)
+
+		jsonOutput, _ := cmd.Flags().GetBool("json")
+		if jsonOutput {
+			err = printServiceRuleDetailsJSON(ruleDataJSON, details, filter)
+			if err != nil {
+				return err
+			}
+			return statusErr
+		}
+		inheritRuleNames([]types.ServiceRule{details.BaseRule}, details.ExpandedRules)
+		renderRule(details.BaseRule.Rule)
+		fmt.Println("\nExpanded Rules (for each bound app):")
+		renderExpandedRules(details.ExpandedRules, allSync, statusOpts.StaleAfter)
+		fmt.Println("\nSync history:")
+		renderSyncHistory(details.RulesSync)
+		if resolver := parseResolver(cmd.Flags()); resolver != nil {
+			renderDNSResolutions(resolveDNSRules(cmd.Context(), resolver, details.ExpandedRules, allSync))
+		}
+		return statusErr
+	},
+}
+
+func findServiceRuleDetails(si types.ServiceInstance, expandedRules []types.Rule, rulesSync []types.RuleSyncInfo, ruleID string) (*serviceRuleDetails, error) {
+	var details *serviceRuleDetails
+	for _, r := range si.BaseRules {
+		if r.RuleID == ruleID {
+			r.Rule.Creator = r.Creator
+			details = &serviceRuleDetails{BaseRule: r}
+			break
+		}
+	}
+	if details == nil {
+		return nil, errors.Errorf("rule %q not found in instance %q", ruleID, si.InstanceName)
+	}
+	expandedIDs := map[string]bool{}
+	for _, r := range expandedRules {
+		if r.Metadata["base-ruleid"] == ruleID {
+			details.ExpandedRules = append(details.ExpandedRules, r)
+			expandedIDs[r.RuleID] = true
+		}
+	}
+	for _, rs := range rulesSync {
+		if expandedIDs[rs.RuleID] {
+			details.RulesSync = append(details.RulesSync, rs)
+		}
+	}
+	return details, nil
+}
+
+// printServiceRuleDetailsJSON prints the parts of the JSON of the rules of
+// an instance in details, as sent by the API.
+func printServiceRuleDetailsJSON(ruleDataJSON json.RawMessage, details *serviceRuleDetails, filter syncFilter) error {
+	var ruleData struct {
+		ServiceInstance struct {
+			BaseRules json.RawMessage
+		}
+		ExpandedRules json.RawMessage
+		RulesSync     json.RawMessage
+	}
+	if err := json.Unmarshal(ruleDataJSON, &ruleData); err != nil {
+		return errors.Wrap(err, "unable to parse rules JSON")
+	}
+	baseRules, err := filterJSONRules(ruleData.ServiceInstance.BaseRules, map[string]bool{details.BaseRule.RuleID: true})
+	if err != nil {
+		return err
+	}
+	var output serviceRuleDetailsJSON
+	var baseRule []json.RawMessage
+	if err = json.Unmarshal(baseRules, &baseRule); err != nil || len(baseRule) != 1 {
+		return errors.Errorf("rule %q not found in rules JSON", details.BaseRule.RuleID)
+	}
+	output.BaseRule = baseRule[0]
+	ids := ruleIDs(details.ExpandedRules)
+	if output.ExpandedRules, err = filterJSONRules(ruleData.ExpandedRules, ids); err != nil {
+		return err
+	}
+	if output.RulesSync, err = filterJSONSyncHistory(ruleData.RulesSync, ids, filter); err != nil {
+		return err
+	}
+	data, err := json.Marshal(output)
+	if err != nil {
+		return err
+	}
+	return printJSON(data)
+}
+
+// filterJSONSyncHistory is syncFilter.Apply on the JSON list of sync
+// information data, keeping only rules in ids.
+func filterJSONSyncHistory(data json.RawMessage, ids map[string]bool, filter syncFilter) (json.RawMessage, error) {
+	var items []json.RawMessage
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, errors.Wrap(err, "unable to parse sync JSON")
+		}
+	}
+	filtered := []map[string]json.RawMessage{}
+	for _, item := range items {
+		var fields map[string]json.RawMessage
+		var rs struct {
+			RuleID string
+			Engine string
+			Syncs  []json.RawMessage
+		}
+		if err := json.Unmarshal(item, &fields); err != nil {
+			return nil, errors.Wrap(err, "unable to parse sync JSON")
+		}
+		if err := json.Unmarshal(item, &rs); err != nil {
+			return nil, errors.Wrap(err, "unable to parse sync JSON")
+		}
+		if !ids[rs.RuleID] || (filter.Engine != "" && rs.Engine != filter.Engine) {
+			continue
+		}
+		syncs := []json.RawMessage{}
+		for _, rawSync := range rs.Syncs {
+			var sync struct{ StartTime time.Time }
+			if err := json.Unmarshal(rawSync, &sync); err != nil {
+				return nil, errors.Wrap(err, "unable to parse sync JSON")
+			}
+			if !sync.StartTime.Before(filter.Since) {
+				syncs = append(syncs, rawSync)
+			}
+		}
+		var err error
+		if fields["Syncs"], err = json.Marshal(syncs); err != nil {
+			return nil, err
+		}
+		filtered = append(filtered, fields)
+	}
+	return json.Marshal(filtered)
+}
@@ -0,0 +1,229 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"github.com/tsuru/acl-api/api/types"
+)
+
+type ruleStatus string
+
+const (
+	// statusPending means at least one engine never synced the rule and none
+	// failed to sync it.
+	statusPending ruleStatus = "pending"
+	// statusSynced means the latest sync succeeded in every engine.
+	statusSynced ruleStatus = "synced"
+	// statusPartial means the latest sync failed in some engines and
+	// succeeded in others.
+	statusPartial ruleStatus = "partial"
+	// statusFailing means the latest sync failed in every engine that tried
+	// to sync the rule.
+	statusFailing ruleStatus = "failing"
+	// statusStale means the latest sync succeeded in every engine but at
+	// least one of them did not sync the rule for longer than the stale
+	// threshold.
+	statusStale ruleStatus = "stale"
+)
+
+var allStatuses = []ruleStatus{statusPending, statusSynced, statusPartial, statusFailing, statusStale}
+
+const defaultStaleAfter = 24 * time.Hour
+
+type ruleSyncStatus struct {
+	Status         ruleStatus
+	FailingEngines []string
+	PendingEngines []string
+	LastSuccess    time.Time
+}
+
+func (s ruleSyncStatus) String() string {
+	switch s.Status {
+	case statusPartial:
+		return string(s.Status) + " (failing: " + strings.Join(s.FailingEngines, ", ") + ")"
+	case statusPending:
+		if len(s.PendingEngines) > 0 {
+			return string(s.Status) + " (" + strings.Join(s.PendingEngines, ", ") + ")"
+		}
+	}
+	return string(s.Status)
+}
+
+// computeRuleStatus derives the status of a rule from the sync information
+// of every engine.
+func computeRuleStatus(rulesSync []types.RuleSyncInfo, now time.Time, staleAfter time.Duration) ruleSyncStatus {
+	var status ruleSyncStatus
+	succeeded := 0
+	var oldestSuccess time.Time
+	for _, rs := range rulesSync {
+		latestSync := rs.LatestSync()
+		if latestSync == nil {
+			status.PendingEngines = append(status.PendingEngines, rs.Engine)
+			continue
+		}
+		if !latestSync.Successful {
+			status.FailingEngines = append(status.FailingEngines, rs.Engine)
+			continue
+		}
+		succeeded++
+		if oldestSuccess.IsZero() || latestSync.EndTime.Before(oldestSuccess) {
+			oldestSuccess = latestSync.EndTime
+		}
+		if latestSync.EndTime.After(status.LastSuccess) {
+			status.LastSuccess = latestSync.EndTime
+		}
+	}
+	sort.Strings(status.FailingEngines)
+	sort.Strings(status.PendingEngines)
+	switch {
+	case len(status.FailingEngines) > 0 && succeeded > 0:
+		status.Status = statusPartial
+	case len(status.FailingEngines) > 0:
+		status.Status = statusFailing
+	case succeeded == 0 || len(status.PendingEngines) > 0:
+		status.Status = statusPending
+	case staleAfter > 0 && now.Sub(oldestSuccess) > staleAfter:
+		status.Status = statusStale
+	default:
+		status.Status = statusSynced
+	}
+	return status
+}
+
+// ruleStatuses computes the status of each rule in rules.
+func ruleStatuses(rules []types.Rule, rulesSync []types.RuleSyncInfo, staleAfter time.Duration) map[string]ruleSyncStatus {
+	syncsByRule := map[string][]types.RuleSyncInfo{}
+	for _, rs := range rulesSync {
+		syncsByRule[rs.RuleID] = append(syncsByRule[rs.RuleID], rs)
+	}
+	now := time.Now()
+	statuses := map[string]ruleSyncStatus{}
+	for _, r := range rules {
+		statuses[r.RuleID] = computeRuleStatus(syncsByRule[r.RuleID], now, staleAfter)
+	}
+	return statuses
+}
+
+func parseStatuses(raw []string, flagName string) ([]ruleStatus, error) {
+	var statuses []ruleStatus
+	for _, s := range raw {
+		status := ruleStatus(strings.ToLower(strings.TrimSpace(s)))
+		if !containsStatus(allStatuses, status) {
+			valid := make([]string, len(allStatuses))
+			for i, st := range allStatuses {
+				valid[i] = string(st)
+			}
+			return nil, errors.Errorf("invalid --%s %q, valid values are: %s", flagName, s, strings.Join(valid, ", "))
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func containsStatus(statuses []ruleStatus, status ruleStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// statusOptions holds the --stale-after, --status and --expect flags shared
+// by commands displaying rule statuses.
+type statusOptions struct {
+	StaleAfter time.Duration
+	Filter     []ruleStatus
+	Expect     []ruleStatus
+}
+
+func parseStatusOptions(flags *pflag.FlagSet) (statusOptions, error) {
+	opts := statusOptions{StaleAfter: defaultStaleAfter}
+	if flags.Lookup("stale-after") != nil {
+		opts.StaleAfter, _ = flags.GetDuration("stale-after")
+	}
+	var err error
+	if flags.Lookup("status") != nil {
+		raw, _ := flags.GetStringSlice("status")
+		opts.Filter, err = parseStatuses(raw, "status")
+		if err != nil {
+			return opts, err
+		}
+	}
+	if flags.Lookup("expect") != nil {
+		raw, _ := flags.GetStringSlice("expect")
+		opts.Expect, err = parseStatuses(raw, "expect")
+		if err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// filterRulesByStatus keeps only rules whose status is in opts.Filter, along
+// with their sync information and the base rules they were expanded from.
+func filterRulesByStatus(serviceInstances []types.ServiceInstance, rules []types.Rule, rulesSync []types.RuleSyncInfo, opts statusOptions) ([]types.ServiceInstance, []types.Rule, []types.RuleSyncInfo) {
+	if len(opts.Filter) == 0 {
+		return serviceInstances, rules, rulesSync
+	}
+	statuses := ruleStatuses(rules, rulesSync, opts.StaleAfter)
+	var filteredRules []types.Rule
+	selected := map[string]bool{}
+	selectedBase := map[string]bool{}
+	for _, r := range rules {
+		if containsStatus(opts.Filter, statuses[r.RuleID].Status) {
+			filteredRules = append(filteredRules, r)
+			selected[r.RuleID] = true
+			selectedBase[r.Metadata["base-ruleid"]] = true
+		}
+	}
+	filteredInstances := make([]types.ServiceInstance, len(serviceInstances))
+	for i, si := range serviceInstances {
+		var baseRules []types.ServiceRule
+		for _, r := range si.BaseRules {
+			if selectedBase[r.RuleID] {
+				baseRules = append(baseRules, r)
+			}
+		}
+		si.BaseRules = baseRules
+		filteredInstances[i] = si
+	}
+	var filteredSync []types.RuleSyncInfo
+	for _, rs := range rulesSync {
+		if selected[rs.RuleID] {
+			filteredSync = append(filteredSync, rs)
+		}
+	}
+	return filteredInstances, filteredRules, filteredSync
+}
+
+// checkExpectedStatus returns an error listing every rule whose status is not
+// in opts.Expect. Removed rules are ignored.
+func checkExpectedStatus(rules []types.Rule, rulesSync []types.RuleSyncInfo, opts statusOptions) error {
+	if len(opts.Expect) == 0 {
+		return nil
+	}
+	statuses := ruleStatuses(rules, rulesSync, opts.StaleAfter)
+	var unexpected []string
+	for _, r := range rules {
+		if r.Removed {
+			continue
+		}
+		status := statuses[r.RuleID]
+		if !containsStatus(opts.Expect, status.Status) {
+			unexpected = append(unexpected, r.RuleID+": "+status.String())
+		}
+	}
+	if len(unexpected) > 0 {
+		return errors.Errorf("%d rules not in the expected status:\n%s", len(unexpected), strings.Join(unexpected, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// syncResultRenderer renders the SyncResult of a sync attempt of an engine
+// in a human readable way. An error means the result is not in the format
+// expected by the renderer.
+type syncResultRenderer func(syncResult string) (string, error)
+
+// syncResultRenderers maps engine names to their renderers. Engines running
+// in several clusters are usually named after the engine with a cluster
+// suffix, e.g. "acl-operator-cluster-b", so the longest matching prefix is
+// used.
+var syncResultRenderers = map[string]syncResultRenderer{
+	"acl-operator": renderOperatorSyncResult,
+}
+
+// renderSyncResult renders syncResult with the renderer registered for
+// engine, falling back to indented JSON for unknown engines or results.
+func renderSyncResult(engine, syncResult string) string {
+	if syncResult == "" {
+		return ""
+	}
+	var bestPrefix string
+	for prefix := range syncResultRenderers {
+		if strings.HasPrefix(engine, prefix) && len(prefix) > len(bestPrefix) {
+			bestPrefix = prefix
+		}
+	}
+	if bestPrefix != "" {
+		rendered, err := syncResultRenderers[bestPrefix](syncResult)
+		if err == nil {
+			return rendered
+		}
+	}
+	return formatSyncResult(syncResult)
+}
+
+// Results of the acl-operator engine. The engine doesn't create network
+// policies itself, it only annotates the tsuru App custom resource so
+// acl-operator updates the policies of the app. An empty result means the
+// app was not found in the cluster.
+const (
+	operatorTriggered         = "triggered acl-operator"
+	operatorTriggeredRecently = "triggered acl-operator in the last minute"
+)
+
+func renderOperatorSyncResult(syncResult string) (string, error) {
+	var message string
+	if err := json.Unmarshal([]byte(syncResult), &message); err != nil {
+		return "", err
+	}
+	switch message {
+	case "":
+		return "app not found in the cluster, no network policies changed", nil
+	case operatorTriggered:
+		return "acl-operator triggered to update the network policies of the app", nil
+	case operatorTriggeredRecently:
+		return "acl-operator already triggered in the last minute, it will update the network policies of the app", nil
+	}
+	return message, nil
+}
@@ -0,0 +1,28 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import "testing"
+
+func TestRenderSyncResult(t *testing.T) {
+	tests := []struct {
+		engine     string
+		syncResult string
+		want       string
+	}{
+		{"acl-operator", `"triggered acl-operator"`, "acl-operator triggered to update the network policies of the app"},
+		{"acl-operator-cluster-b", `"triggered acl-operator in the last minute"`, "acl-operator already triggered in the last minute, it will update the network policies of the app"},
+		{"acl-operator", `""`, "app not found in the cluster, no network policies changed"},
+		{"acl-operator", `"something else"`, "something else"},
+		{"acl-operator", `{"a":1}`, "{\n  \"a\": 1\n}"},
+		{"other", `"triggered acl-operator"`, "triggered acl-operator"},
+		{"other", "", ""},
+	}
+	for _, tt := range tests {
+		if got := renderSyncResult(tt.engine, tt.syncResult); got != tt.want {
+			t.Errorf("renderSyncResult(%q, %q) = %q, want %q", tt.engine, tt.syncResult, got, tt.want)
+		}
+	}
+}
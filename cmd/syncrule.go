@@ -5,79 +5,337 @@
 package cmd
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"net/http"
 	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-plugin/client"
+	"github.com/tsuru/tablecli"
 )
 
-type forceResponse struct {
-	Count int `json:"count"`
-}
-
 var ForceSyncCmd = &cobra.Command{
 	Use:   "sync [app name]",
 	Short: "Force sync rules (for debug/troubleshooting purpose)",
-	Args:  cobra.ExactArgs(1),
+	Long: `Force sync every rule of an app, or the rules matching the selector flags.
+
+With selector flags, every rule is matched against all of them, flags with
+several values match if any of the values matches. The app name, if set, is
+used as one more selector. Rules are always synced in every engine, --engine
+only selects which rules are synced.`,
+	Example: `
+# Sync every rule of an app
+tsuru acl rules sync myapp
+
+# Sync rules failing in a single engine, 5 per second
+tsuru acl rules sync --failed --engine acl-operator-cluster-b --rate 5
+
+# Sync rules to any address inside 10.0.0.0/8 and to any subdomain of example.com
+tsuru acl rules sync --ip 10.0.0.0/8 --dns '*.example.com' --dry-run
+	`,
+	Args: cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		appName := args[0]
-		rsp, err := doProxyAdminRequest(http.MethodPost, defaultServiceName, "/apps/"+appName+"/sync", nil)
+		var appName string
+		if len(args) > 0 {
+			appName = args[0]
+		}
+		selector, err := parseRuleSyncSelector(cmd.Flags(), appName)
 		if err != nil {
 			return err
 		}
-		defer rsp.Body.Close()
+		serviceName, _ := cmd.Flags().GetString("service")
+		c := newClient(serviceName)
+		if selector.Empty() {
+			if appName == "" {
+				return errors.New("either an app name or a selector flag must be set")
+			}
+			count, err := c.ForceSyncApp(cmd.Context(), appName)
+			if err != nil {
+				return err
+			}
 
-		var response forceResponse
-		err = json.NewDecoder(rsp.Body).Decode(&response)
+			fmt.Printf("Sync request sent, %d rules synced\n", count)
+			return nil
+		}
+
+		rules, err := selectRulesToSync(cmd.Context(), c, selector)
 		if err != nil {
 			return err
 		}
+		if len(rules) == 0 {
+			return errors.New("no rules match the selector")
+		}
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		if dryRun {
+			fmt.Printf("%d rules would be synced:\n", len(rules))
+			renderSyncSummary(rules, nil)
+			return nil
+		}
 
-		fmt.Printf("Sync request sent, %d rules synced\n", response.Count)
+		concurrency, _ := cmd.Flags().GetInt("concurrency")
+		rate, _ := cmd.Flags().GetFloat64("rate")
+		wait, _ := cmd.Flags().GetBool("wait")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		limiter := newRateLimiter(rate)
+		defer limiter.Stop()
+		ruleIDs := make([]string, len(rules))
+		for i, r := range rules {
+			ruleIDs[i] = r.RuleID
+		}
+		fmt.Printf("Syncing %d rules\n", len(ruleIDs))
+		progress := newBulkProgress(os.Stdout, len(ruleIDs))
+		// Rules are synced with the command context, syncRule lets the sync
+		// requests already sent finish when interrupted.
+		summary := runBulk(cmd.Context(), ruleIDs, concurrency, func(_ context.Context, i int, ruleID string) error {
+			err := errSkipped
+			if limiter.Wait(cmd.Context()) == nil {
+				err = syncRule(cmd.Context(), c, ruleID, wait, timeout)
+			}
+			progress.Done(ruleID, err)
+			return err
+		})
+		fmt.Println()
+		renderSyncSummary(rules, summary)
+		if summary.Interrupted {
+			return cmd.Context().Err()
+		}
+		if failed := summary.Failed(); len(failed) > 0 {
+			return errors.Errorf("%d of %d rules failed to sync", len(failed), len(ruleIDs))
+		}
 		return nil
 	},
 }
 
+// selectRulesToSync returns the rules not removed matching selector.
+func selectRulesToSync(ctx context.Context, c *client.Client, selector ruleSyncSelector) ([]types.Rule, error) {
+	rules, err := c.ListAllRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rulesSync, err := c.ListSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	syncsByRule := map[string][]types.RuleSyncInfo{}
+	for _, rs := range rulesSync {
+		syncsByRule[rs.RuleID] = append(syncsByRule[rs.RuleID], rs)
+	}
+	var selected []types.Rule
+	for _, r := range rules {
+		if !r.Removed && selector.Matches(r, syncsByRule[r.RuleID]) {
+			selected = append(selected, r)
+		}
+	}
+	return selected, nil
+}
+
+// renderSyncSummary renders the rules being synced with the result of each
+// one, a nil summary renders only the rules.
+func renderSyncSummary(rules []types.Rule, summary *bulkSummary) {
+	table := tablecli.NewTable()
+	table.Headers = tablecli.Row{"Rule ID", "Instance", "Source", "Destination"}
+	if summary != nil {
+		table.Headers = append(table.Headers, "Result")
+	}
+	for i, r := range rules {
+		row := tablecli.Row{r.RuleID, r.Metadata["instance-name"], r.Source.String(), r.Destination.String()}
+		if summary != nil {
+			result := summary.Results[i]
+			switch {
+			case result.Skipped:
+				row = append(row, "skipped")
+			case result.Err != nil:
+				row = append(row, "failed: "+result.Err.Error())
+			default:
+				row = append(row, "synced")
+			}
+		}
+		table.AddRow(row)
+	}
+	fmt.Print(table.String())
+	if summary != nil {
+		failed, skipped := len(summary.Failed()), len(summary.Skipped())
+		fmt.Printf("%d succeeded, %d failed, %d skipped.\n", len(rules)-failed-skipped, failed, skipped)
+	}
+}
+
 var SyncDNSCmd = &cobra.Command{
-	Use:   "sync-dns [cname]",
-	Short: "Force sync rules (for debug/troubleshooting purpose)",
-	Args:  cobra.ExactArgs(1),
+	Use:   "sync-dns [name or pattern]...",
+	Short: "Force sync rules to DNS names (for debug/troubleshooting purpose)",
+	Long: `Force sync every rule whose destination is one of the given DNS names.
+
+Names may be glob patterns, e.g. "*.example.com", matched against the DNS
+destination of every rule.`,
+	Example: `
+# Sync every rule to example.org and to any subdomain of example.com
+tsuru acl rules sync-dns example.org '*.example.com' --concurrency 8
+
+# Sync and wait for every engine to report the result
+tsuru acl rules sync-dns example.org --wait --timeout 2m
+	`,
+	Args: cobra.MinimumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		dns := args[0]
+		serviceName, _ := cmd.Flags().GetString("service")
+		concurrency, _ := cmd.Flags().GetInt("concurrency")
+		wait, _ := cmd.Flags().GetBool("wait")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		c := newClient(serviceName)
 
-		ruleIDs, err := rulesIDFromDNS(dns)
+		ruleIDs, err := rulesIDFromDNSNames(cmd.Context(), c, args)
 		if err != nil {
 			return err
 		}
-
-		for i, ruleID := range ruleIDs {
-			fmt.Printf("%d/%d Syncing rule %s\n", i+1, len(ruleIDs), ruleID)
-			err = forceSyncRuleID(ruleID)
-			if err != nil {
-				fmt.Printf("Error Syncing rule %s, error: %s", ruleID, err)
-			}
+		if len(ruleIDs) == 0 {
+			return errors.Errorf("no rules found for %s", strings.Join(args, ", "))
 		}
 
+		fmt.Printf("Syncing %d rules\n", len(ruleIDs))
+		progress := newBulkProgress(os.Stdout, len(ruleIDs))
+		summary := runBulk(cmd.Context(), ruleIDs, concurrency, func(_ context.Context, i int, ruleID string) error {
+			err := syncRule(cmd.Context(), c, ruleID, wait, timeout)
+			progress.Done(ruleID, err)
+			return err
+		})
+		summary.Print(os.Stdout)
+		if summary.Interrupted {
+			return cmd.Context().Err()
+		}
+		if failed := summary.Failed(); len(failed) > 0 {
+			return errors.Errorf("%d of %d rules failed to sync", len(failed), len(ruleIDs))
+		}
 		return nil
 	},
 }
 
-func rulesIDFromDNS(dns string) ([]string, error) {
-	var rules []types.Rule
+// syncRule forces the sync of ruleID. With wait it also waits until every
+// engine reports a successful sync started after the forced one. Once sent,
+// the sync request is allowed to finish after ctx is cancelled, but waiting
+// stops right away.
+func syncRule(ctx context.Context, c *client.Client, ruleID string, wait bool, timeout time.Duration) error {
+	var previous []types.RuleSyncInfo
+	if wait {
+		var err error
+		previous, err = c.GetRuleSync(ctx, ruleID)
+		if ctx.Err() != nil {
+			return errSkipped
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err := c.ForceSyncRule(withoutCancel(ctx), ruleID)
+	if err != nil || !wait {
+		return err
+	}
+	err = pollRulesSynced(ctx, ruleID, timeout, func(ctx context.Context) ([]string, []types.RuleSyncInfo, error) {
+		rulesSync, err := c.GetRuleSync(ctx, ruleID)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []string{ruleID}, newerSyncs(rulesSync, previous), nil
+	})
+	if err != nil && ctx.Err() == context.Canceled {
+		return errSyncNotWaited
+	}
+	return err
+}
+
+var errSyncNotWaited = errors.New("sync requested, interrupted while waiting for it")
 
-	q := url.Values{}
-	q.Set("destination.externaldns.name", dns)
+// newerSyncs returns a copy of rulesSync keeping only sync attempts started
+// after the latest attempt of the same rule and engine in previous. Using the
+// timestamps recorded by the API avoids depending on the local clock.
+func newerSyncs(rulesSync, previous []types.RuleSyncInfo) []types.RuleSyncInfo {
+	latest := map[string]time.Time{}
+	for _, rs := range previous {
+		if latestSync := rs.LatestSync(); latestSync != nil {
+			latest[rs.RuleID+"/"+rs.Engine] = latestSync.StartTime
+		}
+	}
+	var filtered []types.RuleSyncInfo
+	for _, rs := range rulesSync {
+		since := latest[rs.RuleID+"/"+rs.Engine]
+		var syncs []types.RuleSyncData
+		for _, s := range rs.Syncs {
+			if s.StartTime.After(since) {
+				syncs = append(syncs, s)
+			}
+		}
+		rs.Syncs = syncs
+		filtered = append(filtered, rs)
+	}
+	return filtered
+}
 
-	rsp, err := doProxyAdminRequest(http.MethodGet, defaultServiceName, "/rules?"+q.Encode(), nil)
+// rulesIDFromDNSNames returns the IDs of the rules not removed whose
+// destination is one of names. Names containing glob characters are matched
+// against every DNS rule with path.Match.
+func rulesIDFromDNSNames(ctx context.Context, c *client.Client, names []string) ([]string, error) {
+	var patterns []string
+	ruleIDs := []string{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		if strings.ContainsAny(name, "*?[") {
+			if _, err := path.Match(name, ""); err != nil {
+				return nil, errors.Errorf("invalid pattern %q: %v", name, err)
+			}
+			patterns = append(patterns, name)
+			continue
+		}
+		ids, err := rulesIDFromDNS(ctx, c, name)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			fmt.Printf("No rules found for %s\n", name)
+		}
+		for _, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				ruleIDs = append(ruleIDs, id)
+			}
+		}
+	}
+	if len(patterns) == 0 {
+		return ruleIDs, nil
+	}
+	rules, err := c.ListAllRules(ctx)
 	if err != nil {
 		return nil, err
 	}
-	defer rsp.Body.Close()
+	for _, pattern := range patterns {
+		found := false
+		for _, r := range rules {
+			if r.Removed || r.Destination.ExternalDNS == nil {
+				continue
+			}
+			if matched, _ := path.Match(pattern, r.Destination.ExternalDNS.Name); !matched {
+				continue
+			}
+			found = true
+			if !seen[r.RuleID] {
+				seen[r.RuleID] = true
+				ruleIDs = append(ruleIDs, r.RuleID)
+			}
+		}
+		if !found {
+			fmt.Printf("No rules found for %s\n", pattern)
+		}
+	}
+	return ruleIDs, nil
+}
+
+func rulesIDFromDNS(ctx context.Context, c *client.Client, dns string) ([]string, error) {
+	q := url.Values{}
+	q.Set("destination.externaldns.name", dns)
 
-	err = json.NewDecoder(rsp.Body).Decode(&rules)
+	rules, err := c.FindRules(ctx, q)
 	if err != nil {
 		return nil, err
 	}
@@ -92,17 +350,3 @@ func rulesIDFromDNS(dns string) ([]string, error) {
 
 	return ruleIDs, nil
 }
-
-func forceSyncRuleID(ruleID string) error {
-	rsp, err := doProxyAdminRequest(http.MethodPost, defaultServiceName, "/rules/"+ruleID+"/sync", nil)
-	if err != nil {
-		return err
-	}
-	defer rsp.Body.Close()
-
-	if rsp.StatusCode >= http.StatusBadRequest {
-		return fmt.Errorf("invalid status code: %d", rsp.StatusCode)
-	}
-
-	return nil
-}
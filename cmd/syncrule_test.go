@@ -0,0 +1,53 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/acl-api/api/types"
+)
+
+func TestSyncRuleStopsWaitingWhenInterrupted(t *testing.T) {
+	srv, c := newTestServer(t)
+	srv.SetEngines()
+	ruleID := srv.AddRule(types.Rule{Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "example.org"}}})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	err := syncRule(ctx, c, ruleID, true, time.Minute)
+	if !errors.Is(err, errSyncNotWaited) {
+		t.Fatalf("got error %v, want %v", err, errSyncNotWaited)
+	}
+	calls := srv.Calls()
+	time.Sleep(5 * syncPollInterval)
+	if after := srv.Calls(); len(after) != len(calls) {
+		t.Errorf("got calls %v after the interruption", after[len(calls):])
+	}
+	if !containsString(calls, "POST /rules/"+ruleID+"/sync") {
+		t.Errorf("got calls %v, want the sync requested", calls)
+	}
+}
+
+func TestSyncRuleSkippedWhenInterruptedBeforeRequest(t *testing.T) {
+	srv, c := newTestServer(t)
+	ruleID := srv.AddRule(types.Rule{Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "example.org"}}})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := syncRule(ctx, c, ruleID, true, time.Minute)
+	if !errors.Is(err, errSkipped) {
+		t.Fatalf("got error %v, want %v", err, errSkipped)
+	}
+	for _, call := range srv.Calls() {
+		if strings.HasPrefix(call, "POST") {
+			t.Errorf("got call %q, want no sync requested", call)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"net"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"github.com/tsuru/acl-api/api/types"
+)
+
+// ruleSyncSelector selects rules to be synced. Every field set must match,
+// fields with several values match if any of the values matches.
+type ruleSyncSelector struct {
+	App       string
+	RuleIDs   []string
+	Networks  []*net.IPNet
+	DNS       []string
+	Instances []string
+	Pools     []string
+	Engine    string
+	Failed    bool
+}
+
+// parseRuleSyncSelector returns the selector set by the --rule-id, --ip,
+// --dns, --instance, --pool, --engine and --failed flags. A non empty app
+// selects rules from or to the app.
+func parseRuleSyncSelector(flags *pflag.FlagSet, app string) (ruleSyncSelector, error) {
+	selector := ruleSyncSelector{App: app}
+	selector.RuleIDs, _ = flags.GetStringSlice("rule-id")
+	selector.DNS, _ = flags.GetStringSlice("dns")
+	selector.Instances, _ = flags.GetStringSlice("instance")
+	selector.Pools, _ = flags.GetStringSlice("pool")
+	selector.Engine, _ = flags.GetString("engine")
+	selector.Failed, _ = flags.GetBool("failed")
+	for _, pattern := range selector.DNS {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return selector, errors.Errorf("invalid --dns pattern %q: %v", pattern, err)
+		}
+	}
+	rawNetworks, _ := flags.GetStringSlice("ip")
+	for _, raw := range rawNetworks {
+		n, err := parseNetwork(raw)
+		if err != nil {
+			return selector, errors.Errorf("invalid --ip %q, must be an IP or a CIDR, e.g. \"10.0.0.0/8\"", raw)
+		}
+		selector.Networks = append(selector.Networks, n)
+	}
+	return selector, nil
+}
+
+// parseNetwork parses a CIDR or a single IP, which is handled as a /32 or
+// /128 network.
+func parseNetwork(raw string) (*net.IPNet, error) {
+	if _, n, err := net.ParseCIDR(raw); err == nil {
+		return n, nil
+	}
+	ip := net.ParseIP(raw)
+	if ip == nil {
+		return nil, errors.Errorf("invalid IP %q", raw)
+	}
+	bits := 128
+	if ip.To4() != nil {
+		ip = ip.To4()
+		bits = 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// networkContains reports whether inner is entirely inside outer.
+func networkContains(outer, inner *net.IPNet) bool {
+	outerOnes, outerBits := outer.Mask.Size()
+	innerOnes, innerBits := inner.Mask.Size()
+	return outerBits == innerBits && innerOnes >= outerOnes && outer.Contains(inner.IP)
+}
+
+// Empty reports whether no selector is set, except for the app.
+func (s ruleSyncSelector) Empty() bool {
+	return len(s.RuleIDs) == 0 && len(s.Networks) == 0 && len(s.DNS) == 0 &&
+		len(s.Instances) == 0 && len(s.Pools) == 0 && s.Engine == "" && !s.Failed
+}
+
+// Matches reports whether r is selected, rulesSync must hold the sync
+// information of r in every engine.
+func (s ruleSyncSelector) Matches(r types.Rule, rulesSync []types.RuleSyncInfo) bool {
+	if s.App != "" && !ruleHasApp(r, s.App) {
+		return false
+	}
+	if len(s.RuleIDs) > 0 && !containsString(s.RuleIDs, r.RuleID) && !containsString(s.RuleIDs, r.Metadata["base-ruleid"]) {
+		return false
+	}
+	if len(s.Networks) > 0 && !s.matchesNetwork(r) {
+		return false
+	}
+	if len(s.DNS) > 0 && !s.matchesDNS(r) {
+		return false
+	}
+	if len(s.Instances) > 0 && !containsString(s.Instances, r.Metadata["instance-name"]) {
+		return false
+	}
+	if len(s.Pools) > 0 && !ruleHasPool(r, s.Pools) {
+		return false
+	}
+	if s.Engine == "" && !s.Failed {
+		return true
+	}
+	for _, rs := range rulesSync {
+		if s.Engine != "" && rs.Engine != s.Engine {
+			continue
+		}
+		if !s.Failed {
+			return true
+		}
+		if latestSync := rs.LatestSync(); latestSync != nil && !latestSync.Successful {
+			return true
+		}
+	}
+	return false
+}
+
+func (s ruleSyncSelector) matchesNetwork(r types.Rule) bool {
+	if r.Destination.ExternalIP == nil {
+		return false
+	}
+	ruleNet, err := parseNetwork(r.Destination.ExternalIP.IP)
+	if err != nil {
+		return false
+	}
+	for _, n := range s.Networks {
+		if networkContains(n, ruleNet) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s ruleSyncSelector) matchesDNS(r types.Rule) bool {
+	if r.Destination.ExternalDNS == nil {
+		return false
+	}
+	name := strings.ToLower(r.Destination.ExternalDNS.Name)
+	for _, pattern := range s.DNS {
+		if matched, _ := path.Match(strings.ToLower(pattern), name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func ruleHasApp(r types.Rule, app string) bool {
+	if r.Metadata["app-name"] == app {
+		return true
+	}
+	for _, rt := range []types.RuleType{r.Source, r.Destination} {
+		if rt.TsuruApp != nil && rt.TsuruApp.AppName == app {
+			return true
+		}
+	}
+	return false
+}
+
+func ruleHasPool(r types.Rule, pools []string) bool {
+	for _, rt := range []types.RuleType{r.Source, r.Destination} {
+		if rt.TsuruApp != nil && rt.TsuruApp.PoolName != "" && containsString(pools, rt.TsuruApp.PoolName) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
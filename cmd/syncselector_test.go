@@ -0,0 +1,86 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"net"
+	"testing"
+
+	"github.com/tsuru/acl-api/api/types"
+)
+
+func mustParseNetwork(t *testing.T, raw string) *net.IPNet {
+	t.Helper()
+	n, err := parseNetwork(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestRuleSyncSelectorMatches(t *testing.T) {
+	appRule := types.Rule{
+		RuleID:      "base1-app1",
+		Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}},
+		Destination: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "API.Example.org"}},
+		Metadata:    map[string]string{"base-ruleid": "base1", "instance-name": "inst1", "app-name": "app1"},
+	}
+	ipRule := types.Rule{
+		RuleID:      "custom1",
+		Source:      types.RuleType{TsuruApp: &types.TsuruAppRule{PoolName: "prod"}},
+		Destination: types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "10.1.2.0/24"}},
+	}
+	appDestRule := types.Rule{
+		RuleID:      "custom2",
+		Source:      types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "192.168.0.1/32"}},
+		Destination: types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app2"}},
+	}
+	succeeded := []types.RuleSyncInfo{{Engine: "acl-operator", Syncs: []types.RuleSyncData{{Successful: true}}}}
+	failed := []types.RuleSyncInfo{
+		{Engine: "acl-operator", Syncs: []types.RuleSyncData{{Successful: true}}},
+		{Engine: "acl-operator-b", Syncs: []types.RuleSyncData{{Successful: true}, {Successful: false}}},
+	}
+	tests := []struct {
+		name      string
+		selector  ruleSyncSelector
+		rule      types.Rule
+		rulesSync []types.RuleSyncInfo
+		want      bool
+	}{
+		{name: "empty selector", rule: ipRule, want: true},
+		{name: "app source by metadata", selector: ruleSyncSelector{App: "app1"}, rule: appRule, want: true},
+		{name: "app destination", selector: ruleSyncSelector{App: "app2"}, rule: appDestRule, want: true},
+		{name: "other app", selector: ruleSyncSelector{App: "app2"}, rule: appRule, want: false},
+		{name: "rule ID", selector: ruleSyncSelector{RuleIDs: []string{"custom1"}}, rule: ipRule, want: true},
+		{name: "base rule ID", selector: ruleSyncSelector{RuleIDs: []string{"base1"}}, rule: appRule, want: true},
+		{name: "other rule ID", selector: ruleSyncSelector{RuleIDs: []string{"custom2"}}, rule: ipRule, want: false},
+		{name: "network containing the rule", selector: ruleSyncSelector{Networks: []*net.IPNet{mustParseNetwork(t, "10.0.0.0/8")}}, rule: ipRule, want: true},
+		{name: "network inside the rule", selector: ruleSyncSelector{Networks: []*net.IPNet{mustParseNetwork(t, "10.1.2.3")}}, rule: ipRule, want: false},
+		{name: "network without IP destination", selector: ruleSyncSelector{Networks: []*net.IPNet{mustParseNetwork(t, "0.0.0.0/0")}}, rule: appRule, want: false},
+		{name: "DNS pattern ignoring case", selector: ruleSyncSelector{DNS: []string{"*.example.ORG"}}, rule: appRule, want: true},
+		{name: "DNS exact", selector: ruleSyncSelector{DNS: []string{"other.example.org", "api.example.org"}}, rule: appRule, want: true},
+		{name: "DNS pattern not matching", selector: ruleSyncSelector{DNS: []string{"*.example.com"}}, rule: appRule, want: false},
+		{name: "DNS without DNS destination", selector: ruleSyncSelector{DNS: []string{"*"}}, rule: ipRule, want: false},
+		{name: "instance", selector: ruleSyncSelector{Instances: []string{"inst2", "inst1"}}, rule: appRule, want: true},
+		{name: "other instance", selector: ruleSyncSelector{Instances: []string{"inst2"}}, rule: appRule, want: false},
+		{name: "pool", selector: ruleSyncSelector{Pools: []string{"prod"}}, rule: ipRule, want: true},
+		{name: "other pool", selector: ruleSyncSelector{Pools: []string{"dev"}}, rule: ipRule, want: false},
+		{name: "engine with syncs", selector: ruleSyncSelector{Engine: "acl-operator"}, rule: ipRule, rulesSync: succeeded, want: true},
+		{name: "engine without syncs", selector: ruleSyncSelector{Engine: "acl-operator-b"}, rule: ipRule, rulesSync: succeeded, want: false},
+		{name: "failed in some engine", selector: ruleSyncSelector{Failed: true}, rule: ipRule, rulesSync: failed, want: true},
+		{name: "failed in the selected engine", selector: ruleSyncSelector{Engine: "acl-operator-b", Failed: true}, rule: ipRule, rulesSync: failed, want: true},
+		{name: "failed in another engine", selector: ruleSyncSelector{Engine: "acl-operator", Failed: true}, rule: ipRule, rulesSync: failed, want: false},
+		{name: "failed without failures", selector: ruleSyncSelector{Failed: true}, rule: ipRule, rulesSync: succeeded, want: false},
+		{name: "every criteria matching", selector: ruleSyncSelector{App: "app1", RuleIDs: []string{"base1"}, DNS: []string{"*.example.org"}, Instances: []string{"inst1"}, Engine: "acl-operator"}, rule: appRule, rulesSync: succeeded, want: true},
+		{name: "one criteria not matching", selector: ruleSyncSelector{App: "app1", Instances: []string{"inst2"}}, rule: appRule, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.selector.Matches(tt.rule, tt.rulesSync); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
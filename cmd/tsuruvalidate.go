@@ -0,0 +1,211 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/acl-api/api/types"
+	"github.com/tsuru/acl-plugin/client"
+)
+
+const maxSuggestions = 3
+
+// tsuruValidator checks that the apps, pools and rpaas instances used by
+// rules exist in the tsuru API, checking each of them once. Names are
+// checked one by one, as the tsuru lists only have what the token is
+// allowed to see, and the lists are only used to suggest close matches.
+type tsuruValidator struct {
+	client    *client.Client
+	checked   map[string]string
+	apps      []string
+	pools     []string
+	instances map[string][]string
+}
+
+func newTsuruValidator(c *client.Client) *tsuruValidator {
+	return &tsuruValidator{client: c, checked: map[string]string{}, instances: map[string][]string{}}
+}
+
+// Validate returns a problem when rt refers to something missing in tsuru,
+// suggesting close matches. The error is returned when tsuru can't be
+// queried.
+func (v *tsuruValidator) Validate(ctx context.Context, rt types.RuleType) (string, error) {
+	switch {
+	case rt.TsuruApp != nil && rt.TsuruApp.AppName != "":
+		name := rt.TsuruApp.AppName
+		return v.check(ctx, "app", name, func() (bool, error) {
+			return v.client.TsuruAppExists(ctx, name)
+		}, v.appNames)
+	case rt.TsuruApp != nil:
+		name := rt.TsuruApp.PoolName
+		return v.check(ctx, "pool", name, func() (bool, error) {
+			return v.client.TsuruPoolExists(ctx, name)
+		}, v.poolNames)
+	case rt.RpaasInstance != nil:
+		service, name := rt.RpaasInstance.ServiceName, rt.RpaasInstance.Instance
+		return v.check(ctx, fmt.Sprintf("%s instance", service), name, func() (bool, error) {
+			return v.client.TsuruServiceInstanceExists(ctx, service, name)
+		}, func(ctx context.Context) ([]string, error) {
+			return v.instanceNames(ctx, service)
+		})
+	}
+	return "", nil
+}
+
+// check returns the problem of a missing kind named name, remembering it
+// for the next destinations. Close matches are suggested from list, on a
+// best effort basis.
+func (v *tsuruValidator) check(ctx context.Context, kind, name string, exists func() (bool, error), list func(context.Context) ([]string, error)) (string, error) {
+	key := kind + "/" + name
+	if problem, ok := v.checked[key]; ok {
+		return problem, nil
+	}
+	found, err := exists()
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to check %s %q", kind, name)
+	}
+	var problem string
+	if !found {
+		candidates, _ := list(ctx)
+		problem = notFoundProblem(kind, name, candidates)
+	}
+	v.checked[key] = problem
+	return problem, nil
+}
+
+func (v *tsuruValidator) appNames(ctx context.Context) ([]string, error) {
+	if v.apps == nil {
+		apps, err := v.client.ListTsuruApps(ctx)
+		if err != nil {
+			return nil, err
+		}
+		v.apps = []string{}
+		for _, a := range apps {
+			v.apps = append(v.apps, a.Name)
+		}
+	}
+	return v.apps, nil
+}
+
+func (v *tsuruValidator) poolNames(ctx context.Context) ([]string, error) {
+	if v.pools == nil {
+		pools, err := v.client.ListTsuruPools(ctx)
+		if err != nil {
+			return nil, err
+		}
+		v.pools = []string{}
+		for _, p := range pools {
+			v.pools = append(v.pools, p.Name)
+		}
+	}
+	return v.pools, nil
+}
+
+func (v *tsuruValidator) instanceNames(ctx context.Context, service string) ([]string, error) {
+	instances, ok := v.instances[service]
+	if !ok {
+		var err error
+		instances, err = v.client.ListTsuruServiceInstances(ctx, service)
+		if err != nil {
+			return nil, err
+		}
+		v.instances[service] = instances
+	}
+	return instances, nil
+}
+
+// ValidateAll validates every destination in rts, returning an error
+// listing every problem.
+func (v *tsuruValidator) ValidateAll(ctx context.Context, rts []types.RuleType) error {
+	var problems []string
+	for _, rt := range rts {
+		problem, err := v.Validate(ctx, rt)
+		if err != nil {
+			return errors.Wrap(err, "unable to validate destinations, use --skip-validation to create rules without validation")
+		}
+		if problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.Errorf("%v\nUse --skip-validation to create rules to destinations not created yet.", problemsError(problems))
+}
+
+func notFoundProblem(kind, name string, existing []string) string {
+	problem := fmt.Sprintf("%s %q not found in tsuru", kind, name)
+	if suggestions := closestMatches(name, existing); len(suggestions) > 0 {
+		problem += fmt.Sprintf(", did you mean %s?", strings.Join(suggestions, " or "))
+	}
+	return problem
+}
+
+// closestMatches returns up to maxSuggestions candidates close enough to
+// name to be a typo, closest first.
+func closestMatches(name string, candidates []string) []string {
+	maxDistance := len(name) / 3
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+	type match struct {
+		name     string
+		distance int
+	}
+	var matches []match
+	for _, c := range candidates {
+		if d := levenshtein(name, c); d <= maxDistance {
+			matches = append(matches, match{name: c, distance: d})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].distance == matches[j].distance {
+			return matches[i].name < matches[j].name
+		}
+		return matches[i].distance < matches[j].distance
+	})
+	var result []string
+	for i := 0; i < len(matches) && i < maxSuggestions; i++ {
+		result = append(result, matches[i].name)
+	}
+	return result
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,91 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/tsuru/acl-api/api/types"
+)
+
+func TestTsuruValidatorValidate(t *testing.T) {
+	srv, c := newTestServer(t)
+	srv.AddTsuruApp("app1", "prod")
+	srv.AddTsuruServiceInstance("rpaasv2-be", "front")
+	srv.Fail(http.MethodGet, "/1.0/apps/hidden", http.StatusForbidden, "forbidden", 0)
+	srv.Fail(http.MethodGet, "/1.0/pools/hidden", http.StatusForbidden, "forbidden", 0)
+	validator := newTsuruValidator(c)
+	tests := []struct {
+		name        string
+		rt          types.RuleType
+		wantProblem string
+	}{
+		{name: "app", rt: types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}}},
+		{name: "app not visible", rt: types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "hidden"}}},
+		{
+			name:        "missing app",
+			rt:          types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app2"}},
+			wantProblem: `app "app2" not found in tsuru, did you mean app1?`,
+		},
+		{
+			name:        "missing app again",
+			rt:          types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app2"}},
+			wantProblem: `app "app2" not found in tsuru, did you mean app1?`,
+		},
+		{name: "pool", rt: types.RuleType{TsuruApp: &types.TsuruAppRule{PoolName: "prod"}}},
+		{name: "pool not visible", rt: types.RuleType{TsuruApp: &types.TsuruAppRule{PoolName: "hidden"}}},
+		{
+			name:        "missing pool",
+			rt:          types.RuleType{TsuruApp: &types.TsuruAppRule{PoolName: "staging"}},
+			wantProblem: `pool "staging" not found in tsuru`,
+		},
+		{name: "rpaas", rt: types.RuleType{RpaasInstance: &types.RpaasInstanceRule{ServiceName: "rpaasv2-be", Instance: "front"}}},
+		{
+			name:        "missing rpaas",
+			rt:          types.RuleType{RpaasInstance: &types.RpaasInstanceRule{ServiceName: "rpaasv2-be", Instance: "frnt"}},
+			wantProblem: `rpaasv2-be instance "frnt" not found in tsuru, did you mean front?`,
+		},
+		{name: "dns", rt: types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "example.org"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problem, err := validator.Validate(context.Background(), tt.rt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if problem != tt.wantProblem {
+				t.Errorf("got problem %q, want %q", problem, tt.wantProblem)
+			}
+		})
+	}
+	wantCalls := []string{
+		"GET /1.0/apps/app1",
+		"GET /1.0/apps/hidden",
+		"GET /1.0/apps/app2",
+		"GET /1.0/apps",
+		"GET /1.0/pools/prod",
+		"GET /1.0/pools/hidden",
+		"GET /1.0/pools/staging",
+		"GET /1.0/pools",
+		"GET /1.0/services/rpaasv2-be/instances/front",
+		"GET /1.0/services/rpaasv2-be/instances/frnt",
+		"GET /1.0/services/instances",
+	}
+	if calls := srv.Calls(); strings.Join(calls, "\n") != strings.Join(wantCalls, "\n") {
+		t.Errorf("got calls:\n%s\nwant:\n%s", strings.Join(calls, "\n"), strings.Join(wantCalls, "\n"))
+	}
+}
+
+func TestTsuruValidatorValidateError(t *testing.T) {
+	srv, c := newTestServer(t)
+	srv.Fail(http.MethodGet, "/1.0/apps/*", http.StatusInternalServerError, "boom", 0)
+	_, err := newTsuruValidator(c).Validate(context.Background(), types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "app1"}})
+	if err == nil || !strings.Contains(err.Error(), `unable to check app "app1"`) {
+		t.Errorf("got error %v, want it to report the app check", err)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tsuru/acl-api/api/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const dnsCheckTimeout = 5 * time.Second
+
+var (
+	// tsuruAppNameRegexp matches tsuru app and job names.
+	tsuruAppNameRegexp  = regexp.MustCompile(`^[a-z][a-z0-9-]{0,39}$`)
+	tsuruPoolNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+	rpaasInstanceRegexp = regexp.MustCompile(`^[a-z][a-z0-9-]{0,29}$`)
+)
+
+// validationError lists every problem found validating rules.
+type validationError struct {
+	Problems []string
+}
+
+func (e *validationError) Error() string {
+	if len(e.Problems) == 1 {
+		return e.Problems[0]
+	}
+	return fmt.Sprintf("%d problems found:\n  %s", len(e.Problems), strings.Join(e.Problems, "\n  "))
+}
+
+// problemsError returns a *validationError with problems, or nil without
+// problems.
+func problemsError(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return &validationError{Problems: problems}
+}
+
+func printWarnings(warnings []string) {
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+	}
+}
+
+// validateRuleType checks the syntax of rt, canonicalizing IP networks, DNS
+// names and ports in place.
+func validateRuleType(rt *types.RuleType) (warnings, problems []string) {
+	count := 0
+	for _, set := range []bool{rt.TsuruApp != nil, rt.TsuruJob != nil, rt.KubernetesService != nil, rt.ExternalDNS != nil, rt.ExternalIP != nil, rt.RpaasInstance != nil} {
+		if set {
+			count++
+		}
+	}
+	if count != 1 {
+		return nil, []string{"exactly one rule type must be set"}
+	}
+	switch {
+	case rt.ExternalIP != nil:
+		ip, n, err := net.ParseCIDR(rt.ExternalIP.IP)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("IP %q: must be a network, e.g. 10.0.0.0/24", rt.ExternalIP.IP))
+			break
+		}
+		if !ip.Equal(n.IP) {
+			warnings = append(warnings, fmt.Sprintf("IP %s has host bits set, using %s", rt.ExternalIP.IP, n.String()))
+		}
+		rt.ExternalIP.IP = n.String()
+		var portProblems []string
+		rt.ExternalIP.Ports, portProblems = validatePorts(rt.ExternalIP.Ports, "IP "+rt.ExternalIP.IP)
+		problems = append(problems, portProblems...)
+	case rt.ExternalDNS != nil:
+		name := strings.ToLower(strings.TrimSuffix(rt.ExternalDNS.Name, "."))
+		if problem := validateHostname(name); problem != "" {
+			problems = append(problems, fmt.Sprintf("DNS %q: %s", rt.ExternalDNS.Name, problem))
+		}
+		rt.ExternalDNS.Name = name
+		var portProblems []string
+		rt.ExternalDNS.Ports, portProblems = validatePorts(rt.ExternalDNS.Ports, "DNS "+name)
+		problems = append(problems, portProblems...)
+	case rt.TsuruApp != nil && rt.TsuruApp.AppName != "":
+		if !tsuruAppNameRegexp.MatchString(rt.TsuruApp.AppName) {
+			problems = append(problems, fmt.Sprintf("app %q: must start with a lowercase letter and have up to 40 lowercase letters, numbers or dashes", rt.TsuruApp.AppName))
+		}
+	case rt.TsuruApp != nil:
+		if !tsuruPoolNameRegexp.MatchString(rt.TsuruApp.PoolName) {
+			problems = append(problems, fmt.Sprintf("pool %q: must start with a lowercase letter or number and have up to 63 lowercase letters, numbers, dashes or underscores", rt.TsuruApp.PoolName))
+		}
+	case rt.TsuruJob != nil:
+		if !tsuruAppNameRegexp.MatchString(rt.TsuruJob.JobName) {
+			problems = append(problems, fmt.Sprintf("job %q: must start with a lowercase letter and have up to 40 lowercase letters, numbers or dashes", rt.TsuruJob.JobName))
+		}
+	case rt.KubernetesService != nil:
+		for _, problem := range []string{
+			validateDNSLabel(rt.KubernetesService.Namespace, "service namespace"),
+			validateDNSLabel(rt.KubernetesService.ServiceName, "service name"),
+		} {
+			if problem != "" {
+				problems = append(problems, problem)
+			}
+		}
+	case rt.RpaasInstance != nil:
+		if !tsuruAppNameRegexp.MatchString(rt.RpaasInstance.ServiceName) {
+			problems = append(problems, fmt.Sprintf("rpaas service %q: must start with a lowercase letter and have only lowercase letters, numbers or dashes", rt.RpaasInstance.ServiceName))
+		}
+		if !rpaasInstanceRegexp.MatchString(rt.RpaasInstance.Instance) {
+			problems = append(problems, fmt.Sprintf("rpaas instance %q: must start with a lowercase letter and have up to 30 lowercase letters, numbers or dashes", rt.RpaasInstance.Instance))
+		}
+	}
+	// The ACL API refuses more than the checks above, e.g. IPv6 networks,
+	// large networks without ports and Kubernetes services.
+	if len(problems) == 0 {
+		if err := rt.Validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", rt.String(), err))
+		}
+	}
+	return warnings, problems
+}
+
+// validateHostname checks name is an RFC 1123 hostname, optionally with a
+// leading dot matching its subdomains too, e.g. ".example.org", the wildcard
+// form accepted by the ACL API.
+func validateHostname(name string) string {
+	if _, err := parseNetwork(name); err == nil {
+		return "it's an IP, use --ip " + name
+	}
+	if strings.Contains(name, "*") {
+		return "wildcards must be written with a leading dot, e.g. .example.org"
+	}
+	name = strings.TrimPrefix(name, ".")
+	errs := validation.IsDNS1123Subdomain(name)
+	// IsDNS1123Subdomain only limits the whole name, not each label.
+	for _, label := range strings.Split(name, ".") {
+		if len(label) > validation.DNS1123LabelMaxLength {
+			errs = append(errs, label)
+		}
+	}
+	if len(errs) > 0 {
+		return "must be an RFC 1123 hostname, with labels of letters, numbers or dashes separated by dots, e.g. api.example.org"
+	}
+	return ""
+}
+
+// validateDNSLabel checks value is a DNS-1123 label, as Kubernetes names.
+func validateDNSLabel(value, what string) string {
+	if len(validation.IsDNS1123Label(value)) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s %q: must have up to 63 lowercase letters, numbers or dashes, starting and ending with a letter or number", what, value)
+}
+
+// validatePorts checks protocols and port numbers, removing repeated ports.
+func validatePorts(ports types.ProtoPorts, dst string) (types.ProtoPorts, []string) {
+	var result types.ProtoPorts
+	var problems []string
+	for _, p := range ports {
+		p.Protocol = strings.ToLower(p.Protocol)
+		switch p.Protocol {
+		case "tcp", "udp":
+		default:
+			problems = append(problems, fmt.Sprintf("%s: protocol %q is not supported, the ACL API only allows tcp or udp", dst, p.Protocol))
+			continue
+		}
+		if p.Port == 0 {
+			problems = append(problems, fmt.Sprintf("%s: port 0 is not allowed", dst))
+			continue
+		}
+		if !containsPort(result, p) {
+			result = append(result, p)
+		}
+	}
+	return result, problems
+}
+
+// checkDNSResolution warns about DNS destinations not resolving with
+// resolver. Wildcard names, with a leading dot, can't be resolved and are
+// not checked.
+func checkDNSResolution(ctx context.Context, resolver dnsResolver, rts []types.RuleType) []string {
+	ctx, cancel := context.WithTimeout(ctx, dnsCheckTimeout)
+	defer cancel()
+	var warnings []string
+	for _, rt := range rts {
+		if rt.ExternalDNS == nil || strings.HasPrefix(rt.ExternalDNS.Name, ".") {
+			continue
+		}
+		if _, err := resolver.LookupHost(ctx, rt.ExternalDNS.Name); err != nil {
+			warnings = append(warnings, fmt.Sprintf("DNS %s doesn't resolve: %v", rt.ExternalDNS.Name, err))
+		}
+	}
+	return warnings
+}
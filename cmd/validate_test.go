@@ -0,0 +1,220 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tsuru/acl-api/api/types"
+)
+
+func TestValidateRuleType(t *testing.T) {
+	tests := []struct {
+		name         string
+		rt           types.RuleType
+		want         string
+		wantWarnings []string
+		wantProblems []string
+	}{
+		{
+			name:         "no destination",
+			rt:           types.RuleType{},
+			wantProblems: []string{"exactly one rule type must be set"},
+		},
+		{
+			name: "two destinations",
+			rt: types.RuleType{
+				ExternalIP:  &types.ExternalIPRule{IP: "10.0.0.1/32"},
+				ExternalDNS: &types.ExternalDNSRule{Name: "example.org"},
+			},
+			wantProblems: []string{"exactly one rule type must be set"},
+		},
+		{
+			name: "ip network",
+			rt:   types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "10.0.0.0/24", Ports: types.ProtoPorts{{Protocol: "tcp", Port: 443}}}},
+			want: "IP: 10.0.0.0/24, Ports: tcp:443",
+		},
+		{
+			name:         "ip with host bits",
+			rt:           types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "10.0.0.5/24", Ports: types.ProtoPorts{{Protocol: "tcp", Port: 443}}}},
+			want:         "IP: 10.0.0.0/24, Ports: tcp:443",
+			wantWarnings: []string{"IP 10.0.0.5/24 has host bits set, using 10.0.0.0/24"},
+		},
+		{
+			name:         "ip without mask",
+			rt:           types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "10.0.0.1"}},
+			want:         "IP: 10.0.0.1",
+			wantProblems: []string{`IP "10.0.0.1": must be a network, e.g. 10.0.0.0/24`},
+		},
+		{
+			name: "ip with invalid and repeated ports",
+			rt: types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "10.0.0.1/32", Ports: types.ProtoPorts{
+				{Protocol: "TCP", Port: 443},
+				{Protocol: "tcp", Port: 443},
+				{Protocol: "sctp", Port: 9899},
+				{Protocol: "udp", Port: 0},
+			}}},
+			want: "IP: 10.0.0.1/32, Ports: tcp:443",
+			wantProblems: []string{
+				`IP 10.0.0.1/32: protocol "sctp" is not supported, the ACL API only allows tcp or udp`,
+				"IP 10.0.0.1/32: port 0 is not allowed",
+			},
+		},
+		{
+			name: "dns canonicalized",
+			rt:   types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "API.Example.org.", Ports: types.ProtoPorts{{Protocol: "UDP", Port: 53}}}},
+			want: "DNS: api.example.org, Ports: udp:53",
+		},
+		{
+			name:         "dns with an ip",
+			rt:           types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "10.0.0.1"}},
+			want:         "DNS: 10.0.0.1",
+			wantProblems: []string{`DNS "10.0.0.1": it's an IP, use --ip 10.0.0.1`},
+		},
+		{
+			name: "ipv6 network",
+			rt:   types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "2001:db8::1/128", Ports: types.ProtoPorts{{Protocol: "tcp", Port: 443}}}},
+			want: "IP: 2001:db8::1/128, Ports: tcp:443",
+			wantProblems: []string{
+				"IP: 2001:db8::1/128, Ports: tcp:443: IP Rule: Invalid IP, IPv6 is not supported yet",
+			},
+		},
+		{
+			name:         "large network without ports",
+			rt:           types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "10.0.0.0/16"}},
+			want:         "IP: 10.0.0.0/16",
+			wantProblems: []string{"IP: 10.0.0.0/16: IP Rule: Large CIDR, the maximum size of network without ports is /22"},
+		},
+		{
+			name: "large network with ports",
+			rt:   types.RuleType{ExternalIP: &types.ExternalIPRule{IP: "10.0.0.0/16", Ports: types.ProtoPorts{{Protocol: "tcp", Port: 443}}}},
+			want: "IP: 10.0.0.0/16, Ports: tcp:443",
+		},
+		{
+			name: "wildcard dns",
+			rt:   types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: ".Example.org", Ports: types.ProtoPorts{{Protocol: "tcp", Port: 443}}}},
+			want: "DNS: .example.org, Ports: tcp:443",
+		},
+		{
+			name:         "cluster internal dns",
+			rt:           types.RuleType{ExternalDNS: &types.ExternalDNSRule{Name: "redis.default.svc.cluster.local"}},
+			want:         "DNS: redis.default.svc.cluster.local",
+			wantProblems: []string{"DNS: redis.default.svc.cluster.local: DNS Rule: Name must not be a cluster internal address"},
+		},
+		{
+			name: "app",
+			rt:   types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "my-app"}},
+			want: "Tsuru APP: my-app",
+		},
+		{
+			name:         "invalid app",
+			rt:           types.RuleType{TsuruApp: &types.TsuruAppRule{AppName: "My_App"}},
+			want:         "Tsuru APP: My_App",
+			wantProblems: []string{`app "My_App": must start with a lowercase letter and have up to 40 lowercase letters, numbers or dashes`},
+		},
+		{
+			name: "pool",
+			rt:   types.RuleType{TsuruApp: &types.TsuruAppRule{PoolName: "dev_1"}},
+			want: "Tsuru Pool: dev_1",
+		},
+		{
+			name:         "invalid pool",
+			rt:           types.RuleType{TsuruApp: &types.TsuruAppRule{PoolName: "-dev"}},
+			want:         "Tsuru Pool: -dev",
+			wantProblems: []string{`pool "-dev": must start with a lowercase letter or number and have up to 63 lowercase letters, numbers, dashes or underscores`},
+		},
+		{
+			name:         "invalid job",
+			rt:           types.RuleType{TsuruJob: &types.TsuruJobRule{JobName: "1job"}},
+			want:         "Tsuru Job: 1job",
+			wantProblems: []string{`job "1job": must start with a lowercase letter and have up to 40 lowercase letters, numbers or dashes`},
+		},
+		{
+			name: "invalid kubernetes service",
+			rt:   types.RuleType{KubernetesService: &types.KubernetesServiceRule{Namespace: "Default", ServiceName: "redis_1"}},
+			want: "Kubernetes Service: Default/redis_1",
+			wantProblems: []string{
+				`service namespace "Default": must have up to 63 lowercase letters, numbers or dashes, starting and ending with a letter or number`,
+				`service name "redis_1": must have up to 63 lowercase letters, numbers or dashes, starting and ending with a letter or number`,
+			},
+		},
+		{
+			name:         "kubernetes service",
+			rt:           types.RuleType{KubernetesService: &types.KubernetesServiceRule{Namespace: "default", ServiceName: "redis"}},
+			want:         "Kubernetes Service: default/redis",
+			wantProblems: []string{"Kubernetes Service: default/redis: Kubernetes Service Rule: has been deactivated for use, please use instead: App or RPaaS destinations"},
+		},
+		{
+			name: "rpaas",
+			rt:   types.RuleType{RpaasInstance: &types.RpaasInstanceRule{ServiceName: "rpaasv2-be", Instance: "front"}},
+			want: "Rpaas: rpaasv2-be/front",
+		},
+		{
+			name:         "invalid rpaas instance",
+			rt:           types.RuleType{RpaasInstance: &types.RpaasInstanceRule{ServiceName: "rpaasv2-be", Instance: "Front"}},
+			want:         "Rpaas: rpaasv2-be/Front",
+			wantProblems: []string{`rpaas instance "Front": must start with a lowercase letter and have up to 30 lowercase letters, numbers or dashes`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings, problems := validateRuleType(&tt.rt)
+			if !reflect.DeepEqual(warnings, tt.wantWarnings) {
+				t.Errorf("got warnings %q, want %q", warnings, tt.wantWarnings)
+			}
+			if !reflect.DeepEqual(problems, tt.wantProblems) {
+				t.Errorf("got problems %q, want %q", problems, tt.wantProblems)
+			}
+			if tt.want != "" {
+				if got := tt.rt.String(); got != tt.want {
+					t.Errorf("got destination %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateHostname(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr string
+	}{
+		{name: "example.org"},
+		{name: "api.example-1.org"},
+		{name: "localhost"},
+		{name: ".example.org"},
+		{name: "*.example.org", wantErr: "wildcards must be written with a leading dot, e.g. .example.org"},
+		{name: "10.0.0.1", wantErr: "it's an IP, use --ip 10.0.0.1"},
+		{name: "10.0.0.0/24", wantErr: "it's an IP, use --ip 10.0.0.0/24"},
+		{name: "2001:db8::1", wantErr: "it's an IP"},
+		{name: "api.*.example.org", wantErr: "wildcards must be written with a leading dot"},
+		{name: "*", wantErr: "wildcards must be written with a leading dot"},
+		{name: "..example.org", wantErr: "must be an RFC 1123 hostname"},
+		{name: "-api.example.org", wantErr: "must be an RFC 1123 hostname"},
+		{name: "api_1.example.org", wantErr: "must be an RFC 1123 hostname"},
+		{name: "Example.org", wantErr: "must be an RFC 1123 hostname"},
+		{name: "api..example.org", wantErr: "must be an RFC 1123 hostname"},
+		{name: strings.Repeat("a", 63) + ".example.org"},
+		{name: strings.Repeat("a", 64) + ".example.org", wantErr: "must be an RFC 1123 hostname"},
+		{name: strings.Repeat("a.", 127) + "org", wantErr: "must be an RFC 1123 hostname"},
+		{name: "", wantErr: "must be an RFC 1123 hostname"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := validateHostname(tt.name)
+			if tt.wantErr == "" {
+				if got != "" {
+					t.Errorf("got %q, want no problem", got)
+				}
+				return
+			}
+			if !strings.Contains(got, tt.wantErr) {
+				t.Errorf("got %q, want it to contain %q", got, tt.wantErr)
+			}
+		})
+	}
+}
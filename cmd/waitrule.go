@@ -0,0 +1,140 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/tsuru/acl-api/api/types"
+)
+
+var WaitRulesCmd = &cobra.Command{
+	Use:   "wait [service name] [instance name]",
+	Short: "Wait until rules reach a status",
+	Long: `Wait until every expanded rule of an instance reaches one of the statuses
+set by --for, exiting with an error if they don't before --timeout.
+
+Failing rules are retried by the periodic sync of the engines, so they are
+waited for as well.`,
+	Example: `
+# Wait for a single rule to be synced in every engine
+tsuru acl rules wait <ACL SERVICE> --rule <RULE ID> --timeout 5m
+
+# Wait for every rule labelled team=payments to be synced or stale
+tsuru acl rules wait <ACL SERVICE> -l team=payments --for synced,stale
+	`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		selector, err := parseLabelSelector(cmd.Flags())
+		if err != nil {
+			return err
+		}
+		statusOpts, err := parseStatusOptions(cmd.Flags())
+		if err != nil {
+			return err
+		}
+		rawFor, _ := cmd.Flags().GetStringSlice("for")
+		expected, err := parseStatuses(rawFor, "for")
+		if err != nil {
+			return err
+		}
+		if len(expected) == 0 {
+			return errors.New("at least one status must be set in --for")
+		}
+		baseRuleIDs, _ := cmd.Flags().GetStringSlice("rule")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		serviceName, instanceName := serviceInstanceName(args, 1)
+		c := newClient(serviceName)
+
+		ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+		defer cancel()
+		var lastProgress string
+		for {
+			ruleData, err := c.ListRules(ctx, instanceName)
+			if err != nil {
+				return err
+			}
+			_, rules, rulesSync := filterServiceRules([]types.ServiceInstance{ruleData.ServiceInstance}, ruleData.ExpandedRules, ruleData.RulesSync, selector)
+			rules = filterBaseRuleIDs(rules, baseRuleIDs)
+			statuses := ruleStatuses(rules, rulesSync, statusOpts.StaleAfter)
+			done, total, progress := waitProgress(rules, statuses, expected)
+			if total == 0 {
+				return errors.Errorf("no rules of instance %q match the selection, nothing to wait for", instanceName)
+			}
+			if progress != lastProgress {
+				fmt.Println(progress)
+				lastProgress = progress
+			}
+			if done {
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				err = checkExpectedStatus(rules, rulesSync, statusOptions{StaleAfter: statusOpts.StaleAfter, Expect: expected})
+				return errors.Wrap(err, "gave up waiting")
+			case <-time.After(syncPollInterval):
+			}
+		}
+	},
+}
+
+// filterBaseRuleIDs keeps only rules expanded from one of baseRuleIDs. An
+// empty baseRuleIDs keeps every rule.
+func filterBaseRuleIDs(rules []types.Rule, baseRuleIDs []string) []types.Rule {
+	if len(baseRuleIDs) == 0 {
+		return rules
+	}
+	wanted := map[string]bool{}
+	for _, id := range baseRuleIDs {
+		wanted[id] = true
+	}
+	var filtered []types.Rule
+	for _, r := range rules {
+		if wanted[r.Metadata["base-ruleid"]] {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered
+}
+
+// waitProgress reports whether every rule not removed is in one of the
+// expected statuses and how many rules are not removed, along with a
+// summary line, e.g. "2/3 rules synced (1 pending)".
+func waitProgress(rules []types.Rule, statuses map[string]ruleSyncStatus, expected []ruleStatus) (bool, int, string) {
+	total, ready := 0, 0
+	others := map[ruleStatus]int{}
+	for _, r := range rules {
+		if r.Removed {
+			continue
+		}
+		total++
+		status := statuses[r.RuleID].Status
+		if containsStatus(expected, status) {
+			ready++
+		} else {
+			others[status]++
+		}
+	}
+	expectedNames := make([]string, len(expected))
+	for i, s := range expected {
+		expectedNames[i] = string(s)
+	}
+	progress := fmt.Sprintf("%d/%d rules %s", ready, total, strings.Join(expectedNames, " or "))
+	if len(others) > 0 {
+		var counts []string
+		for status, count := range others {
+			counts = append(counts, fmt.Sprintf("%d %s", count, status))
+		}
+		sort.Strings(counts)
+		progress += " (" + strings.Join(counts, ", ") + ")"
+	}
+	return ready == total, total, progress
+}
@@ -0,0 +1,62 @@
+// Copyright 2023 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/tsuru/acl-api/api/types"
+)
+
+func TestWaitProgress(t *testing.T) {
+	statuses := map[string]ruleSyncStatus{
+		"r1": {Status: statusSynced},
+		"r2": {Status: statusPending},
+		"r3": {Status: statusFailing},
+	}
+	tests := []struct {
+		name         string
+		rules        []types.Rule
+		wantDone     bool
+		wantTotal    int
+		wantProgress string
+	}{
+		{
+			name:         "no rules",
+			wantDone:     true,
+			wantTotal:    0,
+			wantProgress: "0/0 rules synced",
+		},
+		{
+			name:         "only removed rules",
+			rules:        []types.Rule{{RuleID: "r2", Removed: true}},
+			wantDone:     true,
+			wantTotal:    0,
+			wantProgress: "0/0 rules synced",
+		},
+		{
+			name:         "every rule synced",
+			rules:        []types.Rule{{RuleID: "r1"}, {RuleID: "r2", Removed: true}},
+			wantDone:     true,
+			wantTotal:    1,
+			wantProgress: "1/1 rules synced",
+		},
+		{
+			name:         "some rules not synced",
+			rules:        []types.Rule{{RuleID: "r1"}, {RuleID: "r2"}, {RuleID: "r3"}},
+			wantDone:     false,
+			wantTotal:    3,
+			wantProgress: "1/3 rules synced (1 failing, 1 pending)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done, total, progress := waitProgress(tt.rules, statuses, []ruleStatus{statusSynced})
+			if done != tt.wantDone || total != tt.wantTotal || progress != tt.wantProgress {
+				t.Errorf("got (%v, %d, %q), want (%v, %d, %q)", done, total, progress, tt.wantDone, tt.wantTotal, tt.wantProgress)
+			}
+		})
+	}
+}
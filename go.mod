@@ -10,6 +10,9 @@ require (
 	github.com/spf13/viper v1.16.0
 	github.com/tsuru/acl-api v0.1.5-0.20230920203734-6133efd4b663
 	github.com/tsuru/tablecli v0.0.0-20190131152944-7ded8a3383c6
+	golang.org/x/term v0.12.0
+	gopkg.in/yaml.v3 v3.0.1
+	k8s.io/apimachinery v0.28.2
 )
 
 require (
@@ -25,11 +28,8 @@ require (
 	github.com/subosito/gotenv v1.6.0 // indirect
 	golang.org/x/crypto v0.13.0 // indirect
 	golang.org/x/sys v0.12.0 // indirect
-	golang.org/x/term v0.12.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
-	k8s.io/apimachinery v0.28.2 // indirect
 	k8s.io/utils v0.0.0-20230726121419-3b25d923346b // indirect
 )
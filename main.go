@@ -5,14 +5,20 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
-	"net"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"github.com/tsuru/acl-api/api/version"
+	"github.com/tsuru/acl-plugin/client"
 	"github.com/tsuru/acl-plugin/cmd"
 )
 
@@ -33,10 +39,37 @@ func initConfig(rootCmd *cobra.Command) func() {
 	}
 }
 
+// signalContext returns a context cancelled on the first SIGINT or SIGTERM,
+// letting commands stop sending new requests and finish the ones in flight.
+// A second signal exits immediately.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigs:
+		case <-ctx.Done():
+			return
+		}
+		fmt.Fprintln(os.Stderr, "\nInterrupted, waiting for in-flight requests to finish. Press Ctrl-C again to force exit.")
+		cancel()
+		<-sigs
+		os.Exit(130)
+	}()
+	return ctx, func() {
+		signal.Stop(sigs)
+		cancel()
+	}
+}
+
 func main() {
 	rootCmd := &cobra.Command{
+		Use:     "acl",
 		Version: version.Version,
 	}
+	rootCmd.CompletionOptions.DisableDefaultCmd = true
+	rootCmd.AddCommand(cmd.CompletionCmd)
 	cobra.OnInitialize(initConfig(rootCmd))
 	rulesCmd := &cobra.Command{
 		Use: "rules",
@@ -44,9 +77,15 @@ func main() {
 	rootCmd.AddCommand(rulesCmd)
 	rulesCmd.AddCommand(cmd.AddRuleCmd)
 	rulesCmd.AddCommand(cmd.RemoveRuleCmd)
+	rulesCmd.AddCommand(cmd.EditRuleCmd)
+	rulesCmd.AddCommand(cmd.ShowRuleCmd)
 	rulesCmd.AddCommand(cmd.ListRuleCmd)
 	rulesCmd.AddCommand(cmd.ForceSyncCmd)
 	rulesCmd.AddCommand(cmd.SyncDNSCmd)
+	rulesCmd.AddCommand(cmd.ExpireRulesCmd)
+	rulesCmd.AddCommand(cmd.WaitRulesCmd)
+	rulesCmd.AddCommand(cmd.RenderRuleCmd)
+	rulesCmd.AddCommand(cmd.ImportRulesCmd)
 
 	adminCmd := &cobra.Command{
 		Use: "admin",
@@ -54,36 +93,172 @@ func main() {
 	rootCmd.AddCommand(adminCmd)
 	adminCmd.AddCommand(cmd.ListAllRulesCmd)
 	adminCmd.AddCommand(cmd.AddCustomRuleCmd)
+	adminCmd.AddCommand(cmd.AdminExpireRulesCmd)
+	adminCmd.AddCommand(cmd.AdminShowRuleCmd)
+	adminCmd.AddCommand(cmd.AdminRemoveRuleCmd)
+	adminCmd.AddCommand(cmd.AdminSetOwnerCmd)
+	adminCmd.AddCommand(cmd.AdminSetMetadataCmd)
+	adminCmd.AddCommand(cmd.AdminResyncFailedCmd)
 
 	rootCmd.PersistentFlags().String("tsuru.target", "", "Tsuru Target URL")
 	rootCmd.PersistentFlags().String("tsuru.token", "", "Tsuru Token")
 
 	dstFlags := pflag.NewFlagSet("", pflag.ExitOnError)
-	dstFlags.IPNet("ip", net.IPNet{}, "Destination IP Network [10.0.0.1/32]")
-	dstFlags.String("dns", "", "Destination DNS name [example.org]")
+	dstFlags.StringSlice("ip", nil, "Destination IP or IP Network, may have a port [10.0.0.1, 10.0.0.0/24, 10.0.0.1:443]")
+	dstFlags.StringSlice("dns", nil, "Destination DNS name, may have a port, a leading dot matches its subdomains too [example.org, example.org:443, .example.org]")
+	dstFlags.StringSlice("url", nil, "Destination host and port of a URL [https://example.org:8443/path]")
 	dstFlags.String("app", "", "Destination Tsuru App Name [myapp]")
 	dstFlags.String("app-pool", "", "Destination Tsuru Pool Name [dev]")
 	dstFlags.String("rpaas", "", "Destination RPAAS ServiceName/Instance [rpaasv2-be/myrpaas]")
 	dstFlags.String("service", "", "Destination Kubernetes Service [namespace/service]")
-	dstFlags.StringSlice("port", nil, "Destination Ports [tcp:443]")
+	dstFlags.StringSlice("port", nil, "Destination Ports, as numbers, ranges of up to 1000 ports or service names [tcp:443, tcp:8000-8100, tcp:https]")
+
+	metadataFlags := pflag.NewFlagSet("", pflag.ExitOnError)
+	metadataFlags.String("name", "", "Rule name [partner-api]")
+	metadataFlags.String("description", "", "Rule description")
+	metadataFlags.StringArray("label", nil, "Rule label, may be repeated [team=payments]")
+	metadataFlags.String("ticket", "", "Ticket requesting the rule [INC-1234]")
+	metadataFlags.String("reason", "", "Reason for the rule")
+	metadataFlags.Duration("expires-in", 0, "Remove the rule with \"rules expire\" after this duration [72h]")
+	metadataFlags.String("expires-at", "", "Remove the rule with \"rules expire\" after this time [2023-10-01T15:00:00Z]")
+
+	selectorFlags := pflag.NewFlagSet("", pflag.ExitOnError)
+	selectorFlags.StringSliceP("selector", "l", nil, "Select rules by label [team=payments]")
+
+	statusFlags := pflag.NewFlagSet("", pflag.ExitOnError)
+	statusFlags.Duration("stale-after", 24*time.Hour, "Consider synced rules stale when an engine did not sync them for longer than this")
+
+	statusFilterFlags := pflag.NewFlagSet("", pflag.ExitOnError)
+	statusFilterFlags.AddFlagSet(statusFlags)
+	statusFilterFlags.StringSlice("status", nil, "Only show rules in these statuses: pending, synced, partial, failing, stale")
+	statusFilterFlags.StringSlice("expect", nil, "Exit with an error if a rule is not in one of these statuses [synced]")
+
+	resolveFlags := pflag.NewFlagSet("", pflag.ExitOnError)
+	resolveFlags.Bool("resolve", false, "Show the addresses DNS destinations resolve to and when each engine last synced them")
+	resolveFlags.String("dns-server", "", "DNS server used by --resolve instead of the system resolver [8.8.8.8:53]")
+
+	labelConventionFlags := pflag.NewFlagSet("", pflag.ExitOnError)
+	labelConventionFlags.String("app-label", "tsuru.io/app-name", "Label with the name of the app in app pods")
+	labelConventionFlags.String("pool-label", "tsuru.io/app-pool", "Label with the name of the pool in app pods")
+	labelConventionFlags.String("job-label", "tsuru.io/job-name", "Label with the name of the job in job pods")
+	labelConventionFlags.String("service-label", "app.kubernetes.io/name", "Label selecting the pods of Kubernetes service destinations")
+
+	validationFlags := pflag.NewFlagSet("", pflag.ExitOnError)
+	validationFlags.Bool("check-dns", false, "Warn about DNS destinations that don't resolve")
+	validationFlags.Bool("skip-validation", false, "Don't check that apps, pools and rpaas instances exist in tsuru, e.g. for apps created later")
 
 	adminFlags := pflag.NewFlagSet("", pflag.ExitOnError)
 	adminFlags.AddFlagSet(dstFlags)
+	adminFlags.AddFlagSet(validationFlags)
+	adminFlags.AddFlagSet(metadataFlags)
 
 	adminFlags.String("src-app", "", "Source Tsuru App Name [myapp]")
 	adminFlags.String("src-app-pool", "", "Source Tsuru Pool Name [dev]")
+	adminFlags.String("src-job", "", "Source Tsuru Job Name [myjob]")
+	adminFlags.String("src-rpaas", "", "Source RPAAS ServiceName/Instance [rpaasv2-be/myrpaas]")
+	adminFlags.String("src-service", "", "Source Kubernetes Service [namespace/service]")
+	adminFlags.String("src-ip", "", "Source IP or IP Network [10.0.0.1, 10.0.0.0/24]")
 	adminFlags.String("owner", "", "Rule owner")
 
 	cmd.AddRuleCmd.Flags().AddFlagSet(dstFlags)
+	cmd.AddRuleCmd.Flags().AddFlagSet(metadataFlags)
+	cmd.AddRuleCmd.Flags().AddFlagSet(validationFlags)
+	cmd.RemoveRuleCmd.Flags().AddFlagSet(selectorFlags)
+	cmd.RemoveRuleCmd.Flags().Bool("dry-run", false, "Only list the rules matching --selector, without removing them")
+	cmd.RemoveRuleCmd.Flags().BoolP("yes", "y", false, "Remove the rules matching --selector without asking for confirmation")
+	cmd.ListRuleCmd.Flags().AddFlagSet(selectorFlags)
+	cmd.ListAllRulesCmd.Flags().AddFlagSet(selectorFlags)
 	cmd.AddCustomRuleCmd.Flags().AddFlagSet(adminFlags)
+	cmd.ListRuleCmd.Flags().AddFlagSet(statusFilterFlags)
+	cmd.ListAllRulesCmd.Flags().AddFlagSet(statusFilterFlags)
+	cmd.ShowRuleCmd.Flags().AddFlagSet(statusFilterFlags)
+	cmd.ListRuleCmd.Flags().AddFlagSet(resolveFlags)
+	cmd.ShowRuleCmd.Flags().AddFlagSet(resolveFlags)
+	cmd.WaitRulesCmd.Flags().AddFlagSet(selectorFlags)
+	cmd.WaitRulesCmd.Flags().AddFlagSet(statusFlags)
+
+	cmd.EditRuleCmd.Flags().StringSlice("port", nil, "Replace every destination port [tcp:443]")
+	cmd.EditRuleCmd.Flags().StringSlice("add-port", nil, "Add destination ports [tcp:8443]")
+	cmd.EditRuleCmd.Flags().StringSlice("remove-port", nil, "Remove destination ports [tcp:443]")
+	cmd.EditRuleCmd.Flags().Duration("timeout", 2*time.Minute, "How long to wait for the new rule to be synced before rolling back")
+
+	cmd.ExpireRulesCmd.Flags().Bool("dry-run", false, "Only list expired rules, without removing them")
+	cmd.AdminExpireRulesCmd.Flags().Bool("dry-run", false, "Only list expired rules, without removing them")
+
+	cmd.AddCustomRuleCmd.Flags().Bool("json", false, "Return the created rule as JSON instead of the formatted output")
+	cmd.AdminShowRuleCmd.Flags().Bool("json", false, "Return the raw JSON output instead of the formatted output")
+	cmd.AdminRemoveRuleCmd.Flags().String("service", client.DefaultServiceName, "ACL service name")
+	cmd.AdminSetMetadataCmd.Flags().StringSlice("unset", nil, "Metadata keys to remove")
+	cmd.AdminSetOwnerCmd.Flags().Duration("timeout", 2*time.Minute, "How long to wait for the new rule to be synced before rolling back")
+	cmd.AdminSetMetadataCmd.Flags().Duration("timeout", 2*time.Minute, "How long to wait for the new rule to be synced before rolling back")
+
+	cmd.ForceSyncCmd.Flags().String("service", client.DefaultServiceName, "ACL service name")
+	cmd.ForceSyncCmd.Flags().StringSlice("rule-id", nil, "Select rules by ID, base rule IDs select every rule expanded from them")
+	cmd.ForceSyncCmd.Flags().StringSlice("ip", nil, "Select rules to IPs inside these networks [10.0.0.0/8]")
+	cmd.ForceSyncCmd.Flags().StringSlice("dns", nil, "Select rules to DNS names, may be glob patterns [*.example.com]")
+	cmd.ForceSyncCmd.Flags().StringSlice("instance", nil, "Select rules of service instances")
+	cmd.ForceSyncCmd.Flags().StringSlice("pool", nil, "Select rules from or to Tsuru pools")
+	cmd.ForceSyncCmd.Flags().String("engine", "", "Select rules synced by an engine [acl-operator]")
+	cmd.ForceSyncCmd.Flags().Bool("failed", false, "Select rules whose latest sync failed, in --engine if set")
+	cmd.ForceSyncCmd.Flags().Bool("dry-run", false, "Only list the selected rules, without syncing them")
+	cmd.ForceSyncCmd.Flags().Int("concurrency", 4, "How many rules to sync at the same time")
+	cmd.ForceSyncCmd.Flags().Float64("rate", 0, "Maximum rules synced per second, 0 means unlimited")
+	cmd.ForceSyncCmd.Flags().Bool("wait", false, "Wait for every engine to report the result of the sync")
+	cmd.ForceSyncCmd.Flags().Duration("timeout", 2*time.Minute, "How long to wait for each rule to be synced with --wait")
+
+	cmd.SyncDNSCmd.Flags().String("service", client.DefaultServiceName, "ACL service name")
+	cmd.SyncDNSCmd.Flags().Int("concurrency", 4, "How many rules to sync at the same time")
+	cmd.SyncDNSCmd.Flags().Bool("wait", false, "Wait for every engine to report the result of the sync")
+	cmd.SyncDNSCmd.Flags().Duration("timeout", 2*time.Minute, "How long to wait for each rule to be synced with --wait")
+
+	cmd.AdminResyncFailedCmd.Flags().String("engine", "", "Only consider sync attempts of an engine [acl-operator]")
+	cmd.AdminResyncFailedCmd.Flags().Int("concurrency", 4, "How many rules to sync at the same time")
+	cmd.AdminResyncFailedCmd.Flags().Int("wave-size", 50, "How many rules to sync in each wave")
+	cmd.AdminResyncFailedCmd.Flags().Duration("pause", 30*time.Second, "How long to wait between waves")
+	cmd.AdminResyncFailedCmd.Flags().String("checkpoint", "acl-resync-failed.json", "File where progress is saved")
+	cmd.AdminResyncFailedCmd.Flags().Bool("resume", false, "Continue the run saved in the checkpoint file")
+	cmd.AdminResyncFailedCmd.Flags().Bool("dry-run", false, "Only list the failed rules, without syncing them")
+
+	cmd.RenderRuleCmd.Flags().AddFlagSet(dstFlags)
+	cmd.RenderRuleCmd.Flags().String("id", "", "Render an existing rule instead of the destination flags")
+	cmd.RenderRuleCmd.Flags().String("dns-server", "", "DNS server used to resolve DNS destinations instead of the system resolver [8.8.8.8:53]")
+	cmd.RenderRuleCmd.Flags().String("namespace", "", "Namespace of the policies, empty means the namespace of the kubectl context")
+	cmd.RenderRuleCmd.Flags().AddFlagSet(labelConventionFlags)
+
+	cmd.ImportRulesCmd.Flags().AddFlagSet(metadataFlags)
+	cmd.ImportRulesCmd.Flags().AddFlagSet(labelConventionFlags)
+	cmd.ImportRulesCmd.Flags().AddFlagSet(validationFlags)
+	cmd.ImportRulesCmd.Flags().String("from-netpol", "", "Kubernetes NetworkPolicy manifest to import, - reads from stdin [policy.yaml]")
+	cmd.ImportRulesCmd.Flags().String("from-aws-sg", "", "AWS security groups JSON to import, - reads from stdin [sg.json]")
+	cmd.ImportRulesCmd.Flags().String("from-gcp-firewall", "", "GCP firewall rules JSON to import, - reads from stdin [firewall.json]")
+	cmd.ImportRulesCmd.Flags().String("from-iptables", "", "iptables-save dump to import, - reads from stdin [rules.v4]")
+	cmd.ImportRulesCmd.Flags().String("iptables-chain", "OUTPUT", "iptables chain whose ACCEPT rules are imported")
+	cmd.ImportRulesCmd.Flags().String("from-manifest", "", "Manifest written by --manifest to import, - reads from stdin [rules.json]")
+	cmd.ImportRulesCmd.Flags().String("manifest", "", "Write the rules to a manifest instead of adding them [rules.json]")
+	cmd.ImportRulesCmd.Flags().Bool("dry-run", false, "Only show the rules that would be imported")
+
+	cmd.WaitRulesCmd.Flags().StringSlice("rule", nil, "Only wait for rules with these IDs")
+	cmd.WaitRulesCmd.Flags().StringSlice("for", []string{"synced"}, "Statuses to wait for: pending, synced, partial, failing, stale")
+	cmd.WaitRulesCmd.Flags().Duration("timeout", 5*time.Minute, "How long to wait for the rules")
+
+	cmd.ShowRuleCmd.Flags().String("since", "", "Only show sync attempts started after a duration ago or a time [24h]")
+	cmd.ShowRuleCmd.Flags().String("engine", "", "Only show sync attempts of an engine [acl-operator]")
+	cmd.ShowRuleCmd.Flags().Bool("json", false, "Return the raw JSON output instead of the formatted output")
 
 	cmd.ListRuleCmd.Flags().Bool("show-sync", false, "Show rules latest sync attempt")
 	cmd.ListRuleCmd.Flags().Bool("show-extra-sync", false, "Show rules with latest sync attempt details.")
 	cmd.ListRuleCmd.Flags().Bool("json", false, "Return the raw JSON output instead of the formatted table")
 	cmd.ListAllRulesCmd.Flags().Bool("json", false, "Return the raw JSON output instead of the formatted table")
 	cmd.ListAllRulesCmd.Flags().Bool("show-extra-sync", false, "Show rules with latest sync attempt details.")
+	cmd.ListRuleCmd.Flags().Bool("matrix", false, "Show the sync status of each rule in each engine as a table")
+	cmd.ListAllRulesCmd.Flags().Bool("matrix", false, "Show the sync status of each rule in each engine as a table")
+
+	cmd.RegisterCompletions()
 
-	if err := rootCmd.Execute(); err != nil {
+	ctx, stop := signalContext()
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
 		log.Fatal(err)
 	}
 }